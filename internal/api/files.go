@@ -0,0 +1,350 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/zhaoy17/ndid/internal/blob"
+	"github.com/zhaoy17/ndid/internal/document"
+	"github.com/zhaoy17/ndid/internal/schema"
+)
+
+// Serve the files of the documents:
+//
+//	GET, HEAD  /documents/{id}/files/{name}          download the file, honoring Range and ETag
+//	PUT        /documents/{id}/files/{name}          upload the whole file in the body, either
+//	                                                 raw or as the first part of a multipart form
+//	POST       /documents/{id}/files/{name}/uploads  start a resumable upload
+//	GET, HEAD  /uploads/{upload}                     get the offset of the upload
+//	PATCH      /uploads/{upload}                     append a chunk at Upload-Offset
+//	POST       /uploads/{upload}/complete            attach the uploaded file to the document
+//	DELETE     /uploads/{upload}                     abort the upload
+//
+// The SHA-256 digest of a whole file may be given with the Content-SHA256 header of a PUT
+// request, or the sha256 property of the body starting an upload. The one of a chunk may
+// be given with the Content-SHA256 header of a PATCH request. Data that does not match
+// its digest is rejected. Bodies are streamed to disk and never held in memory. The
+// X-Author and X-Change-Reason headers of the request attaching a file are recorded in the
+// history of the document.
+type FileHandler struct {
+	documents document.DIDDocumentRepository
+	schemas   document.SchemaResolver
+	uploads   *UploadSessions
+}
+
+// Header holding the hexadecimal SHA-256 digest of the body of a request
+const contentSHA256Header = "Content-SHA256"
+
+// Header holding the offset of a chunk, and the number of bytes received in responses
+const uploadOffsetHeader = "Upload-Offset"
+
+// Header holding the total size of an upload in responses, if known
+const uploadLengthHeader = "Upload-Length"
+
+// Headers recorded in the history of the document when a file is attached to it
+const authorHeader = "X-Author"
+const changeReasonHeader = "X-Change-Reason"
+
+// Body of the request starting an upload
+type createUploadRequest struct {
+	// Total size of the file in bytes, -1 or omitted if unknown
+	Size *int64 `json:"size"`
+
+	// Hexadecimal SHA-256 digest of the whole file, not checked if empty
+	SHA256 string `json:"sha256"`
+}
+
+// Error reported to the client with its HTTP status
+type httpError struct {
+	status  int
+	message string
+}
+
+func (err *httpError) Error() string {
+	return err.message
+}
+
+func NewFileHandler(documents document.DIDDocumentRepository, schemas document.SchemaResolver, uploads *UploadSessions) *FileHandler {
+	return &FileHandler{documents: documents, schemas: schemas, uploads: uploads}
+}
+
+func (handler *FileHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	segments := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	var err error
+	switch {
+	case len(segments) == 4 && segments[0] == "documents" && segments[2] == "files":
+		switch r.Method {
+		case http.MethodGet, http.MethodHead:
+			err = handler.download(w, r, segments[1], segments[3])
+		case http.MethodPut:
+			err = handler.upload(w, r, segments[1], segments[3])
+		default:
+			err = methodNotAllowed(w, http.MethodGet, http.MethodHead, http.MethodPut)
+		}
+	case len(segments) == 5 && segments[0] == "documents" && segments[2] == "files" && segments[4] == "uploads":
+		if r.Method != http.MethodPost {
+			err = methodNotAllowed(w, http.MethodPost)
+			break
+		}
+		err = handler.createUpload(w, r, segments[1], segments[3])
+	case len(segments) == 2 && segments[0] == "uploads":
+		switch r.Method {
+		case http.MethodGet, http.MethodHead:
+			err = handler.getUpload(w, r, segments[1])
+		case http.MethodPatch:
+			err = handler.appendUpload(w, r, segments[1])
+		case http.MethodDelete:
+			err = handler.deleteUpload(w, r, segments[1])
+		default:
+			err = methodNotAllowed(w, http.MethodGet, http.MethodHead, http.MethodPatch, http.MethodDelete)
+		}
+	case len(segments) == 3 && segments[0] == "uploads" && segments[2] == "complete":
+		if r.Method != http.MethodPost {
+			err = methodNotAllowed(w, http.MethodPost)
+			break
+		}
+		err = handler.completeUpload(w, r, segments[1])
+	default:
+		err = &httpError{status: http.StatusNotFound, message: fmt.Sprintf("%s not found", r.URL.Path)}
+	}
+	if err != nil {
+		writeError(w, err)
+	}
+}
+
+// Serve the content of the file. The blob key is the ETag, since it is the digest of the
+// content. http.ServeContent handles Range, If-Range and the conditional headers.
+func (handler *FileHandler) download(w http.ResponseWriter, r *http.Request, documentID string, fileName string) error {
+	content, info, err := handler.documents.OpenFile(documentID, fileName, r.Context())
+	if err != nil {
+		return err
+	}
+	defer content.Close()
+	w.Header().Set("ETag", `"`+info.Key+`"`)
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": fileName}))
+	http.ServeContent(w, r, "", info.ModTime, content)
+	return nil
+}
+
+// Upload a whole file in a single request, staged as an upload session so that the data
+// is verified before reaching the blob store
+func (handler *FileHandler) upload(w http.ResponseWriter, r *http.Request, documentID string, fileName string) error {
+	if err := handler.checkFile(documentID, fileName, r.Context()); err != nil {
+		return err
+	}
+	body, err := requestBody(r)
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+	session, err := handler.uploads.Create(documentID, fileName, -1, r.Header.Get(contentSHA256Header))
+	if err != nil {
+		return &httpError{status: http.StatusBadRequest, message: err.Error()}
+	}
+	// the session is only meant to live as long as the request
+	defer handler.uploads.Remove(session.ID)
+	if _, err := handler.uploads.Append(session.ID, 0, body, "", r.Context()); err != nil {
+		return err
+	}
+	info, err := handler.attachUpload(session.ID, changeFromRequest(r), r.Context())
+	if err != nil {
+		return err
+	}
+	writeJSON(w, http.StatusOK, info)
+	return nil
+}
+
+func (handler *FileHandler) createUpload(w http.ResponseWriter, r *http.Request, documentID string, fileName string) error {
+	if err := handler.checkFile(documentID, fileName, r.Context()); err != nil {
+		return err
+	}
+	req := &createUploadRequest{}
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(io.LimitReader(r.Body, 1<<16)).Decode(req); err != nil {
+			return &httpError{status: http.StatusBadRequest, message: fmt.Sprintf("invalid upload request: %v", err)}
+		}
+	}
+	size := int64(-1)
+	if req.Size != nil {
+		size = *req.Size
+	}
+	session, err := handler.uploads.Create(documentID, fileName, size, req.SHA256)
+	if err != nil {
+		return &httpError{status: http.StatusBadRequest, message: err.Error()}
+	}
+	w.Header().Set("Location", "/uploads/"+session.ID)
+	setUploadHeaders(w, session)
+	writeJSON(w, http.StatusCreated, session)
+	return nil
+}
+
+func (handler *FileHandler) getUpload(w http.ResponseWriter, r *http.Request, id string) error {
+	session, err := handler.uploads.Get(id)
+	if err != nil {
+		return err
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	setUploadHeaders(w, session)
+	if r.Method == http.MethodHead {
+		w.WriteHeader(http.StatusOK)
+		return nil
+	}
+	writeJSON(w, http.StatusOK, session)
+	return nil
+}
+
+// Append the body to the upload. On failure, the response still tells the client the
+// offset to resume from.
+func (handler *FileHandler) appendUpload(w http.ResponseWriter, r *http.Request, id string) error {
+	offset, err := strconv.ParseInt(r.Header.Get(uploadOffsetHeader), 10, 64)
+	if err != nil || offset < 0 {
+		return &httpError{status: http.StatusBadRequest, message: fmt.Sprintf("%s header must be a non-negative integer", uploadOffsetHeader)}
+	}
+	session, err := handler.uploads.Append(id, offset, r.Body, r.Header.Get(contentSHA256Header), r.Context())
+	if session != nil {
+		setUploadHeaders(w, session)
+	}
+	var offsetErr *OffsetMismatchError
+	if errors.As(err, &offsetErr) {
+		w.Header().Set(uploadOffsetHeader, strconv.FormatInt(offsetErr.Expected, 10))
+	}
+	if err != nil {
+		return err
+	}
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+func (handler *FileHandler) completeUpload(w http.ResponseWriter, r *http.Request, id string) error {
+	info, err := handler.attachUpload(id, changeFromRequest(r), r.Context())
+	if err != nil {
+		return err
+	}
+	writeJSON(w, http.StatusOK, info)
+	return nil
+}
+
+func (handler *FileHandler) deleteUpload(w http.ResponseWriter, r *http.Request, id string) error {
+	if err := handler.uploads.Remove(id); err != nil {
+		return err
+	}
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+// Store the data of the completed upload in the blob store, and attach it to the document
+func (handler *FileHandler) attachUpload(id string, change *document.NDIChange, ctx context.Context) (*blob.BlobInfo, error) {
+	var info *blob.BlobInfo
+	_, err := handler.uploads.Complete(id, func(session *UploadSession, r io.Reader) error {
+		var err error
+		info, err = handler.documents.StoreFile(session.DocumentID, session.FileName, r, change, ctx)
+		return err
+	}, ctx)
+	return info, err
+}
+
+// Check that the document exists and that its class declares the file, before receiving
+// any data for it
+func (handler *FileHandler) checkFile(documentID string, fileName string, ctx context.Context) error {
+	doc, err := handler.documents.GetDocument(documentID, ctx)
+	if err != nil {
+		return err
+	}
+	class, err := handler.schemas(doc.ClassName, ctx)
+	if err != nil {
+		return err
+	}
+	for _, file := range class.AllFiles() {
+		if file.FileName == fileName {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: %s does not declare file %s", document.ErrFileNotDeclared, class.SchemaName, fileName)
+}
+
+// Get the content of a PUT request: the body itself, or the first part holding a file if
+// the body is a multipart form. Parts are read as they arrive, unlike with ParseMultipartForm.
+func requestBody(r *http.Request) (io.ReadCloser, error) {
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil || !strings.HasPrefix(mediaType, "multipart/") {
+		return r.Body, nil
+	}
+	reader, err := r.MultipartReader()
+	if err != nil {
+		return nil, &httpError{status: http.StatusBadRequest, message: err.Error()}
+	}
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			return nil, &httpError{status: http.StatusBadRequest, message: "multipart body has no file"}
+		}
+		if err != nil {
+			return nil, &httpError{status: http.StatusBadRequest, message: err.Error()}
+		}
+		if part.FileName() != "" {
+			return part, nil
+		}
+		part.Close()
+	}
+}
+
+func changeFromRequest(r *http.Request) *document.NDIChange {
+	return &document.NDIChange{Author: r.Header.Get(authorHeader), Reason: r.Header.Get(changeReasonHeader)}
+}
+
+func setUploadHeaders(w http.ResponseWriter, session *UploadSession) {
+	w.Header().Set(uploadOffsetHeader, strconv.FormatInt(session.Offset, 10))
+	if session.Size >= 0 {
+		w.Header().Set(uploadLengthHeader, strconv.FormatInt(session.Size, 10))
+	}
+}
+
+func methodNotAllowed(w http.ResponseWriter, methods ...string) error {
+	w.Header().Set("Allow", strings.Join(methods, ", "))
+	return &httpError{status: http.StatusMethodNotAllowed, message: "method not allowed"}
+}
+
+// Write the body as JSON. Errors cannot be reported once the status is written, and
+// only mean that the client went away.
+func writeJSON(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
+
+// Write the error as {"message": ...} with the status matching its cause, or, for the
+// ValidationErrors of a document, as the list of failures with status 422
+func writeError(w http.ResponseWriter, err error) {
+	// failures of the fields of a document are reported in a structured body
+	var validationErrs schema.ValidationErrors
+	if errors.As(err, &validationErrs) {
+		writeJSON(w, http.StatusUnprocessableEntity, validationErrs)
+		return
+	}
+
+	status := http.StatusInternalServerError
+	var httpErr *httpError
+	var offsetErr *OffsetMismatchError
+	switch {
+	case errors.As(err, &httpErr):
+		status = httpErr.status
+	case errors.Is(err, document.ErrDocumentNotFound), errors.Is(err, document.ErrFileNotFound),
+		errors.Is(err, blob.ErrBlobNotFound), errors.Is(err, ErrUploadNotFound):
+		status = http.StatusNotFound
+	case errors.As(err, &offsetErr), errors.Is(err, ErrUploadBusy), errors.Is(err, ErrUploadIncomplete):
+		status = http.StatusConflict
+	case errors.Is(err, ErrUploadTooLarge):
+		status = http.StatusRequestEntityTooLarge
+	case errors.Is(err, ErrChecksumMismatch), errors.Is(err, document.ErrFileNotDeclared):
+		status = http.StatusUnprocessableEntity
+	}
+	writeJSON(w, status, map[string]string{"message": err.Error()})
+}
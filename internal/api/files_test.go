@@ -0,0 +1,234 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	dbsql "database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/zhaoy17/ndid/internal/blob"
+	dt "github.com/zhaoy17/ndid/internal/datatypes"
+	"github.com/zhaoy17/ndid/internal/document"
+	"github.com/zhaoy17/ndid/internal/schema"
+	sql "github.com/zhaoy17/ndid/internal/sql"
+)
+
+var baseClass = &schema.NDISchema{
+	SchemaName:   "base",
+	SchemaFields: []*schema.NDIField{{FieldName: "id", DataType: &dt.NDIIdentifier{}}},
+}
+
+var recordingClass = &schema.NDISchema{
+	SchemaName:   "recording",
+	Superclasses: []*schema.NDISchema{baseClass},
+	Files:        []*schema.NDIFile{{FileName: "data"}},
+}
+
+// Serve the files of a repository holding a single recording, and return the server along
+// with the identifier of the recording
+func newTestServer(t *testing.T) (*httptest.Server, *document.SQLDocumentRepository, string) {
+	t.Helper()
+	conn, err := dbsql.Open("sqlite3", filepath.Join(t.TempDir(), "documents.db")+"?_busy_timeout=5000")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db := sql.NewSqlDatabase(sql.SqlLite, conn, sql.DefaultStmtCacheSize)
+	t.Cleanup(func() { db.Close() })
+	blobs, err := blob.NewLocalBlobStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	classes := map[string]*schema.NDISchema{baseClass.SchemaName: baseClass, recordingClass.SchemaName: recordingClass}
+	resolver := func(className string, ctx context.Context) (*schema.NDISchema, error) {
+		class, ok := classes[className]
+		if !ok {
+			return nil, fmt.Errorf("unknown class %s", className)
+		}
+		return class, nil
+	}
+	documents := document.NewSQLDocumentRepository(db, resolver, blobs)
+	ctx := context.Background()
+	if err := documents.Setup(ctx); err != nil {
+		t.Fatal(err)
+	}
+	recording, err := document.NewNDIDocument(recordingClass, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := documents.InsertDocuments([]*document.NDIDocument{recording}, nil, ctx); err != nil {
+		t.Fatal(err)
+	}
+	uploads, err := NewUploadSessions(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(NewFileHandler(documents, resolver, uploads))
+	t.Cleanup(server.Close)
+	return server, documents, recording.ID
+}
+
+func doRequest(t *testing.T, method string, url string, body io.Reader, headers map[string]string) *http.Response {
+	t.Helper()
+	req, err := http.NewRequest(method, url, body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, value := range headers {
+		req.Header.Set(name, value)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { resp.Body.Close() })
+	return resp
+}
+
+func checkStatus(t *testing.T, resp *http.Response, want int) {
+	t.Helper()
+	if resp.StatusCode != want {
+		body, _ := io.ReadAll(resp.Body)
+		t.Fatalf("%s %s: got status %d (%s), want %d", resp.Request.Method, resp.Request.URL.Path, resp.StatusCode, body, want)
+	}
+}
+
+func digestOf(content string) string {
+	digest := sha256.Sum256([]byte(content))
+	return hex.EncodeToString(digest[:])
+}
+
+func TestPutAndDownloadFile(t *testing.T) {
+	server, documents, id := newTestServer(t)
+	url := server.URL + "/documents/" + id + "/files/data"
+	content := "0123456789abcdefghij"
+
+	resp := doRequest(t, http.MethodPut, url, strings.NewReader(content), map[string]string{authorHeader: "alice"})
+	checkStatus(t, resp, http.StatusOK)
+	info := &blob.BlobInfo{}
+	if err := json.NewDecoder(resp.Body).Decode(info); err != nil {
+		t.Fatal(err)
+	}
+	if info.Key != digestOf(content) || info.Size != int64(len(content)) {
+		t.Fatalf("got %+v", info)
+	}
+	versions, err := documents.ListVersions(id, context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if last := versions[len(versions)-1]; last.Author != "alice" {
+		t.Fatalf("attaching the file was recorded with author %q", last.Author)
+	}
+
+	resp = doRequest(t, http.MethodGet, url, nil, nil)
+	checkStatus(t, resp, http.StatusOK)
+	etag := `"` + info.Key + `"`
+	if got := resp.Header.Get("ETag"); got != etag {
+		t.Fatalf("got ETag %s, want %s", got, etag)
+	}
+	if body, _ := io.ReadAll(resp.Body); string(body) != content {
+		t.Fatalf("downloaded %q", body)
+	}
+
+	resp = doRequest(t, http.MethodGet, url, nil, map[string]string{"Range": "bytes=5-9"})
+	checkStatus(t, resp, http.StatusPartialContent)
+	if got := resp.Header.Get("Content-Range"); got != fmt.Sprintf("bytes 5-9/%d", len(content)) {
+		t.Fatalf("got Content-Range %s", got)
+	}
+	if body, _ := io.ReadAll(resp.Body); string(body) != "56789" {
+		t.Fatalf("downloaded range %q", body)
+	}
+
+	// a range is only served if the content still matches the ETag
+	resp = doRequest(t, http.MethodGet, url, nil, map[string]string{"Range": "bytes=5-9", "If-Range": `"stale"`})
+	checkStatus(t, resp, http.StatusOK)
+	resp = doRequest(t, http.MethodGet, url, nil, map[string]string{"Range": "bytes=100-"})
+	checkStatus(t, resp, http.StatusRequestedRangeNotSatisfiable)
+	resp = doRequest(t, http.MethodGet, url, nil, map[string]string{"If-None-Match": etag})
+	checkStatus(t, resp, http.StatusNotModified)
+
+	resp = doRequest(t, http.MethodHead, url, nil, nil)
+	checkStatus(t, resp, http.StatusOK)
+	if resp.ContentLength != int64(len(content)) || resp.Header.Get("ETag") != etag {
+		t.Fatalf("got length %d and ETag %s", resp.ContentLength, resp.Header.Get("ETag"))
+	}
+	if body, _ := io.ReadAll(resp.Body); len(body) != 0 {
+		t.Fatalf("HEAD returned a body of %d bytes", len(body))
+	}
+}
+
+func TestPutFileChecksSHA256(t *testing.T) {
+	server, _, id := newTestServer(t)
+	url := server.URL + "/documents/" + id + "/files/data"
+
+	resp := doRequest(t, http.MethodPut, url, strings.NewReader("corrupted"), map[string]string{contentSHA256Header: digestOf("original")})
+	checkStatus(t, resp, http.StatusUnprocessableEntity)
+	resp = doRequest(t, http.MethodGet, url, nil, nil)
+	checkStatus(t, resp, http.StatusNotFound)
+
+	resp = doRequest(t, http.MethodPut, url, strings.NewReader("original"), map[string]string{contentSHA256Header: digestOf("original")})
+	checkStatus(t, resp, http.StatusOK)
+	resp = doRequest(t, http.MethodPut, url, strings.NewReader("original"), map[string]string{contentSHA256Header: "not a digest"})
+	checkStatus(t, resp, http.StatusBadRequest)
+}
+
+func TestPutMultipartFile(t *testing.T) {
+	server, _, id := newTestServer(t)
+	url := server.URL + "/documents/" + id + "/files/data"
+
+	body := &bytes.Buffer{}
+	form := multipart.NewWriter(body)
+	if err := form.WriteField("comment", "not the file"); err != nil {
+		t.Fatal(err)
+	}
+	part, err := form.CreateFormFile("file", "recording.bin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	part.Write([]byte("multipart content"))
+	form.Close()
+	resp := doRequest(t, http.MethodPut, url, body, map[string]string{"Content-Type": form.FormDataContentType()})
+	checkStatus(t, resp, http.StatusOK)
+
+	resp = doRequest(t, http.MethodGet, url, nil, nil)
+	checkStatus(t, resp, http.StatusOK)
+	if got, _ := io.ReadAll(resp.Body); string(got) != "multipart content" {
+		t.Fatalf("downloaded %q", got)
+	}
+
+	body.Reset()
+	form = multipart.NewWriter(body)
+	form.WriteField("comment", "no file")
+	form.Close()
+	resp = doRequest(t, http.MethodPut, url, body, map[string]string{"Content-Type": form.FormDataContentType()})
+	checkStatus(t, resp, http.StatusBadRequest)
+}
+
+func TestFileRequestErrors(t *testing.T) {
+	server, _, id := newTestServer(t)
+	tests := []struct {
+		method string
+		path   string
+		want   int
+	}{
+		{http.MethodGet, "/documents/" + id + "/files/data", http.StatusNotFound},
+		{http.MethodPut, "/documents/" + id + "/files/undeclared", http.StatusUnprocessableEntity},
+		{http.MethodPut, "/documents/missing/files/data", http.StatusNotFound},
+		{http.MethodDelete, "/documents/" + id + "/files/data", http.StatusMethodNotAllowed},
+		{http.MethodGet, "/documents/" + id, http.StatusNotFound},
+	}
+	for _, test := range tests {
+		resp := doRequest(t, test.method, server.URL+test.path, strings.NewReader("content"), nil)
+		checkStatus(t, resp, test.want)
+	}
+}
@@ -0,0 +1,341 @@
+package api
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+var ErrUploadNotFound = errors.New("upload session not found")
+var ErrUploadBusy = errors.New("upload session is busy")
+var ErrUploadTooLarge = errors.New("upload exceeds the declared size")
+var ErrUploadIncomplete = errors.New("upload is incomplete")
+var ErrChecksumMismatch = errors.New("checksum mismatch")
+
+var uploadIDPattern = regexp.MustCompile(`^[0-9a-f]{32}$`)
+
+// Returned when a chunk is not sent at the current end of the upload, e.g. because the
+// client missed the acknowledgement of the previous chunk
+type OffsetMismatchError struct {
+	// Offset the chunk was sent at
+	Offset int64
+
+	// Number of bytes received so far, where the next chunk must start
+	Expected int64
+}
+
+func (err *OffsetMismatchError) Error() string {
+	return fmt.Sprintf("chunk sent at offset %d, but the upload is at offset %d", err.Offset, err.Expected)
+}
+
+// Upload of a file of a document, sent in any number of chunks
+type UploadSession struct {
+	ID         string `json:"id"`
+	DocumentID string `json:"document_id"`
+	FileName   string `json:"file_name"`
+
+	// Total size of the file in bytes, -1 if unknown until the upload is completed
+	Size int64 `json:"size"`
+
+	// Expected hexadecimal SHA-256 digest of the whole file, not checked if empty
+	SHA256 string `json:"sha256,omitempty"`
+
+	CreatedAt time.Time `json:"created_at"`
+
+	// Number of bytes received so far, computed from the staged data
+	Offset int64 `json:"offset"`
+}
+
+// Stage uploads in a directory until they are complete: the data received for each session
+// is kept in a file along with the description of the session, so that uploads can be
+// resumed after the connection, or the server, is interrupted. A session is used by one
+// request at a time.
+type UploadSessions struct {
+	dir string
+
+	mu   sync.Mutex
+	busy map[string]bool
+}
+
+// Create the sessions staged in dir, which is created if it does not exist
+func NewUploadSessions(dir string) (*UploadSessions, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	return &UploadSessions{dir: dir, busy: make(map[string]bool)}, nil
+}
+
+// Start the upload of a file of a document. size is -1 if unknown, and sha256 empty if the
+// digest of the file is not to be checked.
+func (sessions *UploadSessions) Create(documentID string, fileName string, size int64, sha256 string) (*UploadSession, error) {
+	if size < -1 {
+		return nil, fmt.Errorf("invalid upload size %d", size)
+	}
+	if sha256 != "" && !isSHA256(sha256) {
+		return nil, fmt.Errorf("%s is not a valid SHA-256 digest", sha256)
+	}
+	var random [16]byte
+	if _, err := rand.Read(random[:]); err != nil {
+		return nil, err
+	}
+	session := &UploadSession{
+		ID:         hex.EncodeToString(random[:]),
+		DocumentID: documentID,
+		FileName:   fileName,
+		Size:       size,
+		SHA256:     sha256,
+		CreatedAt:  time.Now().UTC(),
+	}
+	encoded, err := json.Marshal(session)
+	if err != nil {
+		return nil, err
+	}
+	part, err := os.OpenFile(sessions.partPath(session.ID), os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	if err := part.Close(); err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(sessions.infoPath(session.ID), encoded, 0o600); err != nil {
+		os.Remove(sessions.partPath(session.ID))
+		return nil, err
+	}
+	return session, nil
+}
+
+// Get the session along with the number of bytes received so far
+func (sessions *UploadSessions) Get(id string) (*UploadSession, error) {
+	if !uploadIDPattern.MatchString(id) {
+		return nil, fmt.Errorf("%w: %s", ErrUploadNotFound, id)
+	}
+	encoded, err := os.ReadFile(sessions.infoPath(id))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("%w: %s", ErrUploadNotFound, id)
+	}
+	if err != nil {
+		return nil, err
+	}
+	session := &UploadSession{}
+	if err := json.Unmarshal(encoded, session); err != nil {
+		return nil, fmt.Errorf("upload session %s: %w", id, err)
+	}
+	info, err := os.Stat(sessions.partPath(id))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("%w: %s", ErrUploadNotFound, id)
+	}
+	if err != nil {
+		return nil, err
+	}
+	session.Offset = info.Size()
+	return session, nil
+}
+
+// Append the chunk read from r to the upload, which must be at offset. If chunkSHA256 is
+// not empty, the chunk is discarded unless its digest matches. Otherwise, the data
+// received before r fails is kept, and the client resumes from the offset of the session.
+func (sessions *UploadSessions) Append(id string, offset int64, r io.Reader, chunkSHA256 string, ctx context.Context) (*UploadSession, error) {
+	if chunkSHA256 != "" && !isSHA256(chunkSHA256) {
+		return nil, fmt.Errorf("%s is not a valid SHA-256 digest", chunkSHA256)
+	}
+	if err := sessions.acquire(id); err != nil {
+		return nil, err
+	}
+	defer sessions.release(id)
+	session, err := sessions.Get(id)
+	if err != nil {
+		return nil, err
+	}
+	if offset != session.Offset {
+		return nil, &OffsetMismatchError{Offset: offset, Expected: session.Offset}
+	}
+
+	part, err := os.OpenFile(sessions.partPath(id), os.O_WRONLY, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	defer part.Close()
+	if _, err := part.Seek(offset, io.SeekStart); err != nil {
+		return nil, err
+	}
+	src := io.Reader(&contextReader{r: r, ctx: ctx})
+	if session.Size >= 0 {
+		// read one byte past the declared size to detect oversized uploads
+		src = io.LimitReader(src, session.Size-offset+1)
+	}
+	hash := sha256.New()
+	written, copyErr := io.Copy(io.MultiWriter(part, hash), src)
+
+	discard := copyErr != nil && chunkSHA256 != ""
+	if session.Size >= 0 && offset+written > session.Size {
+		discard, copyErr = true, fmt.Errorf("%w of %d bytes", ErrUploadTooLarge, session.Size)
+	}
+	if copyErr == nil && chunkSHA256 != "" && hex.EncodeToString(hash.Sum(nil)) != chunkSHA256 {
+		discard, copyErr = true, fmt.Errorf("%w: chunk at offset %d does not match %s", ErrChecksumMismatch, offset, chunkSHA256)
+	}
+	if discard {
+		written = 0
+		if err := part.Truncate(offset); err != nil {
+			return nil, err
+		}
+	}
+	if err := part.Sync(); err != nil {
+		return nil, err
+	}
+	session.Offset = offset + written
+	return session, copyErr
+}
+
+// Check that the upload is complete and matches its digest, then pass the staged data to
+// fn, e.g. to store it in a blob store. The session is removed if fn succeeds.
+func (sessions *UploadSessions) Complete(id string, fn func(session *UploadSession, r io.Reader) error, ctx context.Context) (*UploadSession, error) {
+	if err := sessions.acquire(id); err != nil {
+		return nil, err
+	}
+	defer sessions.release(id)
+	session, err := sessions.Get(id)
+	if err != nil {
+		return nil, err
+	}
+	if session.Size >= 0 && session.Offset != session.Size {
+		return nil, fmt.Errorf("%w: received %d of %d bytes", ErrUploadIncomplete, session.Offset, session.Size)
+	}
+	if session.SHA256 != "" {
+		digest, err := sessions.digest(id, ctx)
+		if err != nil {
+			return nil, err
+		}
+		if digest != session.SHA256 {
+			return nil, fmt.Errorf("%w: received file has digest %s, expected %s", ErrChecksumMismatch, digest, session.SHA256)
+		}
+	}
+	part, err := os.Open(sessions.partPath(id))
+	if err != nil {
+		return nil, err
+	}
+	err = fn(session, &contextReader{r: part, ctx: ctx})
+	part.Close()
+	if err != nil {
+		return nil, err
+	}
+	return session, sessions.remove(id)
+}
+
+// Abort the upload and discard the data received
+func (sessions *UploadSessions) Remove(id string) error {
+	if err := sessions.acquire(id); err != nil {
+		return err
+	}
+	defer sessions.release(id)
+	if _, err := sessions.Get(id); err != nil {
+		return err
+	}
+	return sessions.remove(id)
+}
+
+// Remove the sessions created before maxAge ago, and return their identifiers. Sessions in
+// use are skipped.
+func (sessions *UploadSessions) RemoveExpired(maxAge time.Duration) ([]string, error) {
+	entries, err := os.ReadDir(sessions.dir)
+	if err != nil {
+		return nil, err
+	}
+	removed := make([]string, 0)
+	for _, entry := range entries {
+		id, isInfo := strings.CutSuffix(entry.Name(), ".json")
+		if !isInfo || !uploadIDPattern.MatchString(id) {
+			continue
+		}
+		session, err := sessions.Get(id)
+		if err != nil || time.Since(session.CreatedAt) < maxAge {
+			continue
+		}
+		if err := sessions.Remove(id); err == nil {
+			removed = append(removed, id)
+		}
+	}
+	return removed, nil
+}
+
+func (sessions *UploadSessions) acquire(id string) error {
+	sessions.mu.Lock()
+	defer sessions.mu.Unlock()
+	if sessions.busy[id] {
+		return fmt.Errorf("%w: %s", ErrUploadBusy, id)
+	}
+	sessions.busy[id] = true
+	return nil
+}
+
+func (sessions *UploadSessions) release(id string) {
+	sessions.mu.Lock()
+	defer sessions.mu.Unlock()
+	delete(sessions.busy, id)
+}
+
+func (sessions *UploadSessions) remove(id string) error {
+	// remove the description first, so that a failure leaves no session without data
+	if err := os.Remove(sessions.infoPath(id)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	if err := os.Remove(sessions.partPath(id)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// Compute the hexadecimal SHA-256 digest of the data staged for the session
+func (sessions *UploadSessions) digest(id string, ctx context.Context) (string, error) {
+	part, err := os.Open(sessions.partPath(id))
+	if err != nil {
+		return "", err
+	}
+	defer part.Close()
+	hash := sha256.New()
+	if _, err := io.Copy(hash, &contextReader{r: part, ctx: ctx}); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+func (sessions *UploadSessions) infoPath(id string) string {
+	return filepath.Join(sessions.dir, id+".json")
+}
+
+func (sessions *UploadSessions) partPath(id string) string {
+	return filepath.Join(sessions.dir, id+".part")
+}
+
+func isSHA256(digest string) bool {
+	if len(digest) != sha256.Size*2 {
+		return false
+	}
+	_, err := hex.DecodeString(digest)
+	return err == nil && digest == strings.ToLower(digest)
+}
+
+// Reader failing once the context is done, so that long copies stop when the client
+// goes away
+type contextReader struct {
+	r   io.Reader
+	ctx context.Context
+}
+
+func (reader *contextReader) Read(p []byte) (int, error) {
+	if err := reader.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return reader.r.Read(p)
+}
@@ -0,0 +1,182 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Start an upload of the file of the recording, and return its URL
+func createTestUpload(t *testing.T, server string, id string, body string) string {
+	t.Helper()
+	resp := doRequest(t, http.MethodPost, server+"/documents/"+id+"/files/data/uploads", strings.NewReader(body), nil)
+	checkStatus(t, resp, http.StatusCreated)
+	session := &UploadSession{}
+	if err := json.NewDecoder(resp.Body).Decode(session); err != nil {
+		t.Fatal(err)
+	}
+	if location := resp.Header.Get("Location"); location != "/uploads/"+session.ID {
+		t.Fatalf("got Location %s for upload %s", location, session.ID)
+	}
+	return server + "/uploads/" + session.ID
+}
+
+func appendChunk(t *testing.T, url string, offset int, chunk string, headers map[string]string) *http.Response {
+	t.Helper()
+	if headers == nil {
+		headers = make(map[string]string)
+	}
+	headers[uploadOffsetHeader] = strconv.Itoa(offset)
+	return doRequest(t, http.MethodPatch, url, strings.NewReader(chunk), headers)
+}
+
+func checkOffset(t *testing.T, resp *http.Response, want int) {
+	t.Helper()
+	if got := resp.Header.Get(uploadOffsetHeader); got != strconv.Itoa(want) {
+		t.Fatalf("got %s %s, want %d", uploadOffsetHeader, got, want)
+	}
+}
+
+// A chunk sent at the wrong offset is rejected with the offset to resume from
+func TestResumeUpload(t *testing.T) {
+	server, _, id := newTestServer(t)
+	content := "first chunk|second chunk"
+	url := createTestUpload(t, server.URL, id, `{"size": 24, "sha256": "`+digestOf(content)+`"}`)
+
+	resp := appendChunk(t, url, 0, "first chunk|", nil)
+	checkStatus(t, resp, http.StatusNoContent)
+	checkOffset(t, resp, 12)
+
+	// the acknowledgement was lost and the client sends the chunk again
+	resp = appendChunk(t, url, 0, "first chunk|", nil)
+	checkStatus(t, resp, http.StatusConflict)
+	checkOffset(t, resp, 12)
+
+	resp = doRequest(t, http.MethodHead, url, nil, nil)
+	checkStatus(t, resp, http.StatusOK)
+	checkOffset(t, resp, 12)
+	if got := resp.Header.Get(uploadLengthHeader); got != "24" {
+		t.Fatalf("got %s %s", uploadLengthHeader, got)
+	}
+	resp = doRequest(t, http.MethodPost, url+"/complete", nil, nil)
+	checkStatus(t, resp, http.StatusConflict)
+
+	resp = appendChunk(t, url, 12, "second chunk", nil)
+	checkStatus(t, resp, http.StatusNoContent)
+	checkOffset(t, resp, 24)
+	resp = doRequest(t, http.MethodPost, url+"/complete", nil, nil)
+	checkStatus(t, resp, http.StatusOK)
+
+	resp = doRequest(t, http.MethodGet, server.URL+"/documents/"+id+"/files/data", nil, nil)
+	checkStatus(t, resp, http.StatusOK)
+	if body, _ := io.ReadAll(resp.Body); string(body) != content {
+		t.Fatalf("downloaded %q", body)
+	}
+	// the session is removed once completed
+	resp = doRequest(t, http.MethodGet, url, nil, nil)
+	checkStatus(t, resp, http.StatusNotFound)
+}
+
+func TestUploadChecksSHA256(t *testing.T) {
+	server, _, id := newTestServer(t)
+	url := createTestUpload(t, server.URL, id, `{"sha256": "`+digestOf("expected")+`"}`)
+
+	// a chunk not matching its digest is discarded
+	resp := appendChunk(t, url, 0, "corrupted", map[string]string{contentSHA256Header: digestOf("unexpected")})
+	checkStatus(t, resp, http.StatusUnprocessableEntity)
+	checkOffset(t, resp, 0)
+	resp = appendChunk(t, url, 0, "unexpected", map[string]string{contentSHA256Header: digestOf("unexpected")})
+	checkStatus(t, resp, http.StatusNoContent)
+	checkOffset(t, resp, 10)
+
+	// so is the whole file
+	resp = doRequest(t, http.MethodPost, url+"/complete", nil, nil)
+	checkStatus(t, resp, http.StatusUnprocessableEntity)
+	resp = doRequest(t, http.MethodGet, server.URL+"/documents/"+id+"/files/data", nil, nil)
+	checkStatus(t, resp, http.StatusNotFound)
+
+	resp = doRequest(t, http.MethodDelete, url, nil, nil)
+	checkStatus(t, resp, http.StatusNoContent)
+	resp = doRequest(t, http.MethodGet, url, nil, nil)
+	checkStatus(t, resp, http.StatusNotFound)
+}
+
+// Data past the declared size is rejected along with the rest of the chunk
+func TestUploadTooLarge(t *testing.T) {
+	server, _, id := newTestServer(t)
+	url := createTestUpload(t, server.URL, id, `{"size": 8}`)
+
+	resp := appendChunk(t, url, 0, "1234", nil)
+	checkStatus(t, resp, http.StatusNoContent)
+	resp = appendChunk(t, url, 4, "56789", nil)
+	checkStatus(t, resp, http.StatusRequestEntityTooLarge)
+	checkOffset(t, resp, 4)
+	resp = appendChunk(t, url, 4, "5678", nil)
+	checkStatus(t, resp, http.StatusNoContent)
+	checkOffset(t, resp, 8)
+	resp = doRequest(t, http.MethodPost, url+"/complete", nil, nil)
+	checkStatus(t, resp, http.StatusOK)
+}
+
+func TestUploadRequestErrors(t *testing.T) {
+	server, _, id := newTestServer(t)
+	url := createTestUpload(t, server.URL, id, "")
+
+	resp := doRequest(t, http.MethodPatch, url, strings.NewReader("chunk"), nil)
+	checkStatus(t, resp, http.StatusBadRequest)
+	resp = appendChunk(t, server.URL+"/uploads/"+strings.Repeat("0", 32), 0, "chunk", nil)
+	checkStatus(t, resp, http.StatusNotFound)
+	resp = appendChunk(t, server.URL+"/uploads/../uploads", 0, "chunk", nil)
+	checkStatus(t, resp, http.StatusNotFound)
+	resp = doRequest(t, http.MethodPost, server.URL+"/documents/"+id+"/files/data/uploads", strings.NewReader(`{"size": -2}`), nil)
+	checkStatus(t, resp, http.StatusBadRequest)
+	resp = doRequest(t, http.MethodPost, server.URL+"/documents/"+id+"/files/undeclared/uploads", nil, nil)
+	checkStatus(t, resp, http.StatusUnprocessableEntity)
+}
+
+func TestRemoveExpiredUploads(t *testing.T) {
+	sessions, err := NewUploadSessions(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	expired, err := sessions.Create("document", "data", -1, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	recent, err := sessions.Create("document", "data", -1, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// backdate the first session
+	expired.CreatedAt = expired.CreatedAt.Add(-2 * time.Hour)
+	encoded, err := json.Marshal(expired)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(sessions.infoPath(expired.ID), encoded, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(sessions.infoPath("not-an-upload"), encoded, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	removed, err := sessions.RemoveExpired(time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(removed) != 1 || removed[0] != expired.ID {
+		t.Fatalf("removed %v, want %s", removed, expired.ID)
+	}
+	if _, err := sessions.Get(expired.ID); !errors.Is(err, ErrUploadNotFound) {
+		t.Fatalf("got error %v, want ErrUploadNotFound", err)
+	}
+	if _, err := sessions.Get(recent.ID); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,162 @@
+package blob
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// Store blobs as files under a root directory. Each blob is stored under a directory named
+// after the first two characters of its key, to keep directories small. Content is written
+// to a temporary file first and renamed once its digest is known, so that readers never
+// see a partial blob.
+type LocalBlobStore struct {
+	root string
+}
+
+// Create a store keeping its blobs under root, which is created if it does not exist
+func NewLocalBlobStore(root string) (*LocalBlobStore, error) {
+	if err := os.MkdirAll(filepath.Join(root, "tmp"), 0o755); err != nil {
+		return nil, err
+	}
+	return &LocalBlobStore{root: root}, nil
+}
+
+func (store *LocalBlobStore) Put(r io.Reader, ctx context.Context) (*BlobInfo, error) {
+	tmp, err := os.CreateTemp(filepath.Join(store.root, "tmp"), "upload-*")
+	if err != nil {
+		return nil, err
+	}
+	// no-op once the file has been renamed
+	defer os.Remove(tmp.Name())
+
+	hash := sha256.New()
+	size, err := io.Copy(io.MultiWriter(tmp, hash), &contextReader{r: r, ctx: ctx})
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	key := hex.EncodeToString(hash.Sum(nil))
+	path := store.path(key)
+	if info, err := os.Stat(path); err == nil {
+		// the content is already stored
+		return &BlobInfo{Key: key, Size: info.Size(), ModTime: info.ModTime()}, nil
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(tmp.Name(), 0o444); err != nil {
+		return nil, err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return nil, err
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	return &BlobInfo{Key: key, Size: size, ModTime: info.ModTime()}, nil
+}
+
+func (store *LocalBlobStore) Open(key string, ctx context.Context) (io.ReadSeekCloser, *BlobInfo, error) {
+	if !ValidKey(key) {
+		return nil, nil, fmt.Errorf("%w: %s", ErrBlobNotFound, key)
+	}
+	file, err := os.Open(store.path(key))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil, fmt.Errorf("%w: %s", ErrBlobNotFound, key)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, nil, err
+	}
+	return file, &BlobInfo{Key: key, Size: info.Size(), ModTime: info.ModTime()}, nil
+}
+
+func (store *LocalBlobStore) Stat(key string, ctx context.Context) (*BlobInfo, error) {
+	if !ValidKey(key) {
+		return nil, fmt.Errorf("%w: %s", ErrBlobNotFound, key)
+	}
+	info, err := os.Stat(store.path(key))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("%w: %s", ErrBlobNotFound, key)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &BlobInfo{Key: key, Size: info.Size(), ModTime: info.ModTime()}, nil
+}
+
+func (store *LocalBlobStore) Delete(key string, ctx context.Context) error {
+	if !ValidKey(key) {
+		return fmt.Errorf("invalid blob key %s", key)
+	}
+	err := os.Remove(store.path(key))
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+func (store *LocalBlobStore) List(fn func(info *BlobInfo) error, ctx context.Context) error {
+	return filepath.WalkDir(store.root, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if entry.IsDir() {
+			if path != store.root && filepath.Dir(path) != store.root {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		// skip the temporary files of uploads in progress
+		key := entry.Name()
+		if !ValidKey(key) || filepath.Base(filepath.Dir(path)) != key[:2] {
+			return nil
+		}
+		info, err := entry.Info()
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		return fn(&BlobInfo{Key: key, Size: info.Size(), ModTime: info.ModTime()})
+	})
+}
+
+func (store *LocalBlobStore) path(key string) string {
+	return filepath.Join(store.root, key[:2], key)
+}
+
+// Reader failing once the context is done, so that long copies can be cancelled
+type contextReader struct {
+	r   io.Reader
+	ctx context.Context
+}
+
+func (reader *contextReader) Read(p []byte) (int, error) {
+	if err := reader.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return reader.r.Read(p)
+}
@@ -0,0 +1,208 @@
+package blob
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func newTestLocalStore(t *testing.T) *LocalBlobStore {
+	t.Helper()
+	store, err := NewLocalBlobStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return store
+}
+
+func TestLocalPutAndOpen(t *testing.T) {
+	store := newTestLocalStore(t)
+	ctx := context.Background()
+	content := "recording"
+	info, err := store.Put(strings.NewReader(content), ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	digest := sha256.Sum256([]byte(content))
+	if info.Key != hex.EncodeToString(digest[:]) || info.Size != int64(len(content)) {
+		t.Fatalf("got %+v", info)
+	}
+	file, opened, err := store.Open(info.Key, ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	got, err := io.ReadAll(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != content || opened.Size != info.Size {
+		t.Fatalf("read %q, %+v", got, opened)
+	}
+	if _, _, err := store.Open(strings.Repeat("0", 64), ctx); !errors.Is(err, ErrBlobNotFound) {
+		t.Fatalf("got error %v, want ErrBlobNotFound", err)
+	}
+	if _, err := store.Stat("../../etc/passwd", ctx); !errors.Is(err, ErrBlobNotFound) {
+		t.Fatalf("got error %v, want ErrBlobNotFound for an invalid key", err)
+	}
+}
+
+// Storing the same content twice keeps a single copy
+func TestLocalPutDeduplicates(t *testing.T) {
+	store := newTestLocalStore(t)
+	ctx := context.Background()
+	first, err := store.Put(strings.NewReader("same"), ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := store.Put(strings.NewReader("same"), ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first.Key != second.Key || first.Size != second.Size {
+		t.Fatalf("got %+v and %+v", first, second)
+	}
+	entries, err := os.ReadDir(filepath.Join(store.root, first.Key[:2]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Fatalf("got %d files, want a single copy", len(entries))
+	}
+	if tmp, _ := os.ReadDir(filepath.Join(store.root, "tmp")); len(tmp) != 0 {
+		t.Fatalf("left %d temporary files", len(tmp))
+	}
+}
+
+// Reader failing once part of the content has been read
+type failingReader struct {
+	r   io.Reader
+	err error
+}
+
+func (reader *failingReader) Read(p []byte) (int, error) {
+	n, err := reader.r.Read(p)
+	if err == io.EOF {
+		return n, reader.err
+	}
+	return n, err
+}
+
+// Content is only visible under its key once it has been completely written
+func TestLocalPutIsAtomic(t *testing.T) {
+	store := newTestLocalStore(t)
+	ctx := context.Background()
+	failure := errors.New("connection reset")
+	if _, err := store.Put(&failingReader{r: strings.NewReader("partial"), err: failure}, ctx); !errors.Is(err, failure) {
+		t.Fatalf("got error %v, want %v", err, failure)
+	}
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	if _, err := store.Put(strings.NewReader("cancelled"), cancelled); !errors.Is(err, context.Canceled) {
+		t.Fatalf("got error %v, want context.Canceled", err)
+	}
+
+	entries, err := os.ReadDir(store.root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].Name() != "tmp" {
+		t.Fatalf("got %v, want only the directory of temporary files", entries)
+	}
+	if tmp, _ := os.ReadDir(filepath.Join(store.root, "tmp")); len(tmp) != 0 {
+		t.Fatalf("left %d temporary files", len(tmp))
+	}
+
+	// a successful upload is written read-only under its key, in one rename
+	info, err := store.Put(strings.NewReader("complete"), ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stat, err := os.Stat(store.path(info.Key))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stat.Mode().Perm()&0o222 != 0 {
+		t.Fatalf("blob is writable: %v", stat.Mode())
+	}
+}
+
+func TestLocalDelete(t *testing.T) {
+	store := newTestLocalStore(t)
+	ctx := context.Background()
+	info, err := store.Put(strings.NewReader("deleted"), ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		if err := store.Delete(info.Key, ctx); err != nil {
+			t.Fatalf("delete %d: %v", i+1, err)
+		}
+	}
+	if _, err := store.Stat(info.Key, ctx); !errors.Is(err, ErrBlobNotFound) {
+		t.Fatalf("got error %v, want ErrBlobNotFound", err)
+	}
+	if err := store.Delete("not-a-key", ctx); err == nil {
+		t.Fatalf("deleted an invalid key")
+	}
+}
+
+// Uploads in progress and files that are not blobs are not listed
+func TestLocalList(t *testing.T) {
+	store := newTestLocalStore(t)
+	ctx := context.Background()
+	want := make([]string, 0)
+	for _, content := range []string{"a", "b", "c"} {
+		info, err := store.Put(strings.NewReader(content), ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want = append(want, info.Key)
+	}
+	stray := want[0]
+	files := map[string]string{
+		filepath.Join("tmp", "upload-123"): "in progress",
+		filepath.Join("tmp", stray):        "in progress under a valid key",
+		filepath.Join("ff", stray):         "valid key in the wrong directory",
+		filepath.Join(stray[:2], "notes"):  "not a key",
+		"README":                           "not a blob",
+	}
+	for name, content := range files {
+		path := filepath.Join(store.root, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	got := make([]string, 0)
+	err := store.List(func(info *BlobInfo) error {
+		if info.Size != 1 || info.ModTime.IsZero() {
+			t.Errorf("got %+v", info)
+		}
+		got = append(got, info.Key)
+		return nil
+	}, ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(got)
+	sort.Strings(want)
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Fatalf("listed %v, want %v", got, want)
+	}
+
+	stop := errors.New("stop")
+	if err := store.List(func(info *BlobInfo) error { return stop }, ctx); !errors.Is(err, stop) {
+		t.Fatalf("got error %v, want %v", err, stop)
+	}
+}
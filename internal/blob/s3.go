@@ -0,0 +1,516 @@
+package blob
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Default size of the parts of multipart uploads
+const defaultS3PartSize = 16 << 20
+
+// Minimum size of the parts of multipart uploads, but the last one, imposed by S3
+const minS3PartSize = 5 << 20
+
+// Maximum number of parts of a multipart upload, imposed by S3
+const maxS3Parts = 10000
+
+// Default number of bytes requested at once when reading an object
+const defaultS3ReadSize = 8 << 20
+
+// Configuration of S3BlobStore
+type S3Config struct {
+	// URL of the S3 service, e.g. https://s3.us-east-1.amazonaws.com or
+	// http://localhost:9000 for MinIO
+	Endpoint string
+
+	Region string
+	Bucket string
+
+	// Prepended to the names of the objects, e.g. "ndi/blobs/"
+	Prefix string
+
+	AccessKeyID     string
+	SecretAccessKey string
+
+	// Token of temporary credentials, ignored if empty
+	SessionToken string
+
+	// Weather or not the bucket is addressed in the path of the URLs rather than in the host
+	// name, as needed by most S3-compatible services
+	PathStyle bool
+
+	// Size of the parts of multipart uploads. Content smaller than that is uploaded in a
+	// single request. Default to 16 MiB. Larger parts are used for content that would take
+	// more than 10,000 parts.
+	PartSize int64
+
+	// Maximum number of bytes requested at once when reading an object, so that a client
+	// reading part of it does not make the service send the rest. Default to 8 MiB.
+	ReadSize int64
+
+	// Directory content is staged in while its digest is computed. Default to the temporary
+	// directory of the system.
+	TempDir string
+
+	// Default to http.DefaultClient
+	HTTPClient *http.Client
+}
+
+// Store blobs as objects of an S3 bucket, laid out like LocalBlobStore under the prefix.
+// Since the key of a blob is only known once its content has been read, Put stages the
+// content in a temporary file, and skips the upload if the bucket already has the object.
+// Requests are signed with AWS Signature Version 4.
+type S3BlobStore struct {
+	config   S3Config
+	endpoint *url.URL
+	client   *http.Client
+	signer   *sigV4Signer
+}
+
+// Error returned by the S3 service
+type S3Error struct {
+	StatusCode int
+	Code       string `xml:"Code"`
+	Message    string `xml:"Message"`
+}
+
+func (err *S3Error) Error() string {
+	if err.Code == "" {
+		return fmt.Sprintf("s3 request failed with status %d", err.StatusCode)
+	}
+	return fmt.Sprintf("s3 request failed with status %d: %s: %s", err.StatusCode, err.Code, err.Message)
+}
+
+type initiateMultipartUploadResult struct {
+	UploadID string `xml:"UploadId"`
+}
+
+type completeMultipartUpload struct {
+	XMLName xml.Name          `xml:"CompleteMultipartUpload"`
+	Parts   []completedS3Part `xml:"Part"`
+}
+
+type completedS3Part struct {
+	PartNumber int    `xml:"PartNumber"`
+	ETag       string `xml:"ETag"`
+}
+
+type listBucketResult struct {
+	Contents              []listedS3Object `xml:"Contents"`
+	IsTruncated           bool             `xml:"IsTruncated"`
+	NextContinuationToken string           `xml:"NextContinuationToken"`
+}
+
+type listedS3Object struct {
+	Key          string    `xml:"Key"`
+	Size         int64     `xml:"Size"`
+	LastModified time.Time `xml:"LastModified"`
+}
+
+func NewS3BlobStore(config S3Config) (*S3BlobStore, error) {
+	endpoint, err := url.Parse(config.Endpoint)
+	if err != nil || endpoint.Scheme == "" || endpoint.Host == "" {
+		return nil, fmt.Errorf("invalid s3 endpoint %q", config.Endpoint)
+	}
+	if config.Bucket == "" {
+		return nil, fmt.Errorf("s3 bucket is required")
+	}
+	if config.Region == "" {
+		return nil, fmt.Errorf("s3 region is required")
+	}
+	if config.PartSize == 0 {
+		config.PartSize = defaultS3PartSize
+	}
+	if config.PartSize < minS3PartSize {
+		return nil, fmt.Errorf("s3 part size cannot be smaller than %d bytes", minS3PartSize)
+	}
+	if config.ReadSize == 0 {
+		config.ReadSize = defaultS3ReadSize
+	}
+	if config.ReadSize < 0 {
+		return nil, fmt.Errorf("invalid s3 read size %d", config.ReadSize)
+	}
+	if config.Prefix != "" && !strings.HasSuffix(config.Prefix, "/") {
+		config.Prefix += "/"
+	}
+	client := config.HTTPClient
+	if client == nil {
+		client = http.DefaultClient
+	}
+	return &S3BlobStore{
+		config:   config,
+		endpoint: endpoint,
+		client:   client,
+		signer: &sigV4Signer{
+			accessKeyID:     config.AccessKeyID,
+			secretAccessKey: config.SecretAccessKey,
+			sessionToken:    config.SessionToken,
+			region:          config.Region,
+			service:         "s3",
+		},
+	}, nil
+}
+
+func (store *S3BlobStore) Put(r io.Reader, ctx context.Context) (*BlobInfo, error) {
+	tmp, err := os.CreateTemp(store.config.TempDir, "ndi-blob-*")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	hash := sha256.New()
+	size, err := io.Copy(io.MultiWriter(tmp, hash), &contextReader{r: r, ctx: ctx})
+	if err != nil {
+		return nil, err
+	}
+	key := hex.EncodeToString(hash.Sum(nil))
+	if info, err := store.Stat(key, ctx); err == nil {
+		// the content is already stored
+		return info, nil
+	} else if !errors.Is(err, ErrBlobNotFound) {
+		return nil, err
+	}
+
+	if size <= store.config.PartSize {
+		err = store.putObject(key, io.NewSectionReader(tmp, 0, size), size, ctx)
+	} else {
+		err = store.putMultipart(key, tmp, size, ctx)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return store.Stat(key, ctx)
+}
+
+// Open the object for reading. The content is fetched with ranged GET requests of up to
+// ReadSize bytes as it is read, starting over from the new offset after each seek.
+func (store *S3BlobStore) Open(key string, ctx context.Context) (io.ReadSeekCloser, *BlobInfo, error) {
+	info, err := store.Stat(key, ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	return &s3Object{store: store, key: key, size: info.Size, ctx: ctx}, info, nil
+}
+
+func (store *S3BlobStore) Stat(key string, ctx context.Context) (*BlobInfo, error) {
+	if !ValidKey(key) {
+		return nil, fmt.Errorf("%w: %s", ErrBlobNotFound, key)
+	}
+	resp, err := store.do(http.MethodHead, key, nil, nil, nil, nil, ctx)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+	size, err := strconv.ParseInt(resp.Header.Get("Content-Length"), 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("s3 object %s has no valid Content-Length", key)
+	}
+	modTime, _ := http.ParseTime(resp.Header.Get("Last-Modified"))
+	return &BlobInfo{Key: key, Size: size, ModTime: modTime}, nil
+}
+
+func (store *S3BlobStore) Delete(key string, ctx context.Context) error {
+	if !ValidKey(key) {
+		return fmt.Errorf("invalid blob key %s", key)
+	}
+	resp, err := store.do(http.MethodDelete, key, nil, nil, nil, nil, ctx)
+	if errors.Is(err, ErrBlobNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// List the objects under the prefix with ListObjectsV2, a page of up to 1000 at a time,
+// skipping the ones not named like blobs
+func (store *S3BlobStore) List(fn func(info *BlobInfo) error, ctx context.Context) error {
+	query := url.Values{"list-type": {"2"}, "prefix": {store.config.Prefix}}
+	for {
+		result := &listBucketResult{}
+		if err := store.doXML(http.MethodGet, "", query, nil, result, ctx); err != nil {
+			return err
+		}
+		for _, object := range result.Contents {
+			dir, key := path.Split(strings.TrimPrefix(object.Key, store.config.Prefix))
+			if !ValidKey(key) || dir != key[:2]+"/" {
+				continue
+			}
+			if err := fn(&BlobInfo{Key: key, Size: object.Size, ModTime: object.LastModified}); err != nil {
+				return err
+			}
+		}
+		if !result.IsTruncated || result.NextContinuationToken == "" {
+			return nil
+		}
+		query.Set("continuation-token", result.NextContinuationToken)
+	}
+}
+
+// Get a URL the blob can be downloaded from without credentials until expires has
+// elapsed, which S3 limits to 7 days
+func (store *S3BlobStore) PresignGet(key string, expires time.Duration) (string, error) {
+	if !ValidKey(key) {
+		return "", fmt.Errorf("invalid blob key %s", key)
+	}
+	if expires <= 0 || expires > 7*24*time.Hour {
+		return "", fmt.Errorf("expiration of presigned URLs must be between 1 second and 7 days")
+	}
+	return store.signer.presign(store.objectURL(key, nil), expires, time.Now()), nil
+}
+
+func (store *S3BlobStore) putObject(key string, body io.ReadSeeker, size int64, ctx context.Context) error {
+	payloadHash, err := readerSHA256(body)
+	if err != nil {
+		return err
+	}
+	resp, err := store.do(http.MethodPut, key, nil, nil, body, &requestPayload{size: size, hash: payloadHash}, ctx)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// Upload the content in parts of PartSize bytes, or more if that would take more than
+// maxS3Parts, aborting the upload on failure so that the bucket does not keep the parts
+func (store *S3BlobStore) putMultipart(key string, content io.ReaderAt, size int64, ctx context.Context) error {
+	initiated := &initiateMultipartUploadResult{}
+	if err := store.doXML(http.MethodPost, key, url.Values{"uploads": {""}}, nil, initiated, ctx); err != nil {
+		return err
+	}
+	uploadQuery := url.Values{"uploadId": {initiated.UploadID}}
+	basePartSize := s3PartSize(store.config.PartSize, size)
+
+	complete := &completeMultipartUpload{}
+	err := func() error {
+		for offset, partNumber := int64(0), 1; offset < size; offset, partNumber = offset+basePartSize, partNumber+1 {
+			partSize := basePartSize
+			if offset+partSize > size {
+				partSize = size - offset
+			}
+			part := io.NewSectionReader(content, offset, partSize)
+			payloadHash, err := readerSHA256(part)
+			if err != nil {
+				return err
+			}
+			query := url.Values{"partNumber": {strconv.Itoa(partNumber)}, "uploadId": {initiated.UploadID}}
+			resp, err := store.do(http.MethodPut, key, query, nil, part, &requestPayload{size: partSize, hash: payloadHash}, ctx)
+			if err != nil {
+				return fmt.Errorf("uploading part %d: %w", partNumber, err)
+			}
+			resp.Body.Close()
+			complete.Parts = append(complete.Parts, completedS3Part{PartNumber: partNumber, ETag: resp.Header.Get("ETag")})
+		}
+		body, err := xml.Marshal(complete)
+		if err != nil {
+			return err
+		}
+		// S3 may report a failure in the body of a 200 response
+		result := &S3Error{}
+		if err := store.doXML(http.MethodPost, key, uploadQuery, body, result, ctx); err != nil {
+			return err
+		}
+		if result.Code != "" {
+			result.StatusCode = http.StatusOK
+			return result
+		}
+		return nil
+	}()
+	if err != nil {
+		// use a fresh context, since the failure may come from the cancellation of ctx
+		abortCtx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+		if resp, abortErr := store.do(http.MethodDelete, key, uploadQuery, nil, nil, nil, abortCtx); abortErr == nil {
+			resp.Body.Close()
+		}
+		return err
+	}
+	return nil
+}
+
+// Get the size of the parts content of the given size is uploaded in: partSize, or the
+// smallest size fitting the content in maxS3Parts parts if it is larger
+func s3PartSize(partSize int64, size int64) int64 {
+	if minSize := (size + maxS3Parts - 1) / maxS3Parts; minSize > partSize {
+		return minSize
+	}
+	return partSize
+}
+
+// Body of a request along with its size and hexadecimal SHA-256 digest
+type requestPayload struct {
+	size int64
+	hash string
+}
+
+// Send a signed request for the object of the blob. Return ErrBlobNotFound if the object
+// does not exist, and S3Error if the request fails otherwise.
+func (store *S3BlobStore) do(method string, key string, query url.Values, header http.Header, body io.Reader, payload *requestPayload, ctx context.Context) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, store.objectURL(key, query).String(), body)
+	if err != nil {
+		return nil, err
+	}
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	payloadHash := sha256Hex(nil)
+	if payload != nil {
+		req.ContentLength = payload.size
+		payloadHash = payload.hash
+	}
+	store.signer.sign(req, payloadHash, time.Now())
+	resp, err := store.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode < 300 {
+		return resp, nil
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound && query == nil {
+		return nil, fmt.Errorf("%w: %s", ErrBlobNotFound, key)
+	}
+	s3Err := &S3Error{}
+	xml.NewDecoder(io.LimitReader(resp.Body, 1<<16)).Decode(s3Err)
+	s3Err.StatusCode = resp.StatusCode
+	return nil, s3Err
+}
+
+// Send a signed request with an XML body, and decode the XML response into result
+func (store *S3BlobStore) doXML(method string, key string, query url.Values, body []byte, result interface{}, ctx context.Context) error {
+	resp, err := store.do(method, key, query, nil, bytes.NewReader(body), &requestPayload{size: int64(len(body)), hash: sha256Hex(body)}, ctx)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if err := xml.NewDecoder(resp.Body).Decode(result); err != nil {
+		return fmt.Errorf("invalid s3 response: %w", err)
+	}
+	return nil
+}
+
+// Get the URL of the object of the blob, in path style or virtual-hosted style, or the one
+// of the bucket if key is empty
+func (store *S3BlobStore) objectURL(key string, query url.Values) *url.URL {
+	objectName := ""
+	if key != "" {
+		objectName = store.config.Prefix + key[:2] + "/" + key
+	}
+	u := *store.endpoint
+	basePath := strings.TrimSuffix(u.Path, "/")
+	if store.config.PathStyle {
+		u.Path = basePath + "/" + store.config.Bucket + "/" + objectName
+	} else {
+		u.Host = store.config.Bucket + "." + u.Host
+		u.Path = basePath + "/" + objectName
+	}
+	u.RawPath = ""
+	u.RawQuery = canonicalQuery(query)
+	return &u
+}
+
+// Compute the hexadecimal SHA-256 digest of the content, and rewind it
+func readerSHA256(r io.ReadSeeker) (string, error) {
+	hash := sha256.New()
+	if _, err := io.Copy(hash, r); err != nil {
+		return "", err
+	}
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// Object of an S3BlobStore opened for reading
+type s3Object struct {
+	store  *S3BlobStore
+	key    string
+	size   int64
+	ctx    context.Context
+	offset int64
+
+	// Body of the pending GET request, nil until the next read
+	body io.ReadCloser
+	// Offset the range of the pending GET request ends at, excluded
+	end int64
+}
+
+func (object *s3Object) Read(p []byte) (int, error) {
+	if object.offset >= object.size {
+		return 0, io.EOF
+	}
+	if object.body == nil {
+		object.end = object.offset + object.store.config.ReadSize
+		if object.end > object.size {
+			object.end = object.size
+		}
+		header := http.Header{"Range": {fmt.Sprintf("bytes=%d-%d", object.offset, object.end-1)}}
+		resp, err := object.store.do(http.MethodGet, object.key, nil, header, nil, nil, object.ctx)
+		if err != nil {
+			return 0, err
+		}
+		object.body = resp.Body
+	}
+	if remaining := object.end - object.offset; int64(len(p)) > remaining {
+		p = p[:remaining]
+	}
+	n, err := object.body.Read(p)
+	object.offset += int64(n)
+	if object.offset >= object.end && err == nil {
+		err = io.EOF
+	}
+	if err == io.EOF && object.offset < object.size {
+		// the range was read or the response ended early, the next read starts a new request
+		object.body.Close()
+		object.body = nil
+		err = nil
+	}
+	return n, err
+}
+
+func (object *s3Object) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += object.offset
+	case io.SeekEnd:
+		offset += object.size
+	default:
+		return 0, fmt.Errorf("invalid whence %d", whence)
+	}
+	if offset < 0 {
+		return 0, fmt.Errorf("negative offset %d", offset)
+	}
+	if offset != object.offset && object.body != nil {
+		object.body.Close()
+		object.body = nil
+	}
+	object.offset = offset
+	return offset, nil
+}
+
+func (object *s3Object) Close() error {
+	if object.body == nil {
+		return nil
+	}
+	err := object.body.Close()
+	object.body = nil
+	return err
+}
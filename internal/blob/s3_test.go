@@ -0,0 +1,586 @@
+package blob
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+const fakeS3Bucket = "bucket"
+
+// In-process stand-in for S3, serving a single bucket in path style. Every request must be
+// signed with AWS Signature Version 4 by the credentials of signer, either in its
+// Authorization header or, for GET requests, in its query string.
+type fakeS3 struct {
+	signer *sigV4Signer
+
+	// Number of objects listed per page
+	pageSize int
+
+	// Number of the part failing to upload, if not zero
+	failPart int
+
+	mu      sync.Mutex
+	objects map[string]*fakeS3Object
+	uploads map[string]map[int][]byte
+	nextID  int
+
+	// Number of requests received, by method
+	requests map[string]int
+
+	// Range headers of the GET requests for objects
+	ranges []string
+
+	// Number of parts of the last completed multipart upload
+	completedParts int
+	aborted        int
+}
+
+type fakeS3Object struct {
+	data    []byte
+	modTime time.Time
+}
+
+type fakeListBucketResult struct {
+	XMLName               xml.Name           `xml:"ListBucketResult"`
+	Contents              []fakeListedObject `xml:"Contents"`
+	IsTruncated           bool               `xml:"IsTruncated"`
+	NextContinuationToken string             `xml:"NextContinuationToken,omitempty"`
+}
+
+type fakeListedObject struct {
+	Key          string `xml:"Key"`
+	Size         int    `xml:"Size"`
+	LastModified string `xml:"LastModified"`
+}
+
+func newFakeS3(t *testing.T) (*fakeS3, *httptest.Server) {
+	fake := &fakeS3{
+		signer:   &sigV4Signer{accessKeyID: "AKIAFAKE", secretAccessKey: "fake-secret", region: "us-east-1", service: "s3"},
+		pageSize: 1000,
+		objects:  make(map[string]*fakeS3Object),
+		uploads:  make(map[string]map[int][]byte),
+		requests: make(map[string]int),
+	}
+	server := httptest.NewServer(fake)
+	t.Cleanup(server.Close)
+	return fake, server
+}
+
+// Create a store using the fake with the given credentials
+func newFakeS3Store(t *testing.T, server *httptest.Server, secretAccessKey string) *S3BlobStore {
+	t.Helper()
+	store, err := NewS3BlobStore(S3Config{
+		Endpoint:        server.URL,
+		Region:          "us-east-1",
+		Bucket:          fakeS3Bucket,
+		Prefix:          "ndi",
+		AccessKeyID:     "AKIAFAKE",
+		SecretAccessKey: secretAccessKey,
+		PathStyle:       true,
+		TempDir:         t.TempDir(),
+		HTTPClient:      server.Client(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return store
+}
+
+func (fake *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		fakeS3Error(w, http.StatusBadRequest, "IncompleteBody", err.Error())
+		return
+	}
+	if err := fake.verify(r, body); err != nil {
+		fakeS3Error(w, http.StatusForbidden, "SignatureDoesNotMatch", err.Error())
+		return
+	}
+	objectName, ok := strings.CutPrefix(r.URL.Path, "/"+fakeS3Bucket+"/")
+	if !ok {
+		fakeS3Error(w, http.StatusNotFound, "NoSuchBucket", r.URL.Path)
+		return
+	}
+
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+	fake.requests[r.Method]++
+	query := r.URL.Query()
+	switch {
+	case objectName == "" && r.Method == http.MethodGet && query.Get("list-type") == "2":
+		fake.list(w, query)
+	case r.Method == http.MethodPost && query.Has("uploads"):
+		fake.nextID++
+		id := fmt.Sprintf("upload-%d", fake.nextID)
+		fake.uploads[id] = make(map[int][]byte)
+		writeFakeS3XML(w, &struct {
+			XMLName  xml.Name `xml:"InitiateMultipartUploadResult"`
+			UploadID string   `xml:"UploadId"`
+		}{UploadID: id})
+	case r.Method == http.MethodPut && query.Has("uploadId"):
+		parts, ok := fake.uploads[query.Get("uploadId")]
+		if !ok {
+			fakeS3Error(w, http.StatusNotFound, "NoSuchUpload", query.Get("uploadId"))
+			return
+		}
+		partNumber, err := strconv.Atoi(query.Get("partNumber"))
+		if err != nil || partNumber < 1 || partNumber > maxS3Parts {
+			fakeS3Error(w, http.StatusBadRequest, "InvalidArgument", "invalid part number")
+			return
+		}
+		if partNumber == fake.failPart {
+			fakeS3Error(w, http.StatusInternalServerError, "InternalError", "part failed")
+			return
+		}
+		parts[partNumber] = body
+		w.Header().Set("ETag", fmt.Sprintf(`"%s"`, sha256Hex(body)))
+	case r.Method == http.MethodPost && query.Has("uploadId"):
+		fake.complete(w, objectName, query.Get("uploadId"), body)
+	case r.Method == http.MethodDelete && query.Has("uploadId"):
+		delete(fake.uploads, query.Get("uploadId"))
+		fake.aborted++
+		w.WriteHeader(http.StatusNoContent)
+	case r.Method == http.MethodPut:
+		fake.objects[objectName] = &fakeS3Object{data: body, modTime: time.Now()}
+	case r.Method == http.MethodGet || r.Method == http.MethodHead:
+		object, ok := fake.objects[objectName]
+		if !ok {
+			if r.Method == http.MethodHead {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			fakeS3Error(w, http.StatusNotFound, "NoSuchKey", objectName)
+			return
+		}
+		if r.Method == http.MethodGet {
+			fake.ranges = append(fake.ranges, r.Header.Get("Range"))
+		}
+		http.ServeContent(w, r, "", object.modTime, bytes.NewReader(object.data))
+	case r.Method == http.MethodDelete:
+		delete(fake.objects, objectName)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		fakeS3Error(w, http.StatusMethodNotAllowed, "MethodNotAllowed", r.Method)
+	}
+}
+
+// Check the signature of the request, and that the body matches the digest it was signed
+// with
+func (fake *fakeS3) verify(r *http.Request, body []byte) error {
+	query := r.URL.Query()
+	if signature := query.Get("X-Amz-Signature"); signature != "" {
+		if r.Method != http.MethodGet {
+			return fmt.Errorf("only GET requests may be presigned")
+		}
+		query.Del("X-Amz-Signature")
+		canonicalRequest := strings.Join([]string{
+			r.Method,
+			canonicalURI(r.URL),
+			canonicalQuery(query),
+			"host:" + r.Host + "\n",
+			"host",
+			unsignedPayload,
+		}, "\n")
+		return fake.checkSignature(canonicalRequest, query.Get("X-Amz-Date"), query.Get("X-Amz-Credential"), signature)
+	}
+
+	authorization, ok := strings.CutPrefix(r.Header.Get("Authorization"), sigV4Algorithm+" ")
+	if !ok {
+		return fmt.Errorf("request is not signed")
+	}
+	fields := make(map[string]string)
+	for _, field := range strings.Split(authorization, ", ") {
+		name, val, _ := strings.Cut(field, "=")
+		fields[name] = val
+	}
+	payloadHash := r.Header.Get("X-Amz-Content-Sha256")
+	if payloadHash != sha256Hex(body) {
+		return fmt.Errorf("body does not match X-Amz-Content-Sha256")
+	}
+	var canonicalHeaders strings.Builder
+	for _, name := range strings.Split(fields["SignedHeaders"], ";") {
+		val := r.Host
+		if name != "host" {
+			val = strings.Join(r.Header.Values(name), ",")
+		}
+		canonicalHeaders.WriteString(name + ":" + strings.TrimSpace(val) + "\n")
+	}
+	canonicalRequest := strings.Join([]string{
+		r.Method,
+		canonicalURI(r.URL),
+		canonicalQuery(query),
+		canonicalHeaders.String(),
+		fields["SignedHeaders"],
+		payloadHash,
+	}, "\n")
+	return fake.checkSignature(canonicalRequest, r.Header.Get("X-Amz-Date"), fields["Credential"], fields["Signature"])
+}
+
+func (fake *fakeS3) checkSignature(canonicalRequest string, amzDate string, credential string, signature string) error {
+	now, err := time.Parse(amzDateLayout, amzDate)
+	if err != nil {
+		return fmt.Errorf("invalid X-Amz-Date %q", amzDate)
+	}
+	scope := fake.signer.scope(now)
+	if credential != fake.signer.accessKeyID+"/"+scope {
+		return fmt.Errorf("unexpected credential %s", credential)
+	}
+	if want := fake.signer.signature(canonicalRequest, amzDate, scope, now); signature != want {
+		return fmt.Errorf("signature %s does not match", signature)
+	}
+	return nil
+}
+
+func (fake *fakeS3) list(w http.ResponseWriter, query url.Values) {
+	names := make([]string, 0)
+	for name := range fake.objects {
+		if strings.HasPrefix(name, query.Get("prefix")) && name > query.Get("continuation-token") {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	result := &fakeListBucketResult{}
+	if len(names) > fake.pageSize {
+		names = names[:fake.pageSize]
+		result.IsTruncated = true
+		result.NextContinuationToken = names[len(names)-1]
+	}
+	for _, name := range names {
+		object := fake.objects[name]
+		result.Contents = append(result.Contents, fakeListedObject{
+			Key:          name,
+			Size:         len(object.data),
+			LastModified: object.modTime.UTC().Format("2006-01-02T15:04:05.000Z"),
+		})
+	}
+	writeFakeS3XML(w, result)
+}
+
+func (fake *fakeS3) complete(w http.ResponseWriter, objectName string, uploadID string, body []byte) {
+	parts, ok := fake.uploads[uploadID]
+	if !ok {
+		fakeS3Error(w, http.StatusNotFound, "NoSuchUpload", uploadID)
+		return
+	}
+	completed := &completeMultipartUpload{}
+	if err := xml.Unmarshal(body, completed); err != nil {
+		fakeS3Error(w, http.StatusBadRequest, "MalformedXML", err.Error())
+		return
+	}
+	var data []byte
+	for i, part := range completed.Parts {
+		content, ok := parts[part.PartNumber]
+		if !ok || part.PartNumber != i+1 || part.ETag != fmt.Sprintf(`"%s"`, sha256Hex(content)) {
+			fakeS3Error(w, http.StatusBadRequest, "InvalidPart", fmt.Sprintf("part %d", part.PartNumber))
+			return
+		}
+		data = append(data, content...)
+	}
+	delete(fake.uploads, uploadID)
+	fake.objects[objectName] = &fakeS3Object{data: data, modTime: time.Now()}
+	fake.completedParts = len(completed.Parts)
+	writeFakeS3XML(w, &struct {
+		XMLName xml.Name `xml:"CompleteMultipartUploadResult"`
+		Key     string   `xml:"Key"`
+	}{Key: objectName})
+}
+
+func writeFakeS3XML(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/xml")
+	xml.NewEncoder(w).Encode(v)
+}
+
+func fakeS3Error(w http.ResponseWriter, status int, code string, message string) {
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(status)
+	xml.NewEncoder(w).Encode(&struct {
+		XMLName xml.Name `xml:"Error"`
+		Code    string   `xml:"Code"`
+		Message string   `xml:"Message"`
+	}{Code: code, Message: message})
+}
+
+func TestS3PutAndOpen(t *testing.T) {
+	fake, server := newFakeS3(t)
+	store := newFakeS3Store(t, server, "fake-secret")
+	ctx := context.Background()
+
+	content := "the quick brown fox jumps over the lazy dog"
+	info, err := store.Put(strings.NewReader(content), ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Key != sha256Hex([]byte(content)) || info.Size != int64(len(content)) {
+		t.Fatalf("got %+v", info)
+	}
+	if _, ok := fake.objects["ndi/"+info.Key[:2]+"/"+info.Key]; !ok {
+		t.Fatalf("object not stored under the prefix: %v", fake.objects)
+	}
+
+	// storing the same content again only checks that it exists
+	puts := fake.requests[http.MethodPut]
+	if _, err := store.Put(strings.NewReader(content), ctx); err != nil {
+		t.Fatal(err)
+	}
+	if fake.requests[http.MethodPut] != puts {
+		t.Fatal("content already stored was uploaded again")
+	}
+
+	object, _, err := store.Open(info.Key, ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer object.Close()
+	data, err := io.ReadAll(object)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != content {
+		t.Fatalf("got %q", data)
+	}
+}
+
+func TestS3OpenRange(t *testing.T) {
+	fake, server := newFakeS3(t)
+	store := newFakeS3Store(t, server, "fake-secret")
+	store.config.ReadSize = 8
+	ctx := context.Background()
+	content := "0123456789abcdefghij"
+	info, err := store.Put(strings.NewReader(content), ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	object, _, err := store.Open(info.Key, ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer object.Close()
+
+	for _, offset := range []int64{15, 3, 0} {
+		if _, err := object.Seek(offset, io.SeekStart); err != nil {
+			t.Fatal(err)
+		}
+		buf := make([]byte, 4)
+		if _, err := io.ReadFull(object, buf); err != nil {
+			t.Fatal(err)
+		}
+		if want := content[offset : offset+4]; string(buf) != want {
+			t.Fatalf("read %q at offset %d, want %q", buf, offset, want)
+		}
+	}
+	if _, err := object.Seek(-2, io.SeekEnd); err != nil {
+		t.Fatal(err)
+	}
+	rest, err := io.ReadAll(object)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(rest) != "ij" {
+		t.Fatalf("read %q at the end", rest)
+	}
+	if _, err := object.Seek(0, io.SeekStart); err != nil {
+		t.Fatal(err)
+	}
+	all, err := io.ReadAll(object)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(all) != content {
+		t.Fatalf("read %q", all)
+	}
+
+	// every request is bounded by ReadSize and the end of the object
+	want := []string{
+		"bytes=15-19", "bytes=3-10", "bytes=0-7", "bytes=18-19",
+		"bytes=0-7", "bytes=8-15", "bytes=16-19",
+	}
+	if strings.Join(fake.ranges, ",") != strings.Join(want, ",") {
+		t.Fatalf("requested ranges %v, want %v", fake.ranges, want)
+	}
+}
+
+func TestS3Multipart(t *testing.T) {
+	fake, server := newFakeS3(t)
+	store := newFakeS3Store(t, server, "fake-secret")
+	// smaller than S3 allows, to keep the content small
+	store.config.PartSize = 5
+	ctx := context.Background()
+
+	content := "a multipart upload of 33 bytes.."
+	info, err := store.Put(strings.NewReader(content), ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fake.completedParts != 7 {
+		t.Fatalf("uploaded %d parts, want 7", fake.completedParts)
+	}
+	if got := string(fake.objects["ndi/"+info.Key[:2]+"/"+info.Key].data); got != content {
+		t.Fatalf("stored %q", got)
+	}
+}
+
+func TestS3MultipartPartLimit(t *testing.T) {
+	fake, server := newFakeS3(t)
+	store := newFakeS3Store(t, server, "fake-secret")
+	store.config.PartSize = 1
+	ctx := context.Background()
+
+	content := bytes.Repeat([]byte("0123456789"), 2*maxS3Parts+1)
+	info, err := store.Put(bytes.NewReader(content), ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fake.completedParts > maxS3Parts {
+		t.Fatalf("uploaded %d parts, more than S3 allows", fake.completedParts)
+	}
+	if !bytes.Equal(fake.objects["ndi/"+info.Key[:2]+"/"+info.Key].data, content) {
+		t.Fatal("stored content does not match")
+	}
+}
+
+func TestS3PartSize(t *testing.T) {
+	tests := []struct {
+		partSize int64
+		size     int64
+		want     int64
+	}{
+		{defaultS3PartSize, 1 << 30, defaultS3PartSize},
+		{defaultS3PartSize, defaultS3PartSize * maxS3Parts, defaultS3PartSize},
+		{defaultS3PartSize, defaultS3PartSize*maxS3Parts + 1, defaultS3PartSize + 1},
+		{minS3PartSize, 5 << 40, (5<<40 + maxS3Parts - 1) / maxS3Parts},
+	}
+	for _, test := range tests {
+		got := s3PartSize(test.partSize, test.size)
+		if got != test.want {
+			t.Errorf("s3PartSize(%d, %d) = %d, want %d", test.partSize, test.size, got, test.want)
+		}
+		if parts := (test.size + got - 1) / got; parts > maxS3Parts {
+			t.Errorf("%d bytes take %d parts of %d bytes", test.size, parts, got)
+		}
+	}
+}
+
+func TestS3MultipartAbortedOnFailure(t *testing.T) {
+	fake, server := newFakeS3(t)
+	fake.failPart = 2
+	store := newFakeS3Store(t, server, "fake-secret")
+	store.config.PartSize = 5
+	if _, err := store.Put(strings.NewReader("fails on its second part"), context.Background()); err == nil {
+		t.Fatal("upload succeeded although a part failed")
+	}
+	if fake.aborted != 1 || len(fake.uploads) != 0 {
+		t.Fatalf("upload not aborted: %d aborted, %d left", fake.aborted, len(fake.uploads))
+	}
+}
+
+func TestS3StatNotFound(t *testing.T) {
+	_, server := newFakeS3(t)
+	store := newFakeS3Store(t, server, "fake-secret")
+	ctx := context.Background()
+	key := sha256Hex([]byte("missing"))
+	if _, err := store.Stat(key, ctx); !errors.Is(err, ErrBlobNotFound) {
+		t.Fatalf("got %v, want ErrBlobNotFound", err)
+	}
+	if _, _, err := store.Open(key, ctx); !errors.Is(err, ErrBlobNotFound) {
+		t.Fatalf("got %v, want ErrBlobNotFound", err)
+	}
+}
+
+func TestS3Delete(t *testing.T) {
+	_, server := newFakeS3(t)
+	store := newFakeS3Store(t, server, "fake-secret")
+	ctx := context.Background()
+	info, err := store.Put(strings.NewReader("to be deleted"), ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Delete(info.Key, ctx); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Stat(info.Key, ctx); !errors.Is(err, ErrBlobNotFound) {
+		t.Fatalf("got %v after deletion, want ErrBlobNotFound", err)
+	}
+	if err := store.Delete(info.Key, ctx); err != nil {
+		t.Fatalf("deleting a missing blob failed: %v", err)
+	}
+}
+
+func TestS3List(t *testing.T) {
+	fake, server := newFakeS3(t)
+	fake.pageSize = 2
+	store := newFakeS3Store(t, server, "fake-secret")
+	ctx := context.Background()
+	want := make([]string, 0)
+	for i := 0; i < 5; i++ {
+		info, err := store.Put(strings.NewReader(fmt.Sprintf("blob %d", i)), ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want = append(want, info.Key)
+	}
+	fake.objects["ndi/notes.txt"] = &fakeS3Object{modTime: time.Now()}
+	fake.objects["other/"+want[0][:2]+"/"+want[0]] = &fakeS3Object{modTime: time.Now()}
+
+	got := make([]string, 0)
+	err := store.List(func(info *BlobInfo) error {
+		if info.ModTime.IsZero() {
+			t.Errorf("blob %s has no modification time", info.Key)
+		}
+		got = append(got, info.Key)
+		return nil
+	}, ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(got)
+	sort.Strings(want)
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Fatalf("listed %v, want %v", got, want)
+	}
+}
+
+func TestS3PresignGet(t *testing.T) {
+	_, server := newFakeS3(t)
+	store := newFakeS3Store(t, server, "fake-secret")
+	content := "downloaded without credentials"
+	info, err := store.Put(strings.NewReader(content), context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	u, err := store.PresignGet(info.Key, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := server.Client().Get(u)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	data, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK || string(data) != content {
+		t.Fatalf("got status %d and %q", resp.StatusCode, data)
+	}
+}
+
+func TestS3RejectsWrongCredentials(t *testing.T) {
+	_, server := newFakeS3(t)
+	store := newFakeS3Store(t, server, "wrong-secret")
+	_, err := store.Put(strings.NewReader("content"), context.Background())
+	var s3Err *S3Error
+	if !errors.As(err, &s3Err) || s3Err.StatusCode != http.StatusForbidden {
+		t.Fatalf("got %v, want a 403 S3Error", err)
+	}
+}
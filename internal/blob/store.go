@@ -0,0 +1,61 @@
+package blob
+
+import (
+	"context"
+	"errors"
+	"io"
+	"regexp"
+	"time"
+)
+
+var ErrBlobNotFound = errors.New("blob not found")
+
+// Blobs are keyed by the hexadecimal SHA-256 digest of their content
+var blobKeyPattern = regexp.MustCompile(`^[0-9a-f]{64}$`)
+
+// Store binary content addressed by its SHA-256 digest, so that storing the same content
+// twice keeps a single copy
+type BlobStore interface {
+	// Store the content read from r, and return its key along with its size
+	Put(r io.Reader, ctx context.Context) (*BlobInfo, error)
+
+	// Open the blob for reading. Return ErrBlobNotFound if there is no blob with the key.
+	Open(key string, ctx context.Context) (io.ReadSeekCloser, *BlobInfo, error)
+
+	// Get the size and modification time of the blob. Return ErrBlobNotFound if there is no
+	// blob with the key.
+	Stat(key string, ctx context.Context) (*BlobInfo, error)
+
+	// Delete the blob. Deleting a blob that does not exist is not an error.
+	Delete(key string, ctx context.Context) error
+}
+
+type BlobInfo struct {
+	// Hexadecimal SHA-256 digest of the content
+	Key string `json:"key"`
+
+	// Size of the content in bytes
+	Size int64 `json:"size"`
+
+	ModTime time.Time `json:"mod_time"`
+}
+
+// Check if key is a valid blob key: a SHA-256 digest written in lowercase hexadecimal
+func ValidKey(key string) bool {
+	return blobKeyPattern.MatchString(key)
+}
+
+// Implemented by the blob stores able to hand out URLs to download blobs from directly
+type BlobPresigner interface {
+	// Get a URL the blob can be downloaded from without credentials until expires has
+	// elapsed
+	PresignGet(key string, expires time.Duration) (string, error)
+}
+
+// Implemented by the blob stores able to enumerate their blobs, which is needed to find
+// the ones no document refers to
+type BlobLister interface {
+	// Call fn with each blob of the store, in no particular order, stopping at the first
+	// error fn returns
+	List(fn func(info *BlobInfo) error, ctx context.Context) error
+}
@@ -0,0 +1,259 @@
+package validator
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	sqldb "github.com/zhaoy17/ndid/internal/sql"
+)
+
+// Represent a vector for DIDDocument's field, written as a JSON array whose elements are
+// validated with ElementType. A bare scalar is accepted as a vector of one element, since
+// that is how MATLAB encodes them. Stored as JSON, and queryable with SQLHasMember.
+type NDIArray struct {
+	ElementType NDIDataType
+
+	// Required number of elements, ignored if 0
+	Len int
+
+	// Minimum number of elements, ignored if 0
+	MinLen int
+
+	// Maximum number of elements, ignored if 0
+	MaxLen int
+}
+
+// Create NDIArray from the parameters of a field in the schema definition, which hold the
+// registered name of the element type, optionally followed by a colon and the parameters
+// of the element type, e.g. "integer:0,100". Elements are not checked if empty.
+func NewNDIArray(parameters string) (*NDIArray, error) {
+	elementType, err := newElementType(parameters)
+	if err != nil {
+		return nil, err
+	}
+	return &NDIArray{ElementType: elementType}, nil
+}
+
+func (array *NDIArray) ToSqlDataType() (sqldb.SqlDataType, error) {
+	return &sqldb.SqlJSON{NotNull: false}, nil
+}
+
+func (array *NDIArray) Validate(val string) error {
+	_, err := array.parse(val)
+	return err
+}
+
+// Convert the value to the JSON stored in the database, with scalars wrapped in an array
+// and numbers written in a canonical form so that SQLHasMember can match them
+func (array *NDIArray) Normalize(val string) (string, error) {
+	elements, err := array.parse(val)
+	if err != nil {
+		return "", err
+	}
+	return marshalCanonicalJSON(elements)
+}
+
+func (array *NDIArray) parse(val string) ([]interface{}, error) {
+	elements, err := parseJSONArray(val)
+	if err != nil {
+		return nil, err
+	}
+	if array.Len > 0 && len(elements) != array.Len {
+		return nil, fmt.Errorf("array must have %d elements, got %d", array.Len, len(elements))
+	}
+	if array.MinLen > 0 && len(elements) < array.MinLen {
+		return nil, fmt.Errorf("array must have at least %d elements, got %d", array.MinLen, len(elements))
+	}
+	if array.MaxLen > 0 && len(elements) > array.MaxLen {
+		return nil, fmt.Errorf("array must have at most %d elements, got %d", array.MaxLen, len(elements))
+	}
+	if err := validateElements(array.ElementType, elements, ""); err != nil {
+		return nil, err
+	}
+	return elements, nil
+}
+
+// Represent a matrix for DIDDocument's field, written as a JSON array of rows whose
+// elements are validated with ElementType. A flat array is accepted as a matrix with a
+// single row, and a bare scalar as a 1x1 matrix. Stored as JSON, and queryable with
+// SQLHasMember with a depth of 2.
+type NDIMatrix struct {
+	ElementType NDIDataType
+
+	// Required number of rows, ignored if 0
+	Rows int
+
+	// Required number of columns, ignored if 0
+	Cols int
+}
+
+// Create NDIMatrix from the parameters of a field in the schema definition, written the
+// same way as the ones of NDIArray
+func NewNDIMatrix(parameters string) (*NDIMatrix, error) {
+	elementType, err := newElementType(parameters)
+	if err != nil {
+		return nil, err
+	}
+	return &NDIMatrix{ElementType: elementType}, nil
+}
+
+func (matrix *NDIMatrix) ToSqlDataType() (sqldb.SqlDataType, error) {
+	return &sqldb.SqlJSON{NotNull: false}, nil
+}
+
+func (matrix *NDIMatrix) Validate(val string) error {
+	_, err := matrix.parse(val)
+	return err
+}
+
+// Convert the value to the JSON stored in the database, always as an array of rows
+func (matrix *NDIMatrix) Normalize(val string) (string, error) {
+	rows, err := matrix.parse(val)
+	if err != nil {
+		return "", err
+	}
+	return marshalCanonicalJSON(rows)
+}
+
+func (matrix *NDIMatrix) parse(val string) ([]interface{}, error) {
+	rows, err := parseJSONArray(val)
+	if err != nil {
+		return nil, err
+	}
+	if len(rows) > 0 {
+		if _, isNested := rows[0].([]interface{}); !isNested {
+			rows = []interface{}{rows}
+		}
+	}
+	if matrix.Rows > 0 && len(rows) != matrix.Rows {
+		return nil, fmt.Errorf("matrix must have %d rows, got %d", matrix.Rows, len(rows))
+	}
+	numOfCols := -1
+	for i, row := range rows {
+		elements, isArray := row.([]interface{})
+		if !isArray {
+			return nil, fmt.Errorf("row %d of the matrix is not an array", i)
+		}
+		if numOfCols >= 0 && len(elements) != numOfCols {
+			return nil, fmt.Errorf("all the rows of the matrix must have the same number of columns")
+		}
+		numOfCols = len(elements)
+		if err := validateElements(matrix.ElementType, elements, fmt.Sprintf("%d,", i)); err != nil {
+			return nil, err
+		}
+	}
+	if matrix.Cols > 0 && len(rows) > 0 && numOfCols != matrix.Cols {
+		return nil, fmt.Errorf("matrix must have %d columns, got %d", matrix.Cols, numOfCols)
+	}
+	return rows, nil
+}
+
+// Create the element type of an array or matrix from "typeName:parameters"
+func newElementType(parameters string) (NDIDataType, error) {
+	if strings.TrimSpace(parameters) == "" {
+		return nil, nil
+	}
+	typeName, elementParameters := parameters, ""
+	if i := strings.Index(parameters, ":"); i >= 0 {
+		typeName, elementParameters = parameters[:i], parameters[i+1:]
+	}
+	if isContainerType(typeName) {
+		return nil, fmt.Errorf("element type cannot be %s", strings.TrimSpace(typeName))
+	}
+	return NewDataType(typeName, elementParameters)
+}
+
+// Decode a JSON array, keeping numbers as written. A scalar is returned as an array with a
+// single element.
+func parseJSONArray(val string) ([]interface{}, error) {
+	decoder := json.NewDecoder(bytes.NewReader([]byte(val)))
+	decoder.UseNumber()
+	var decoded interface{}
+	if err := decoder.Decode(&decoded); err != nil || decoder.More() {
+		return nil, fmt.Errorf("%s is not a valid JSON array", val)
+	}
+	switch v := decoded.(type) {
+	case []interface{}:
+		return v, nil
+	case map[string]interface{}, nil:
+		return nil, fmt.Errorf("%s is not a valid JSON array", val)
+	default:
+		return []interface{}{v}, nil
+	}
+}
+
+// Validate each element of an array with the element type. Nested arrays and objects are
+// passed to the element type as JSON. indexPrefix is prepended to the index of the element
+// in error messages.
+func validateElements(elementType NDIDataType, elements []interface{}, indexPrefix string) error {
+	if elementType == nil {
+		return nil
+	}
+	for i, element := range elements {
+		var str string
+		switch v := element.(type) {
+		case nil:
+			return fmt.Errorf("element %s%d cannot be null", indexPrefix, i)
+		case string:
+			str = v
+		case json.Number:
+			str = v.String()
+		case bool:
+			str = strconv.FormatBool(v)
+		default:
+			encoded, err := json.Marshal(v)
+			if err != nil {
+				return err
+			}
+			str = string(encoded)
+		}
+		if err := elementType.Validate(str); err != nil {
+			return fmt.Errorf("element %s%d: %w", indexPrefix, i, err)
+		}
+	}
+	return nil
+}
+
+// Encode the value as JSON, converting numbers to int64 or float64 first so that equal
+// numbers always have the same representation (e.g. 1.0 and 1 are both written as 1)
+func marshalCanonicalJSON(val interface{}) (string, error) {
+	encoded, err := json.Marshal(canonicalizeNumbers(val))
+	if err != nil {
+		return "", err
+	}
+	return string(encoded), nil
+}
+
+func canonicalizeNumbers(val interface{}) interface{} {
+	switch v := val.(type) {
+	case json.Number:
+		if i, err := v.Int64(); err == nil {
+			return i
+		}
+		if f, err := v.Float64(); err == nil {
+			if f == math.Trunc(f) && math.Abs(f) < 1<<53 {
+				return int64(f)
+			}
+			return f
+		}
+		return v
+	case []interface{}:
+		res := make([]interface{}, len(v))
+		for i, element := range v {
+			res[i] = canonicalizeNumbers(element)
+		}
+		return res
+	case map[string]interface{}:
+		res := make(map[string]interface{}, len(v))
+		for key, element := range v {
+			res[key] = canonicalizeNumbers(element)
+		}
+		return res
+	default:
+		return v
+	}
+}
@@ -0,0 +1,107 @@
+package validator
+
+import "testing"
+
+func TestNDIArrayNormalize(t *testing.T) {
+	tests := []struct {
+		parameters string
+		val        string
+		want       string
+	}{
+		{"", `[1, "a", true]`, `[1,"a",true]`},
+		// MATLAB encodes vectors of one element as scalars
+		{"", `5`, `[5]`},
+		{"", `"a"`, `["a"]`},
+		{"", `[]`, `[]`},
+		{"float", `[1.0, 2.50, 1e2]`, `[1,2.5,100]`},
+		{"integer:0,10", `[0, 10]`, `[0,10]`},
+		{"", `[[1, 2], {"a": 1.0}]`, `[[1,2],{"a":1}]`},
+	}
+	for _, test := range tests {
+		array, err := NewNDIArray(test.parameters)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := array.Normalize(test.val)
+		if err != nil {
+			t.Fatalf("array(%s).Normalize(%s): %v", test.parameters, test.val, err)
+		}
+		if got != test.want {
+			t.Fatalf("array(%s).Normalize(%s) = %s, want %s", test.parameters, test.val, got, test.want)
+		}
+	}
+}
+
+func TestNDIArrayRejects(t *testing.T) {
+	tests := []struct {
+		array *NDIArray
+		val   string
+	}{
+		{&NDIArray{}, `[1, 2`},
+		{&NDIArray{}, `{"a": 1}`},
+		{&NDIArray{}, `null`},
+		{&NDIArray{}, `[1] [2]`},
+		{&NDIArray{ElementType: &NDIInteger{Max: int64Ptr(10)}}, `[1, 11]`},
+		{&NDIArray{ElementType: &NDIInteger{}}, `[1, null]`},
+		{&NDIArray{ElementType: &NDIInteger{}}, `[1, 1.5]`},
+		{&NDIArray{Len: 3}, `[1, 2]`},
+		{&NDIArray{MinLen: 2}, `[1]`},
+		{&NDIArray{MaxLen: 2}, `[1, 2, 3]`},
+	}
+	for _, test := range tests {
+		if err := test.array.Validate(test.val); err == nil {
+			t.Fatalf("%+v accepted %s", test.array, test.val)
+		}
+	}
+	for _, array := range []*NDIArray{{Len: 2}, {MinLen: 2, MaxLen: 2}} {
+		if err := array.Validate(`[1, 2]`); err != nil {
+			t.Fatalf("%+v rejected [1, 2]: %v", array, err)
+		}
+	}
+}
+
+func TestNDIMatrixNormalize(t *testing.T) {
+	tests := []struct {
+		val  string
+		want string
+	}{
+		{`[[1, 2], [3, 4]]`, `[[1,2],[3,4]]`},
+		// a flat array is a single row, and a scalar a 1x1 matrix
+		{`[1, 2, 3]`, `[[1,2,3]]`},
+		{`7`, `[[7]]`},
+		{`[]`, `[]`},
+		{`[[]]`, `[[]]`},
+	}
+	matrix := &NDIMatrix{}
+	for _, test := range tests {
+		got, err := matrix.Normalize(test.val)
+		if err != nil {
+			t.Fatalf("Normalize(%s): %v", test.val, err)
+		}
+		if got != test.want {
+			t.Fatalf("Normalize(%s) = %s, want %s", test.val, got, test.want)
+		}
+	}
+}
+
+func TestNDIMatrixShape(t *testing.T) {
+	tests := []struct {
+		matrix  *NDIMatrix
+		val     string
+		wantErr bool
+	}{
+		{&NDIMatrix{}, `[[1, 2], [3]]`, true},
+		{&NDIMatrix{}, `[[1, 2], 3]`, true},
+		{&NDIMatrix{Rows: 2, Cols: 3}, `[[1, 2, 3], [4, 5, 6]]`, false},
+		{&NDIMatrix{Rows: 2, Cols: 3}, `[[1, 2, 3]]`, true},
+		{&NDIMatrix{Rows: 2, Cols: 3}, `[[1, 2], [3, 4]]`, true},
+		{&NDIMatrix{Cols: 3}, `[1, 2, 3]`, false},
+		{&NDIMatrix{ElementType: &NDIFloat{Min: float64Ptr(0)}}, `[[0, 1], [2, -1]]`, true},
+		{&NDIMatrix{ElementType: &NDIFloat{Min: float64Ptr(0)}}, `[[0, 1], [2, 3]]`, false},
+	}
+	for _, test := range tests {
+		if err := test.matrix.Validate(test.val); (err != nil) != test.wantErr {
+			t.Fatalf("%+v Validate(%s) = %v", test.matrix, test.val, err)
+		}
+	}
+}
@@ -0,0 +1,42 @@
+package validator
+
+import (
+	"fmt"
+	"strings"
+
+	sqldb "github.com/zhaoy17/ndid/internal/sql"
+)
+
+// Represent boolean type for DIDDocument's field. Accept the spellings produced by NDI,
+// true/false and 1/0, regardless of case.
+type NDIBoolean struct{}
+
+// Create NDIBoolean from the parameters of a field in the schema definition. Boolean
+// does not take any parameter.
+func NewNDIBoolean(parameters string) (*NDIBoolean, error) {
+	if strings.TrimSpace(parameters) != "" {
+		return nil, fmt.Errorf("boolean does not take any parameter")
+	}
+	return &NDIBoolean{}, nil
+}
+
+func (boolean *NDIBoolean) ToSqlDataType() (sqldb.SqlDataType, error) {
+	return &sqldb.SqlBoolean{NotNull: false}, nil
+}
+
+func (boolean *NDIBoolean) Validate(val string) error {
+	_, err := ParseNDIBoolean(val)
+	return err
+}
+
+// Convert a value accepted by NDIBoolean to bool
+func ParseNDIBoolean(val string) (bool, error) {
+	switch strings.ToLower(strings.TrimSpace(val)) {
+	case "true", "1":
+		return true, nil
+	case "false", "0":
+		return false, nil
+	default:
+		return false, fmt.Errorf("%s is not boolean", val)
+	}
+}
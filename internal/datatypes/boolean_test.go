@@ -0,0 +1,33 @@
+package validator
+
+import "testing"
+
+func TestParseNDIBoolean(t *testing.T) {
+	tests := []struct {
+		val     string
+		want    bool
+		wantErr bool
+	}{
+		{"true", true, false},
+		{"TRUE", true, false},
+		{" True ", true, false},
+		{"1", true, false},
+		{"false", false, false},
+		{"False", false, false},
+		{"0", false, false},
+		{"yes", false, true},
+		{"t", false, true},
+		{"2", false, true},
+		{"", false, true},
+	}
+	boolean := &NDIBoolean{}
+	for _, test := range tests {
+		got, err := ParseNDIBoolean(test.val)
+		if (err != nil) != test.wantErr || got != test.want {
+			t.Fatalf("ParseNDIBoolean(%q) = %v, %v", test.val, got, err)
+		}
+		if err := boolean.Validate(test.val); (err != nil) != test.wantErr {
+			t.Fatalf("Validate(%q) = %v", test.val, err)
+		}
+	}
+}
@@ -9,3 +9,10 @@ type NDIDataType interface {
 	// Validate the string value pass in against the data type
 	Validate(string) error
 }
+
+// Implemented by the data types whose values are stored in a different form than they are
+// written in, e.g. timestamps converted to UTC
+type NDINormalizer interface {
+	// Convert a valid value to the representation stored in the database
+	Normalize(string) (string, error)
+}
@@ -0,0 +1,190 @@
+package validator
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	sqldb "github.com/zhaoy17/ndid/internal/sql"
+)
+
+// Layouts accepted for timestamps: ISO 8601 with or without timezone and fractional
+// seconds (the NDI datestamp format, e.g. 2018-12-05T18:36:47.241Z, being one of them),
+// the same with a space instead of T, and dates alone
+var timestampLayouts = []string{
+	"2006-01-02T15:04:05Z07:00",
+	"2006-01-02T15:04:05Z0700",
+	"2006-01-02T15:04:05Z07",
+	"2006-01-02T15:04:05",
+	"2006-01-02T15:04Z07:00",
+	"2006-01-02T15:04",
+	"2006-01-02 15:04:05Z07:00",
+	"2006-01-02 15:04:05Z0700",
+	"2006-01-02 15:04:05",
+	"2006-01-02",
+}
+
+// Represent timestamp type for DIDDocument's field. Values are normalized to UTC before
+// being stored, so that they can be compared with SQLBefore and SQLAfter.
+type NDIDateTime struct {
+	// Earliest accepted timestamp, ignored if nil
+	Min *time.Time
+
+	// Latest accepted timestamp, ignored if nil
+	Max *time.Time
+
+	// Timezone of the values that do not specify one. Default to UTC.
+	Location *time.Location
+}
+
+// Create NDIDateTime from the parameters of a field in the schema definition, which hold
+// the optional minimum and maximum separated by a comma, e.g. "2000-01-01T00:00:00Z,"
+func NewNDIDateTime(parameters string) (*NDIDateTime, error) {
+	min, max, err := parseTimeBounds(parameters)
+	if err != nil {
+		return nil, err
+	}
+	return &NDIDateTime{Min: min, Max: max}, nil
+}
+
+func (datetime *NDIDateTime) ToSqlDataType() (sqldb.SqlDataType, error) {
+	return &sqldb.SqlDateTime{NotNull: false}, nil
+}
+
+func (datetime *NDIDateTime) Validate(val string) error {
+	_, err := datetime.Parse(val)
+	return err
+}
+
+// Parse the value and check it against the bounds. The result is in UTC.
+func (datetime *NDIDateTime) Parse(val string) (time.Time, error) {
+	t, err := parseTimestamp(val, datetime.Location)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if err := checkTimeBounds(t, datetime.Min, datetime.Max, time.RFC3339Nano); err != nil {
+		return time.Time{}, err
+	}
+	return t, nil
+}
+
+// Convert the value to the representation stored in the database
+func (datetime *NDIDateTime) Normalize(val string) (string, error) {
+	t, err := datetime.Parse(val)
+	if err != nil {
+		return "", err
+	}
+	return t.Format(sqldb.SqlDateTimeLayout), nil
+}
+
+// Represent calendar date type for DIDDocument's field. Timestamps are accepted as well and
+// truncated to their date in UTC. Dates are stored as timestamps at midnight UTC.
+type NDIDate struct {
+	// Earliest accepted date, ignored if nil
+	Min *time.Time
+
+	// Latest accepted date, ignored if nil
+	Max *time.Time
+
+	// Timezone of the timestamps that do not specify one. Default to UTC.
+	Location *time.Location
+}
+
+// Create NDIDate from the parameters of a field in the schema definition, which hold the
+// optional minimum and maximum separated by a comma, e.g. "2000-01-01,2099-12-31"
+func NewNDIDate(parameters string) (*NDIDate, error) {
+	min, max, err := parseTimeBounds(parameters)
+	if err != nil {
+		return nil, err
+	}
+	return &NDIDate{Min: min, Max: max}, nil
+}
+
+func (date *NDIDate) ToSqlDataType() (sqldb.SqlDataType, error) {
+	return &sqldb.SqlDateTime{NotNull: false}, nil
+}
+
+func (date *NDIDate) Validate(val string) error {
+	_, err := date.Parse(val)
+	return err
+}
+
+// Parse the value and check it against the bounds. The result is at midnight UTC.
+func (date *NDIDate) Parse(val string) (time.Time, error) {
+	// dates alone are taken as is, whatever the timezone
+	t, err := time.Parse("2006-01-02", strings.TrimSpace(val))
+	if err != nil {
+		t, err = parseTimestamp(val, date.Location)
+		if err != nil {
+			return time.Time{}, err
+		}
+		t = t.Truncate(24 * time.Hour)
+	}
+	if err := checkTimeBounds(t, date.Min, date.Max, "2006-01-02"); err != nil {
+		return time.Time{}, err
+	}
+	return t, nil
+}
+
+// Convert the value to the representation stored in the database
+func (date *NDIDate) Normalize(val string) (string, error) {
+	t, err := date.Parse(val)
+	if err != nil {
+		return "", err
+	}
+	return t.Format(sqldb.SqlDateTimeLayout), nil
+}
+
+// Parse a timestamp in any of the accepted layouts and convert it to UTC. loc is the
+// timezone of timestamps without one, UTC if nil.
+func parseTimestamp(val string, loc *time.Location) (time.Time, error) {
+	if loc == nil {
+		loc = time.UTC
+	}
+	val = strings.TrimSpace(val)
+	for _, layout := range timestampLayouts {
+		if t, err := time.ParseInLocation(layout, val, loc); err == nil {
+			return t.UTC(), nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("%s is not a valid timestamp", val)
+}
+
+func checkTimeBounds(t time.Time, min *time.Time, max *time.Time, layout string) error {
+	if min != nil && t.Before(*min) {
+		return fmt.Errorf("%s cannot be earlier than %s", t.Format(layout), min.UTC().Format(layout))
+	}
+	if max != nil && t.After(*max) {
+		return fmt.Errorf("%s cannot be later than %s", t.Format(layout), max.UTC().Format(layout))
+	}
+	return nil
+}
+
+// Parse the optional minimum and maximum given as "min,max"
+func parseTimeBounds(parameters string) (min *time.Time, max *time.Time, err error) {
+	if strings.TrimSpace(parameters) == "" {
+		return nil, nil, nil
+	}
+	bounds := strings.Split(parameters, ",")
+	if len(bounds) != 2 {
+		return nil, nil, fmt.Errorf("parameters must be the minimum and maximum separated by a comma")
+	}
+	if strings.TrimSpace(bounds[0]) != "" {
+		t, err := parseTimestamp(bounds[0], nil)
+		if err != nil {
+			return nil, nil, err
+		}
+		min = &t
+	}
+	if strings.TrimSpace(bounds[1]) != "" {
+		t, err := parseTimestamp(bounds[1], nil)
+		if err != nil {
+			return nil, nil, err
+		}
+		max = &t
+	}
+	if min != nil && max != nil && max.Before(*min) {
+		return nil, nil, fmt.Errorf("maximum cannot be earlier than minimum")
+	}
+	return min, max, nil
+}
@@ -0,0 +1,121 @@
+package validator
+
+import (
+	"testing"
+	"time"
+)
+
+func TestNDIDateTimeNormalize(t *testing.T) {
+	tests := []struct {
+		val  string
+		want string
+	}{
+		// NDI datestamp
+		{"2018-12-05T18:36:47.241Z", "2018-12-05 18:36:47.241000"},
+		{"2018-12-05T18:36:47.241+02:00", "2018-12-05 16:36:47.241000"},
+		{"2018-12-05T18:36:47-0500", "2018-12-05 23:36:47.000000"},
+		{"2018-12-05T18:36:47+01", "2018-12-05 17:36:47.000000"},
+		{"2018-12-05T23:36:47-02:00", "2018-12-06 01:36:47.000000"},
+		{"2018-12-05T18:36:47", "2018-12-05 18:36:47.000000"},
+		{"2018-12-05T18:36Z", "2018-12-05 18:36:00.000000"},
+		{"2018-12-05T18:36", "2018-12-05 18:36:00.000000"},
+		{"2018-12-05 18:36:47.5+02:00", "2018-12-05 16:36:47.500000"},
+		{"2018-12-05 18:36:47", "2018-12-05 18:36:47.000000"},
+		{" 2018-12-05 ", "2018-12-05 00:00:00.000000"},
+	}
+	datetime := &NDIDateTime{}
+	for _, test := range tests {
+		got, err := datetime.Normalize(test.val)
+		if err != nil {
+			t.Fatalf("Normalize(%q): %v", test.val, err)
+		}
+		if got != test.want {
+			t.Fatalf("Normalize(%q) = %s, want %s", test.val, got, test.want)
+		}
+	}
+}
+
+func TestNDIDateTimeRejects(t *testing.T) {
+	datetime, err := NewNDIDateTime("2000-01-01T00:00:00Z,2020-12-31T23:59:59Z")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, val := range []string{
+		"",
+		"yesterday",
+		"05/12/2018",
+		"2018-13-01",
+		"2018-12-05T25:00:00Z",
+		"1999-12-31T23:59:59Z",
+		// later than the maximum once converted to UTC
+		"2020-12-31T23:00:00-02:00",
+	} {
+		if err := datetime.Validate(val); err == nil {
+			t.Fatalf("accepted %q", val)
+		}
+	}
+	if err := datetime.Validate("2021-01-01T00:59:59+01:00"); err != nil {
+		t.Fatalf("rejected the maximum written in another timezone: %v", err)
+	}
+}
+
+func TestNDIDateTimeLocation(t *testing.T) {
+	loc := time.FixedZone("UTC-5", -5*60*60)
+	datetime := &NDIDateTime{Location: loc}
+	got, err := datetime.Parse("2018-12-05T18:36:47")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := time.Date(2018, 12, 5, 23, 36, 47, 0, time.UTC); !got.Equal(want) || got.Location() != time.UTC {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	// an explicit timezone wins over Location
+	got, err = datetime.Parse("2018-12-05T18:36:47Z")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := time.Date(2018, 12, 5, 18, 36, 47, 0, time.UTC); !got.Equal(want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+}
+
+func TestNDIDateNormalize(t *testing.T) {
+	tests := []struct {
+		val  string
+		want string
+	}{
+		{"2018-12-05", "2018-12-05 00:00:00.000000"},
+		{"2018-12-05T18:36:47.241Z", "2018-12-05 00:00:00.000000"},
+		// the date is taken in UTC
+		{"2018-12-05T23:30:00-02:00", "2018-12-06 00:00:00.000000"},
+		{"2018-12-06T00:30:00+02:00", "2018-12-05 00:00:00.000000"},
+	}
+	date := &NDIDate{}
+	for _, test := range tests {
+		got, err := date.Normalize(test.val)
+		if err != nil {
+			t.Fatalf("Normalize(%q): %v", test.val, err)
+		}
+		if got != test.want {
+			t.Fatalf("Normalize(%q) = %s, want %s", test.val, got, test.want)
+		}
+	}
+}
+
+func TestNDIDateBounds(t *testing.T) {
+	date, err := NewNDIDate("2000-01-01,2000-12-31")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for val, wantErr := range map[string]bool{
+		"2000-01-01":           false,
+		"2000-12-31":           false,
+		"2000-12-31T23:59:59Z": false,
+		"1999-12-31":           true,
+		"2001-01-01":           true,
+	} {
+		if err := date.Validate(val); (err != nil) != wantErr {
+			t.Fatalf("Validate(%q) = %v", val, err)
+		}
+	}
+}
@@ -0,0 +1,54 @@
+package validator
+
+import (
+	"fmt"
+	"strings"
+
+	sqldb "github.com/zhaoy17/ndid/internal/sql"
+)
+
+// Represent string type whose value must be taken from a fixed vocabulary, such as the
+// probe types
+type NDIEnumString struct {
+	// the possible values
+	Values []string
+
+	// Weather or not values differing only by case are accepted
+	CaseInsensitive bool
+}
+
+// Create NDIEnumString from the parameters of a field in the schema definition, which
+// list the possible values separated by commas, e.g. "n-trode,patch,sharp"
+func NewNDIEnumString(parameters string) (*NDIEnumString, error) {
+	values := make([]string, 0)
+	for _, val := range strings.Split(parameters, ",") {
+		val = strings.TrimSpace(val)
+		if val == "" {
+			continue
+		}
+		values = append(values, val)
+	}
+	if len(values) == 0 {
+		return nil, fmt.Errorf("enumerated string requires at least one possible value")
+	}
+	return &NDIEnumString{Values: values}, nil
+}
+
+func (enum *NDIEnumString) ToSqlDataType() (sqldb.SqlDataType, error) {
+	maxLen := 0
+	for _, val := range enum.Values {
+		if len(val) > maxLen {
+			maxLen = len(val)
+		}
+	}
+	return &sqldb.SqlText{Len: maxLen, NotNull: false}, nil
+}
+
+func (enum *NDIEnumString) Validate(val string) error {
+	for _, possibleVal := range enum.Values {
+		if val == possibleVal || (enum.CaseInsensitive && strings.EqualFold(val, possibleVal)) {
+			return nil
+		}
+	}
+	return fmt.Errorf("%s is not among the list of possible values (%s)", val, strings.Join(enum.Values, ", "))
+}
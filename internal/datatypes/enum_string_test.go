@@ -0,0 +1,46 @@
+package validator
+
+import (
+	"testing"
+
+	sqldb "github.com/zhaoy17/ndid/internal/sql"
+)
+
+func TestNDIEnumStringValidate(t *testing.T) {
+	tests := []struct {
+		name            string
+		caseInsensitive bool
+		val             string
+		wantErr         bool
+	}{
+		{"Match", false, "patch", false},
+		{"NoMatch", false, "tetrode", true},
+		{"CaseDiffers", false, "Patch", true},
+		{"CaseInsensitive", true, "PATCH", false},
+		{"CaseInsensitiveNoMatch", true, "tetrode", true},
+		{"Prefix", false, "pat", true},
+		{"Empty", false, "", true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			enum := &NDIEnumString{Values: []string{"n-trode", "patch", "sharp"}, CaseInsensitive: test.caseInsensitive}
+			if err := enum.Validate(test.val); (err != nil) != test.wantErr {
+				t.Fatalf("Validate(%q) = %v", test.val, err)
+			}
+		})
+	}
+}
+
+func TestNDIEnumStringColumnFitsLongestValue(t *testing.T) {
+	enum, err := NewNDIEnumString("n-trode,patch,sharp-electrode")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dataType, err := enum.ToSqlDataType()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if text, ok := dataType.(*sqldb.SqlText); !ok || text.Len != len("sharp-electrode") {
+		t.Fatalf("got column type %+v", dataType)
+	}
+}
@@ -2,14 +2,83 @@ package validator
 
 import (
 	"fmt"
+	"math"
 	"strconv"
 
 	sqldb "github.com/zhaoy17/ndid/internal/sql"
 )
 
+// Represent 64-bit floating point type for DIDDocument's field
 type NDIFloat struct {
-	Max float64
-	Min float64
+	// Upper bound, ignored if nil
+	Max *float64
+
+	// Lower bound, ignored if nil
+	Min *float64
+
+	// Weather or not the value has to be strictly less than Max
+	ExclusiveMax bool
+
+	// Weather or not the value has to be strictly greater than Min
+	ExclusiveMin bool
+
+	// Weather or not NaN is accepted. Not every database can store it.
+	AllowNaN bool
+
+	// Weather or not +Inf and -Inf are accepted, in which case they are not subject to the
+	// bounds. Not every database can store them.
+	AllowInf bool
+}
+
+// Create NDIFloat from the parameters of a field in the schema definition, which hold the
+// optional minimum and maximum separated by a comma, e.g. "0,1", optionally followed by
+// exclusive_min, exclusive_max, allow_nan and allow_inf, e.g. "0,1;exclusive_min;allow_nan"
+func NewNDIFloat(parameters string) (*NDIFloat, error) {
+	float := &NDIFloat{}
+	bounds, options, err := splitOptions(parameters, exclusiveMinOption, exclusiveMaxOption, allowNaNOption, allowInfOption)
+	if err != nil {
+		return nil, err
+	}
+	for name, val := range options {
+		if val != "" {
+			return nil, fmt.Errorf("option %s does not take a value", name)
+		}
+	}
+	_, float.ExclusiveMin = options[exclusiveMinOption]
+	_, float.ExclusiveMax = options[exclusiveMaxOption]
+	_, float.AllowNaN = options[allowNaNOption]
+	_, float.AllowInf = options[allowInfOption]
+	params, err := splitNumericParameters(bounds)
+	if err != nil {
+		return nil, err
+	}
+	if len(params) > 2 {
+		return nil, fmt.Errorf("float does not take a step")
+	}
+	for i, param := range params {
+		if param == "" {
+			continue
+		}
+		num, err := strconv.ParseFloat(param, 64)
+		if err != nil || math.IsNaN(num) {
+			return nil, fmt.Errorf("%s is not float", param)
+		}
+		if i == 0 {
+			float.Min = &num
+		} else {
+			float.Max = &num
+		}
+	}
+	if float.Min != nil && float.Max != nil && *float.Min > *float.Max {
+		return nil, fmt.Errorf("minimum %f cannot be greater than maximum %f", *float.Min, *float.Max)
+	}
+	if float.ExclusiveMin && float.Min == nil || float.ExclusiveMax && float.Max == nil {
+		return nil, fmt.Errorf("an exclusive bound requires the bound to be given")
+	}
+	if (float.ExclusiveMin || float.ExclusiveMax) && float.Min != nil && float.Max != nil && *float.Min == *float.Max {
+		return nil, fmt.Errorf("no value lies between the exclusive bounds")
+	}
+	return float, nil
 }
 
 func (float *NDIFloat) ToSqlDataType() (sqldb.SqlDataType, error) {
@@ -21,11 +90,33 @@ func (float *NDIFloat) Validate(val string) error {
 	if err != nil {
 		return fmt.Errorf("%s is not float", val)
 	}
-	if num > float.Max {
-		return fmt.Errorf("%f cannot be greater than %f", num, float.Max)
+	if math.IsNaN(num) {
+		if !float.AllowNaN {
+			return fmt.Errorf("NaN is not allowed")
+		}
+		return nil
+	}
+	if math.IsInf(num, 0) {
+		if !float.AllowInf {
+			return fmt.Errorf("%f is not allowed", num)
+		}
+		return nil
+	}
+	if float.Max != nil {
+		if float.ExclusiveMax && num >= *float.Max {
+			return fmt.Errorf("%f has to be less than %f", num, *float.Max)
+		}
+		if num > *float.Max {
+			return fmt.Errorf("%f cannot be greater than %f", num, *float.Max)
+		}
 	}
-	if num < float.Min {
-		return fmt.Errorf("%f cannot be less than %f", num, float.Min)
+	if float.Min != nil {
+		if float.ExclusiveMin && num <= *float.Min {
+			return fmt.Errorf("%f has to be greater than %f", num, *float.Min)
+		}
+		if num < *float.Min {
+			return fmt.Errorf("%f cannot be less than %f", num, *float.Min)
+		}
 	}
 	return nil
 }
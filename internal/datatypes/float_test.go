@@ -0,0 +1,35 @@
+package validator
+
+import "testing"
+
+func TestNDIFloatValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		float   *NDIFloat
+		valid   []string
+		invalid []string
+	}{
+		// the zero value used to have a maximum of 0, rejecting every positive value
+		{"Unbounded", &NDIFloat{}, []string{"0", "1.5", "-1e300", "1E3"}, []string{"a", "", "NaN", "+Inf", "-Inf"}},
+		{"MaxZero", &NDIFloat{Max: float64Ptr(0)}, []string{"0", "-0.5"}, []string{"0.5"}},
+		{"Inclusive", &NDIFloat{Min: float64Ptr(0), Max: float64Ptr(1)}, []string{"0", "1", "0.5"}, []string{"-0.1", "1.1"}},
+		{"Exclusive", &NDIFloat{Min: float64Ptr(0), Max: float64Ptr(1), ExclusiveMin: true, ExclusiveMax: true}, []string{"0.5", "1e-300"}, []string{"0", "1"}},
+		{"AllowNaN", &NDIFloat{Max: float64Ptr(1), AllowNaN: true}, []string{"NaN", "nan"}, []string{"+Inf"}},
+		// infinities are not subject to the bounds
+		{"AllowInf", &NDIFloat{Max: float64Ptr(1), AllowInf: true}, []string{"+Inf", "-Inf", "inf"}, []string{"NaN", "2"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			for _, val := range test.valid {
+				if err := test.float.Validate(val); err != nil {
+					t.Fatalf("rejected %s: %v", val, err)
+				}
+			}
+			for _, val := range test.invalid {
+				if err := test.float.Validate(val); err == nil {
+					t.Fatalf("accepted %s", val)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,85 @@
+package validator
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	sqldb "github.com/zhaoy17/ndid/internal/sql"
+)
+
+// An NDI identifier is made of the hexadecimal representation of two double-precision
+// numbers separated by an underscore: the creation time as a MATLAB datenum, followed by
+// a random number, e.g. 41268b3b1bbd7fd6_c0d2d7e66d35d5ef
+var ndiIdentifierPattern = regexp.MustCompile(`^[0-9a-f]{16}_[0-9a-f]{16}$`)
+
+// MATLAB datenum of the Unix epoch (1970-01-01)
+const unixEpochDatenum = 719529
+
+// Represent the identifier of an NDI document
+type NDIIdentifier struct {
+	// Weather or not the empty string is accepted, e.g. for optional references
+	AllowEmpty bool
+}
+
+// Create NDIIdentifier from the parameters of a field in the schema definition, which are
+// either empty or "allow_empty"
+func NewNDIIdentifier(parameters string) (*NDIIdentifier, error) {
+	switch strings.TrimSpace(parameters) {
+	case "":
+		return &NDIIdentifier{}, nil
+	case "allow_empty":
+		return &NDIIdentifier{AllowEmpty: true}, nil
+	default:
+		return nil, fmt.Errorf("identifier only takes allow_empty as parameter")
+	}
+}
+
+func (identifier *NDIIdentifier) ToSqlDataType() (sqldb.SqlDataType, error) {
+	return &sqldb.SqlText{Len: 33, NotNull: false}, nil
+}
+
+func (identifier *NDIIdentifier) Validate(val string) error {
+	if val == "" && identifier.AllowEmpty {
+		return nil
+	}
+	if !ndiIdentifierPattern.MatchString(val) {
+		return fmt.Errorf("%s is not a valid NDI identifier", val)
+	}
+	return nil
+}
+
+var lastIdentifierTimestamp = struct {
+	sync.Mutex
+	bits uint64
+}{}
+
+// Generate a new NDI identifier. Since the hexadecimal representation of positive doubles
+// sorts in the same order as the numbers, identifiers generated later sort after the ones
+// generated earlier by the same process.
+func GenerateNDIIdentifier() (string, error) {
+	var random [8]byte
+	if _, err := rand.Read(random[:]); err != nil {
+		return "", err
+	}
+
+	now := time.Now().UTC()
+	datenum := unixEpochDatenum + float64(now.UnixNano())/float64(24*time.Hour)
+	timestamp := math.Float64bits(datenum)
+
+	// two identifiers generated within the precision of the datenum (~10µs) would otherwise
+	// share the same timestamp
+	lastIdentifierTimestamp.Lock()
+	if timestamp <= lastIdentifierTimestamp.bits {
+		timestamp = lastIdentifierTimestamp.bits + 1
+	}
+	lastIdentifierTimestamp.bits = timestamp
+	lastIdentifierTimestamp.Unlock()
+
+	return fmt.Sprintf("%016x_%016x", timestamp, binary.BigEndian.Uint64(random[:])), nil
+}
@@ -0,0 +1,63 @@
+package validator
+
+import (
+	"math"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestNDIIdentifierValidate(t *testing.T) {
+	tests := []struct {
+		val        string
+		allowEmpty bool
+		wantErr    bool
+	}{
+		{"41268b3b1bbd7fd6_c0d2d7e66d35d5ef", false, false},
+		{"41268B3B1BBD7FD6_C0D2D7E66D35D5EF", false, true},
+		{"41268b3b1bbd7fd6c0d2d7e66d35d5ef", false, true},
+		{"41268b3b1bbd7fd6_c0d2d7e66d35d5e", false, true},
+		{"41268b3b1bbd7fd6_c0d2d7e66d35d5efa", false, true},
+		{"41268b3b1bbd7fg6_c0d2d7e66d35d5ef", false, true},
+		{"", false, true},
+		{"", true, false},
+		{" ", true, true},
+	}
+	for _, test := range tests {
+		identifier := &NDIIdentifier{AllowEmpty: test.allowEmpty}
+		if err := identifier.Validate(test.val); (err != nil) != test.wantErr {
+			t.Fatalf("Validate(%q) with AllowEmpty %v = %v", test.val, test.allowEmpty, err)
+		}
+	}
+}
+
+func TestGenerateNDIIdentifier(t *testing.T) {
+	identifier := &NDIIdentifier{}
+	before := time.Now()
+	previous := ""
+	for i := 0; i < 1000; i++ {
+		id, err := GenerateNDIIdentifier()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := identifier.Validate(id); err != nil {
+			t.Fatal(err)
+		}
+		// identifiers generated within the precision of the datenum still sort in order
+		if id <= previous {
+			t.Fatalf("%s generated after %s does not sort after it", id, previous)
+		}
+		previous = id
+	}
+
+	// the first part is the creation time as a MATLAB datenum
+	bits, err := strconv.ParseUint(previous[:16], 16, 64)
+	if err != nil {
+		t.Fatal(err)
+	}
+	datenum := math.Float64frombits(bits)
+	created := time.Unix(0, int64((datenum-unixEpochDatenum)*float64(24*time.Hour)))
+	if created.Before(before.Add(-time.Second)) || created.After(time.Now().Add(time.Second)) {
+		t.Fatalf("identifier was created at %v", created)
+	}
+}
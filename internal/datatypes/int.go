@@ -2,37 +2,273 @@ package validator
 
 import (
 	"fmt"
+	"math"
 	"strconv"
+	"strings"
 
 	sqldb "github.com/zhaoy17/ndid/internal/sql"
 )
 
+// Represent 64-bit signed integer type for DIDDocument's field
 type NDIInteger struct {
-	Max  int
-	Min  int
-	Enum map[int]bool
+	// Upper bound (inclusive), ignored if nil
+	Max *int64
+
+	// Lower bound (inclusive), ignored if nil
+	Min *int64
+
+	// The possible values, ignored if empty
+	Enum map[int64]bool
+
+	// The value must be a multiple of it, ignored if 0
+	MultipleOf int64
+}
+
+// Create NDIInteger from the parameters of a field in the schema definition, which hold the
+// optional minimum, maximum and step separated by commas, e.g. "0,100" or ",,5", optionally
+// followed by the possible values, e.g. "0,100;enum=1|2|4" or ";enum=1|2|4"
+func NewNDIInteger(parameters string) (*NDIInteger, error) {
+	integer := &NDIInteger{}
+	bounds, options, err := splitOptions(parameters, enumOption)
+	if err != nil {
+		return nil, err
+	}
+	params, err := splitNumericParameters(bounds)
+	if err != nil {
+		return nil, err
+	}
+	for i, param := range params {
+		if param == "" {
+			continue
+		}
+		num, err := strconv.ParseInt(param, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("%s is not int", param)
+		}
+		switch i {
+		case 0:
+			integer.Min = &num
+		case 1:
+			integer.Max = &num
+		case 2:
+			integer.MultipleOf = num
+		}
+	}
+	if integer.Min != nil && integer.Max != nil && *integer.Min > *integer.Max {
+		return nil, fmt.Errorf("minimum %d cannot be greater than maximum %d", *integer.Min, *integer.Max)
+	}
+	if integer.MultipleOf < 0 {
+		return nil, fmt.Errorf("step has to be positive")
+	}
+	if enum, ok := options[enumOption]; ok {
+		values := splitEnumValues(enum)
+		if len(values) == 0 {
+			return nil, fmt.Errorf("option enum requires at least one possible value")
+		}
+		enumValues := make(map[int64]bool)
+		for _, val := range values {
+			num, err := strconv.ParseInt(val, 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("possible value %s is not int", val)
+			}
+			if err := integer.Validate(val); err != nil {
+				return nil, fmt.Errorf("possible value %s can never be accepted: %w", val, err)
+			}
+			enumValues[num] = true
+		}
+		integer.Enum = enumValues
+	}
+	return integer, nil
 }
 
-func (ineger *NDIInteger) ToSqlDataType() (sqldb.SqlDataType, error) {
-	return &sqldb.SqlInteger{NotNull: false}, nil
+// Stored as INTEGER if the bounds fit in 32 bits, BIGINT otherwise
+func (integer *NDIInteger) ToSqlDataType() (sqldb.SqlDataType, error) {
+	if integer.Min != nil && integer.Max != nil && *integer.Min >= math.MinInt32 && *integer.Max <= math.MaxInt32 {
+		return &sqldb.SqlInteger{NotNull: false}, nil
+	}
+	return &sqldb.SqlBigInt{NotNull: false}, nil
 }
 
 func (integer *NDIInteger) Validate(val string) error {
-	num, err := strconv.Atoi(val)
+	num, err := strconv.ParseInt(val, 10, 64)
 	if err != nil {
 		return fmt.Errorf("%s is not int", val)
 	}
-	if integer.Enum != nil || len(integer.Enum) == 0 {
-		if num > integer.Max {
-			return fmt.Errorf("%d cannot be greater than %d", num, integer.Max)
+	if len(integer.Enum) > 0 {
+		if _, ok := integer.Enum[num]; !ok {
+			return fmt.Errorf("%d is not among the list of possible values specified", num)
+		}
+	}
+	if integer.Max != nil && num > *integer.Max {
+		return fmt.Errorf("%d cannot be greater than %d", num, *integer.Max)
+	}
+	if integer.Min != nil && num < *integer.Min {
+		return fmt.Errorf("%d cannot be less than %d", num, *integer.Min)
+	}
+	if integer.MultipleOf != 0 && num%integer.MultipleOf != 0 {
+		return fmt.Errorf("%d is not a multiple of %d", num, integer.MultipleOf)
+	}
+	return nil
+}
+
+// Represent 64-bit unsigned integer type for DIDDocument's field
+type NDIUnsignedInteger struct {
+	// Upper bound (inclusive), ignored if nil
+	Max *uint64
+
+	// Lower bound (inclusive), ignored if nil
+	Min *uint64
+
+	// The possible values, ignored if empty
+	Enum map[uint64]bool
+
+	// The value must be a multiple of it, ignored if 0
+	MultipleOf uint64
+}
+
+// Create NDIUnsignedInteger from the parameters of a field in the schema definition, which
+// hold the optional minimum, maximum and step separated by commas, e.g. "1,65535", optionally
+// followed by the possible values the same way as NDIInteger, e.g. "1,65535;enum=80|443"
+func NewNDIUnsignedInteger(parameters string) (*NDIUnsignedInteger, error) {
+	integer := &NDIUnsignedInteger{}
+	bounds, options, err := splitOptions(parameters, enumOption)
+	if err != nil {
+		return nil, err
+	}
+	params, err := splitNumericParameters(bounds)
+	if err != nil {
+		return nil, err
+	}
+	for i, param := range params {
+		if param == "" {
+			continue
+		}
+		num, err := strconv.ParseUint(param, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("%s is not unsigned int", param)
 		}
-		if num < integer.Min {
-			return fmt.Errorf("%d cannot be less than %d", num, integer.Min)
+		switch i {
+		case 0:
+			integer.Min = &num
+		case 1:
+			integer.Max = &num
+		case 2:
+			integer.MultipleOf = num
 		}
-	} else {
+	}
+	if integer.Min != nil && integer.Max != nil && *integer.Min > *integer.Max {
+		return nil, fmt.Errorf("minimum %d cannot be greater than maximum %d", *integer.Min, *integer.Max)
+	}
+	if enum, ok := options[enumOption]; ok {
+		values := splitEnumValues(enum)
+		if len(values) == 0 {
+			return nil, fmt.Errorf("option enum requires at least one possible value")
+		}
+		enumValues := make(map[uint64]bool)
+		for _, val := range values {
+			num, err := strconv.ParseUint(val, 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("possible value %s is not unsigned int", val)
+			}
+			if err := integer.Validate(val); err != nil {
+				return nil, fmt.Errorf("possible value %s can never be accepted: %w", val, err)
+			}
+			enumValues[num] = true
+		}
+		integer.Enum = enumValues
+	}
+	return integer, nil
+}
+
+// Values above the range of BIGINT are stored as DECIMAL(20, 0) unless bounded
+func (integer *NDIUnsignedInteger) ToSqlDataType() (sqldb.SqlDataType, error) {
+	if integer.Max != nil && *integer.Max <= math.MaxInt32 {
+		return &sqldb.SqlInteger{NotNull: false}, nil
+	}
+	if integer.Max != nil && *integer.Max <= math.MaxInt64 {
+		return &sqldb.SqlBigInt{NotNull: false}, nil
+	}
+	return &sqldb.SqlDecimal{Precision: 20, Scale: 0, NotNull: false}, nil
+}
+
+func (integer *NDIUnsignedInteger) Validate(val string) error {
+	num, err := strconv.ParseUint(val, 10, 64)
+	if err != nil {
+		return fmt.Errorf("%s is not unsigned int", val)
+	}
+	if len(integer.Enum) > 0 {
 		if _, ok := integer.Enum[num]; !ok {
 			return fmt.Errorf("%d is not among the list of possible values specified", num)
 		}
 	}
+	if integer.Max != nil && num > *integer.Max {
+		return fmt.Errorf("%d cannot be greater than %d", num, *integer.Max)
+	}
+	if integer.Min != nil && num < *integer.Min {
+		return fmt.Errorf("%d cannot be less than %d", num, *integer.Min)
+	}
+	if integer.MultipleOf != 0 && num%integer.MultipleOf != 0 {
+		return fmt.Errorf("%d is not a multiple of %d", num, integer.MultipleOf)
+	}
 	return nil
 }
+
+// Options following the bounds of a numeric type, e.g. "0,1;exclusive_min;allow_nan"
+const (
+	enumOption         = "enum"
+	exclusiveMinOption = "exclusive_min"
+	exclusiveMaxOption = "exclusive_max"
+	allowNaNOption     = "allow_nan"
+	allowInfOption     = "allow_inf"
+)
+
+// Split the parameters of a numeric type into the bounds and the options following them,
+// each introduced by a semicolon and written either as a name or as name=value. Return an
+// error if an option is not among the allowed ones or is given twice.
+func splitOptions(parameters string, allowed ...string) (string, map[string]string, error) {
+	parts := strings.Split(parameters, ";")
+	options := make(map[string]string)
+	for _, option := range parts[1:] {
+		name, val, _ := strings.Cut(option, "=")
+		name = strings.ToLower(strings.TrimSpace(name))
+		isAllowed := false
+		for _, allowedName := range allowed {
+			isAllowed = isAllowed || name == allowedName
+		}
+		if !isAllowed {
+			return "", nil, fmt.Errorf("unknown option %s", name)
+		}
+		if _, exists := options[name]; exists {
+			return "", nil, fmt.Errorf("option %s is given more than once", name)
+		}
+		options[name] = strings.TrimSpace(val)
+	}
+	return parts[0], options, nil
+}
+
+// Split the possible values of an enum option, separated by |
+func splitEnumValues(values string) []string {
+	res := make([]string, 0)
+	for _, val := range strings.Split(values, "|") {
+		if val = strings.TrimSpace(val); val != "" {
+			res = append(res, val)
+		}
+	}
+	return res
+}
+
+// Split the parameters of a numeric type, "min,max" optionally followed by ",step", into
+// trimmed values that are empty when omitted
+func splitNumericParameters(parameters string) ([]string, error) {
+	if strings.TrimSpace(parameters) == "" {
+		return nil, nil
+	}
+	params := strings.Split(parameters, ",")
+	if len(params) < 2 || len(params) > 3 {
+		return nil, fmt.Errorf("parameters must be the minimum and maximum, optionally followed by the step, separated by commas")
+	}
+	for i := range params {
+		params[i] = strings.TrimSpace(params[i])
+	}
+	return params, nil
+}
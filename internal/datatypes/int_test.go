@@ -0,0 +1,97 @@
+package validator
+
+import (
+	"math"
+	"reflect"
+	"strconv"
+	"testing"
+
+	sqldb "github.com/zhaoy17/ndid/internal/sql"
+)
+
+func TestNDIIntegerValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		integer *NDIInteger
+		valid   []string
+		invalid []string
+	}{
+		// the zero value used to have a maximum of 0, rejecting every positive value
+		{"Unbounded", &NDIInteger{}, []string{"0", "1", "-1", strconv.FormatInt(math.MaxInt64, 10)}, []string{"1.5", "", "1e3", "9223372036854775808"}},
+		{"MaxZero", &NDIInteger{Max: int64Ptr(0)}, []string{"0", "-5"}, []string{"1"}},
+		{"MinZero", &NDIInteger{Min: int64Ptr(0)}, []string{"0", "5"}, []string{"-1"}},
+		{"Bounds", &NDIInteger{Min: int64Ptr(-2), Max: int64Ptr(2)}, []string{"-2", "2"}, []string{"-3", "3"}},
+		// the enum used to be ignored as soon as it was set
+		{"Enum", &NDIInteger{Enum: map[int64]bool{1: true, 3: true}}, []string{"1", "3"}, []string{"2", "0"}},
+		{"EnumAndBounds", &NDIInteger{Max: int64Ptr(2), Enum: map[int64]bool{1: true, 3: true}}, []string{"1"}, []string{"2", "3"}},
+		{"MultipleOf", &NDIInteger{MultipleOf: 5}, []string{"0", "10", "-15"}, []string{"3", "-4"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			for _, val := range test.valid {
+				if err := test.integer.Validate(val); err != nil {
+					t.Fatalf("rejected %s: %v", val, err)
+				}
+			}
+			for _, val := range test.invalid {
+				if err := test.integer.Validate(val); err == nil {
+					t.Fatalf("accepted %s", val)
+				}
+			}
+		})
+	}
+}
+
+func TestNDIUnsignedIntegerValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		integer *NDIUnsignedInteger
+		valid   []string
+		invalid []string
+	}{
+		{"Unbounded", &NDIUnsignedInteger{}, []string{"0", strconv.FormatUint(math.MaxUint64, 10)}, []string{"-1", "1.5"}},
+		{"MaxZero", &NDIUnsignedInteger{Max: uint64Ptr(0)}, []string{"0"}, []string{"1"}},
+		{"Bounds", &NDIUnsignedInteger{Min: uint64Ptr(1), Max: uint64Ptr(65535)}, []string{"1", "65535"}, []string{"0", "65536"}},
+		{"Enum", &NDIUnsignedInteger{Enum: map[uint64]bool{80: true}}, []string{"80"}, []string{"81"}},
+		{"MultipleOf", &NDIUnsignedInteger{MultipleOf: 8}, []string{"0", "16"}, []string{"4"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			for _, val := range test.valid {
+				if err := test.integer.Validate(val); err != nil {
+					t.Fatalf("rejected %s: %v", val, err)
+				}
+			}
+			for _, val := range test.invalid {
+				if err := test.integer.Validate(val); err == nil {
+					t.Fatalf("accepted %s", val)
+				}
+			}
+		})
+	}
+}
+
+func TestIntegerColumnTypes(t *testing.T) {
+	tests := []struct {
+		dataType NDIDataType
+		want     sqldb.SqlDataType
+	}{
+		{&NDIInteger{}, &sqldb.SqlBigInt{}},
+		{&NDIInteger{Min: int64Ptr(0), Max: int64Ptr(100)}, &sqldb.SqlInteger{}},
+		{&NDIInteger{Min: int64Ptr(0)}, &sqldb.SqlBigInt{}},
+		{&NDIInteger{Min: int64Ptr(0), Max: int64Ptr(math.MaxInt32 + 1)}, &sqldb.SqlBigInt{}},
+		{&NDIUnsignedInteger{}, &sqldb.SqlDecimal{Precision: 20}},
+		{&NDIUnsignedInteger{Max: uint64Ptr(math.MaxInt32)}, &sqldb.SqlInteger{}},
+		{&NDIUnsignedInteger{Max: uint64Ptr(math.MaxInt64)}, &sqldb.SqlBigInt{}},
+		{&NDIUnsignedInteger{Max: uint64Ptr(math.MaxInt64 + 1)}, &sqldb.SqlDecimal{Precision: 20}},
+	}
+	for _, test := range tests {
+		got, err := test.dataType.ToSqlDataType()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Fatalf("%+v is stored as %+v, want %+v", test.dataType, got, test.want)
+		}
+	}
+}
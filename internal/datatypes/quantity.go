@@ -0,0 +1,166 @@
+package validator
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+
+	sqldb "github.com/zhaoy17/ndid/internal/sql"
+)
+
+// A number followed by a unit, with or without a space in between, e.g. "12.5 ms"
+var quantityPattern = regexp.MustCompile(`^([-+]?(?:[0-9]+\.?[0-9]*|\.[0-9]+)(?:[eE][-+]?[0-9]+)?)\s*([^\s0-9.+-]\S*)$`)
+
+// Represent a physical quantity for DIDDocument's field, written either as a number followed
+// by its unit ("12.5 ms") or as a JSON object ({"value": 12.5, "unit": "ms"}). The content
+// of the document keeps the unit it was written in, while the querable column holds the
+// value converted to the canonical unit of the dimension, so that range queries compare
+// values written in different units correctly.
+type NDIQuantity struct {
+	// Physical dimension the unit must measure, e.g. time
+	Dimension string
+
+	// Units accepted, DefaultUnitSystem if nil
+	Units *UnitSystem
+
+	// Lower bound in the canonical unit, ignored if nil
+	Min *float64
+
+	// Upper bound in the canonical unit, ignored if nil
+	Max *float64
+}
+
+// Value of a physical quantity along with the unit it was written in
+type Quantity struct {
+	Value float64
+	Unit  *Unit
+}
+
+// Get the value in the canonical unit of the dimension
+func (quantity Quantity) Canonical() float64 {
+	return quantity.Value * quantity.Unit.Factor
+}
+
+func (quantity Quantity) String() string {
+	return strconv.FormatFloat(quantity.Value, 'g', -1, 64) + " " + quantity.Unit.Symbol
+}
+
+// Create NDIQuantity from the parameters of a field in the schema definition, which hold a
+// unit of the dimension, optionally followed by the minimum and maximum in that unit
+// separated by commas, e.g. "ms" or "Hz,0,30000"
+func NewNDIQuantity(parameters string) (*NDIQuantity, error) {
+	params := strings.Split(parameters, ",")
+	unit, err := DefaultUnitSystem.LookupUnit(strings.TrimSpace(params[0]))
+	if err != nil {
+		return nil, err
+	}
+	quantity := &NDIQuantity{Dimension: unit.Dimension}
+	if len(params) == 1 {
+		return quantity, nil
+	}
+	if len(params) != 3 {
+		return nil, fmt.Errorf("parameters must be the unit, optionally followed by the minimum and maximum, separated by commas")
+	}
+	for i, param := range params[1:] {
+		param = strings.TrimSpace(param)
+		if param == "" {
+			continue
+		}
+		num, err := strconv.ParseFloat(param, 64)
+		if err != nil || math.IsNaN(num) {
+			return nil, fmt.Errorf("%s is not float", param)
+		}
+		num *= unit.Factor
+		if i == 0 {
+			quantity.Min = &num
+		} else {
+			quantity.Max = &num
+		}
+	}
+	if quantity.Min != nil && quantity.Max != nil && *quantity.Min > *quantity.Max {
+		return nil, fmt.Errorf("minimum cannot be greater than maximum")
+	}
+	return quantity, nil
+}
+
+func (quantity *NDIQuantity) ToSqlDataType() (sqldb.SqlDataType, error) {
+	return &sqldb.SqlFloat{NotNull: false}, nil
+}
+
+func (quantity *NDIQuantity) Validate(val string) error {
+	_, err := quantity.Parse(val)
+	return err
+}
+
+// Parse the value, check its unit and bounds, and return it in the unit it was written in
+func (quantity *NDIQuantity) Parse(val string) (Quantity, error) {
+	num, symbol, err := splitQuantity(val)
+	if err != nil {
+		return Quantity{}, err
+	}
+	units := quantity.Units
+	if units == nil {
+		units = DefaultUnitSystem
+	}
+	unit, err := units.LookupUnit(symbol)
+	if err != nil {
+		return Quantity{}, err
+	}
+	if quantity.Dimension != "" && unit.Dimension != quantity.Dimension {
+		return Quantity{}, fmt.Errorf("%s is a unit of %s, not of %s", symbol, unit.Dimension, quantity.Dimension)
+	}
+	res := Quantity{Value: num, Unit: unit}
+	canonical, err := units.CanonicalUnit(unit.Dimension)
+	if err != nil {
+		return Quantity{}, err
+	}
+	if quantity.Min != nil && res.Canonical() < *quantity.Min {
+		return Quantity{}, fmt.Errorf("%s cannot be less than %g %s", res, *quantity.Min, canonical.Symbol)
+	}
+	if quantity.Max != nil && res.Canonical() > *quantity.Max {
+		return Quantity{}, fmt.Errorf("%s cannot be greater than %g %s", res, *quantity.Max, canonical.Symbol)
+	}
+	return res, nil
+}
+
+// Convert the value to the number stored in the database, in the canonical unit
+func (quantity *NDIQuantity) Normalize(val string) (string, error) {
+	res, err := quantity.Parse(val)
+	if err != nil {
+		return "", err
+	}
+	return strconv.FormatFloat(res.Canonical(), 'g', -1, 64), nil
+}
+
+// Split a quantity written as a string or as a JSON object into its value and unit
+func splitQuantity(val string) (float64, string, error) {
+	val = strings.TrimSpace(val)
+	var numStr, symbol string
+	if strings.HasPrefix(val, "{") {
+		var decoded struct {
+			Value json.Number `json:"value"`
+			Unit  string      `json:"unit"`
+		}
+		if err := json.Unmarshal([]byte(val), &decoded); err != nil {
+			return 0, "", fmt.Errorf("%s is not a valid quantity", val)
+		}
+		numStr, symbol = decoded.Value.String(), decoded.Unit
+	} else if _, err := strconv.ParseFloat(val, 64); err == nil {
+		return 0, "", fmt.Errorf("%s has no unit", val)
+	} else if matches := quantityPattern.FindStringSubmatch(val); matches != nil {
+		numStr, symbol = matches[1], matches[2]
+	} else {
+		return 0, "", fmt.Errorf("%s is not a valid quantity, which must be a number followed by its unit", val)
+	}
+	if symbol == "" {
+		return 0, "", fmt.Errorf("%s has no unit", val)
+	}
+	num, err := strconv.ParseFloat(numStr, 64)
+	if err != nil || math.IsInf(num, 0) {
+		return 0, "", fmt.Errorf("%s is not a valid quantity", val)
+	}
+	return num, symbol, nil
+}
@@ -0,0 +1,131 @@
+package validator
+
+import (
+	"math"
+	"strconv"
+	"testing"
+)
+
+func TestNDIQuantityNormalize(t *testing.T) {
+	tests := []struct {
+		parameters string
+		val        string
+		want       float64
+	}{
+		{"ms", "12.5 ms", 0.0125},
+		{"ms", "12.5ms", 0.0125},
+		{"ms", "2 min", 120},
+		{"ms", "1.5 h", 5400},
+		{"ms", "3 s", 3},
+		{"ms", "-1e3 µs", -0.001},
+		{"ms", "10 us", 1e-5},
+		{"ms", "10 μs", 1e-5},
+		{"ms", `{"value": 12.5, "unit": "ms"}`, 0.0125},
+		{"Hz", "30 kHz", 30000},
+		{"Ohm", "1.2 MOhm", 1.2e6},
+		{"Ohm", "1.2 MΩ", 1.2e6},
+		{"m", "5 cm", 0.05},
+		{"deg", "3.141592653589793 rad", 180},
+	}
+	for _, test := range tests {
+		quantity, err := NewNDIQuantity(test.parameters)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := quantity.Normalize(test.val)
+		if err != nil {
+			t.Fatalf("Normalize(%s): %v", test.val, err)
+		}
+		num, err := strconv.ParseFloat(got, 64)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if math.Abs(num-test.want) > 1e-9*math.Abs(test.want) {
+			t.Fatalf("Normalize(%s) = %s, want %g", test.val, got, test.want)
+		}
+	}
+}
+
+// The content keeps the unit the value was written in
+func TestNDIQuantityParseKeepsUnit(t *testing.T) {
+	quantity := &NDIQuantity{Dimension: "time"}
+	got, err := quantity.Parse("250 ms")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Value != 250 || got.Unit.Symbol != "ms" || got.String() != "250 ms" || got.Canonical() != 0.25 {
+		t.Fatalf("got %+v", got)
+	}
+}
+
+func TestNDIQuantityRejects(t *testing.T) {
+	quantity, err := NewNDIQuantity("ms,0,1000")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, val := range []string{
+		"12.5",
+		"ms",
+		"",
+		"12.5 parsec",
+		// units are case sensitive
+		"12.5 MS",
+		// not a time
+		"12.5 Hz",
+		// bounds are given in ms
+		"-1 ms",
+		"1001 ms",
+		"1.1 s",
+		`{"value": "a", "unit": "ms"}`,
+		`{"value": 1}`,
+		"1e400 ms",
+	} {
+		if err := quantity.Validate(val); err == nil {
+			t.Fatalf("accepted %q", val)
+		}
+	}
+	if err := quantity.Validate("1 s"); err != nil {
+		t.Fatalf("rejected the maximum written in another unit: %v", err)
+	}
+}
+
+func TestUnitSystem(t *testing.T) {
+	units := NewUnitSystem()
+	if err := units.AddDimension("information", "B", true); err != nil {
+		t.Fatal(err)
+	}
+	if err := units.AddUnit("bit", "information", 0.125); err != nil {
+		t.Fatal(err)
+	}
+	if err := units.AddDimension("information", "o", false); err == nil {
+		t.Fatalf("added a dimension twice")
+	}
+	if err := units.AddUnit("kB", "information", 1024); err == nil {
+		t.Fatalf("added a unit twice")
+	}
+	if err := units.AddUnit("nibble", "entropy", 4); err == nil {
+		t.Fatalf("added a unit to an unknown dimension")
+	}
+	if err := units.AddUnit("nibble", "information", -4); err == nil {
+		t.Fatalf("added a unit with a negative factor")
+	}
+	canonical, err := units.CanonicalUnit("information")
+	if err != nil || canonical.Symbol != "B" {
+		t.Fatalf("got canonical unit %+v, %v", canonical, err)
+	}
+	if symbols := units.Units("information"); len(symbols) != len(siPrefixes)+2 || symbols[0] != "B" {
+		t.Fatalf("got units %v", symbols)
+	}
+
+	quantity := &NDIQuantity{Units: units}
+	got, err := quantity.Normalize("16 bit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != "2" {
+		t.Fatalf("got %s, want 2", got)
+	}
+	if err := quantity.Validate("16 ms"); err == nil {
+		t.Fatalf("accepted a unit of the default system")
+	}
+}
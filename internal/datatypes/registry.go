@@ -0,0 +1,135 @@
+package validator
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Create a data type from the parameters string of a field in the schema definition
+type NDIDataTypeFactory func(parameters string) (NDIDataType, error)
+
+// Built-in data types, by the name used in the type of a field in the schema definition
+const (
+	StringType          = "string"
+	IntegerType         = "integer"
+	UnsignedIntegerType = "unsigned_integer"
+	FloatType           = "float"
+	BooleanType         = "boolean"
+	EnumType            = "enum"
+	DateTimeType        = "datetime"
+	DateType            = "date"
+	IdentifierType      = "identifier"
+	ArrayType           = "array"
+	MatrixType          = "matrix"
+	QuantityType        = "quantity"
+)
+
+var dataTypeRegistry = struct {
+	sync.RWMutex
+	factories map[string]NDIDataTypeFactory
+}{
+	factories: map[string]NDIDataTypeFactory{
+		StringType: func(parameters string) (NDIDataType, error) {
+			return NewNDIString(parameters)
+		},
+		IntegerType: func(parameters string) (NDIDataType, error) {
+			return NewNDIInteger(parameters)
+		},
+		UnsignedIntegerType: func(parameters string) (NDIDataType, error) {
+			return NewNDIUnsignedInteger(parameters)
+		},
+		FloatType: func(parameters string) (NDIDataType, error) {
+			return NewNDIFloat(parameters)
+		},
+		BooleanType: func(parameters string) (NDIDataType, error) {
+			return NewNDIBoolean(parameters)
+		},
+		EnumType: func(parameters string) (NDIDataType, error) {
+			return NewNDIEnumString(parameters)
+		},
+		DateTimeType: func(parameters string) (NDIDataType, error) {
+			return NewNDIDateTime(parameters)
+		},
+		DateType: func(parameters string) (NDIDataType, error) {
+			return NewNDIDate(parameters)
+		},
+		IdentifierType: func(parameters string) (NDIDataType, error) {
+			return NewNDIIdentifier(parameters)
+		},
+		QuantityType: func(parameters string) (NDIDataType, error) {
+			return NewNDIQuantity(parameters)
+		},
+	},
+}
+
+// Array and matrix are registered separately since their factories look up the registry
+func init() {
+	dataTypeRegistry.factories[ArrayType] = func(parameters string) (NDIDataType, error) {
+		return NewNDIArray(parameters)
+	}
+	dataTypeRegistry.factories[MatrixType] = func(parameters string) (NDIDataType, error) {
+		return NewNDIMatrix(parameters)
+	}
+}
+
+// Make a custom data type available to the schema definitions under the given name, which
+// is case insensitive. Meant to be called at startup, before any schema is loaded. Return
+// an error if another data type has been registered with the same name.
+func RegisterDataType(typeName string, factory NDIDataTypeFactory) error {
+	name := normalizeTypeName(typeName)
+	if name == "" {
+		return fmt.Errorf("name of data type cannot be empty")
+	}
+	if factory == nil {
+		return fmt.Errorf("factory of data type %s cannot be nil", name)
+	}
+	dataTypeRegistry.Lock()
+	defer dataTypeRegistry.Unlock()
+	if _, exists := dataTypeRegistry.factories[name]; exists {
+		return fmt.Errorf("data type %s has already been registered", name)
+	}
+	dataTypeRegistry.factories[name] = factory
+	return nil
+}
+
+// Create the data type registered with the given name from the parameters of a field
+func NewDataType(typeName string, parameters string) (NDIDataType, error) {
+	name := normalizeTypeName(typeName)
+	dataTypeRegistry.RLock()
+	factory, ok := dataTypeRegistry.factories[name]
+	dataTypeRegistry.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown data type %s", typeName)
+	}
+	dataType, err := factory(parameters)
+	if err != nil {
+		return nil, fmt.Errorf("invalid parameters %q for data type %s: %w", parameters, name, err)
+	}
+	if dataType == nil {
+		return nil, fmt.Errorf("factory of data type %s returned nil", name)
+	}
+	return dataType, nil
+}
+
+// Get the names of all the registered data types, in alphabetical order
+func RegisteredDataTypes() []string {
+	dataTypeRegistry.RLock()
+	defer dataTypeRegistry.RUnlock()
+	names := make([]string, 0, len(dataTypeRegistry.factories))
+	for name := range dataTypeRegistry.factories {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func normalizeTypeName(typeName string) string {
+	return strings.ToLower(strings.TrimSpace(typeName))
+}
+
+func isContainerType(typeName string) bool {
+	name := normalizeTypeName(typeName)
+	return name == ArrayType || name == MatrixType
+}
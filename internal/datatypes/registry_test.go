@@ -0,0 +1,170 @@
+package validator
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestNewDataType(t *testing.T) {
+	tests := []struct {
+		typeName   string
+		parameters string
+		want       NDIDataType
+	}{
+		{"string", "", &NDIString{}},
+		{"String", "64,^[a-z,]+$", &NDIString{MaxLen: 64, MustHaveRegexPattern: "^[a-z,]+$"}},
+		{" integer ", "0,100", &NDIInteger{Min: int64Ptr(0), Max: int64Ptr(100)}},
+		{"integer", ",,5", &NDIInteger{MultipleOf: 5}},
+		{"integer", "0,100;enum=1|2| 4", &NDIInteger{Min: int64Ptr(0), Max: int64Ptr(100), Enum: map[int64]bool{1: true, 2: true, 4: true}}},
+		{"integer", ";enum=-1|1", &NDIInteger{Enum: map[int64]bool{-1: true, 1: true}}},
+		{"unsigned_integer", "1,65535;ENUM=80|443", &NDIUnsignedInteger{Min: uint64Ptr(1), Max: uint64Ptr(65535), Enum: map[uint64]bool{80: true, 443: true}}},
+		{"float", "0,1", &NDIFloat{Min: float64Ptr(0), Max: float64Ptr(1)}},
+		{"float", "0,1;exclusive_min;exclusive_max", &NDIFloat{Min: float64Ptr(0), Max: float64Ptr(1), ExclusiveMin: true, ExclusiveMax: true}},
+		{"float", ";allow_nan;allow_inf", &NDIFloat{AllowNaN: true, AllowInf: true}},
+		{"boolean", "", &NDIBoolean{}},
+		{"enum", "n-trode, patch,,sharp", &NDIEnumString{Values: []string{"n-trode", "patch", "sharp"}}},
+		{"identifier", "allow_empty", &NDIIdentifier{AllowEmpty: true}},
+		{"array", "", &NDIArray{}},
+		{"array", "integer:0,10;enum=2|4", &NDIArray{ElementType: &NDIInteger{Min: int64Ptr(0), Max: int64Ptr(10), Enum: map[int64]bool{2: true, 4: true}}}},
+		{"matrix", "float", &NDIMatrix{ElementType: &NDIFloat{}}},
+	}
+	for _, test := range tests {
+		got, err := NewDataType(test.typeName, test.parameters)
+		if err != nil {
+			t.Fatalf("%s(%s): %v", test.typeName, test.parameters, err)
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Fatalf("%s(%s) = %+v, want %+v", test.typeName, test.parameters, got, test.want)
+		}
+	}
+}
+
+func TestNewDataTypeRejectsParameters(t *testing.T) {
+	tests := []struct {
+		typeName   string
+		parameters string
+		wantErr    string
+	}{
+		{"unknown", "", "unknown data type unknown"},
+		{"string", "-1", "not a non-negative int"},
+		{"string", ",[", "invalid regex pattern"},
+		{"integer", "1", "minimum and maximum"},
+		{"integer", "1,2,3,4", "minimum and maximum"},
+		{"integer", "a,1", "a is not int"},
+		{"integer", "10,1", "cannot be greater than maximum"},
+		{"integer", ",,-5", "step has to be positive"},
+		{"integer", "0,10;enum=", "at least one possible value"},
+		{"integer", "0,10;enum=1|x", "possible value x is not int"},
+		{"integer", "0,10;enum=1|20", "possible value 20 can never be accepted"},
+		{"integer", "0,10,2;enum=3", "possible value 3 can never be accepted"},
+		{"integer", "0,10;enum=1;enum=2", "given more than once"},
+		{"integer", "0,10;exclusive_min", "unknown option exclusive_min"},
+		{"unsigned_integer", "-1,1", "not unsigned int"},
+		{"unsigned_integer", ";enum=-1", "possible value -1 is not unsigned int"},
+		{"float", "0,1,1", "float does not take a step"},
+		{"float", "NaN,1", "NaN is not float"},
+		{"float", "0,1;enum=1", "unknown option enum"},
+		{"float", "0,1;allow_nan=yes", "does not take a value"},
+		{"float", ",1;exclusive_min", "exclusive bound requires the bound"},
+		{"float", "1,1;exclusive_max", "no value lies between"},
+		{"boolean", "true", "does not take any parameter"},
+		{"enum", " , ", "at least one possible value"},
+		{"identifier", "optional", "only takes allow_empty"},
+		{"datetime", "2020-01-01", "minimum and maximum"},
+		{"datetime", "2020-01-01,2019-01-01", "maximum cannot be earlier than minimum"},
+		{"date", "yesterday,", "not a valid timestamp"},
+		{"array", "array:integer", "element type cannot be array"},
+		{"matrix", "unknown", "unknown data type"},
+		{"quantity", "parsec", "unknown unit parsec"},
+		{"quantity", "ms,1", "unit, optionally followed by the minimum and maximum"},
+		{"quantity", "ms,2,1", "minimum cannot be greater than maximum"},
+	}
+	for _, test := range tests {
+		_, err := NewDataType(test.typeName, test.parameters)
+		if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+			t.Fatalf("%s(%s): got error %v, want one containing %q", test.typeName, test.parameters, err, test.wantErr)
+		}
+	}
+}
+
+// Options written in the schema take effect when values are validated
+func TestNewDataTypeOptionsValidate(t *testing.T) {
+	tests := []struct {
+		typeName   string
+		parameters string
+		valid      []string
+		invalid    []string
+	}{
+		{"integer", ";enum=1|2|4", []string{"1", "4"}, []string{"3", "0"}},
+		{"unsigned_integer", ";enum=80|443", []string{"443"}, []string{"8080"}},
+		{"float", "0,1;exclusive_min", []string{"0.5", "1"}, []string{"0", "NaN", "+Inf"}},
+		{"float", "0,1;exclusive_max;allow_nan;allow_inf", []string{"0", "NaN", "+Inf", "-Inf"}, []string{"1"}},
+	}
+	for _, test := range tests {
+		dataType, err := NewDataType(test.typeName, test.parameters)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, val := range test.valid {
+			if err := dataType.Validate(val); err != nil {
+				t.Fatalf("%s(%s) rejected %s: %v", test.typeName, test.parameters, val, err)
+			}
+		}
+		for _, val := range test.invalid {
+			if err := dataType.Validate(val); err == nil {
+				t.Fatalf("%s(%s) accepted %s", test.typeName, test.parameters, val)
+			}
+		}
+	}
+}
+
+type customDataType struct{ NDIString }
+
+func TestRegisterDataType(t *testing.T) {
+	factory := func(parameters string) (NDIDataType, error) { return &customDataType{}, nil }
+	if err := RegisterDataType(" Custom_Test ", factory); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := NewDataType("CUSTOM_TEST", ""); err != nil || reflect.TypeOf(got) != reflect.TypeOf(&customDataType{}) {
+		t.Fatalf("got %T, %v", got, err)
+	}
+	found := false
+	for _, name := range RegisteredDataTypes() {
+		found = found || name == "custom_test"
+	}
+	if !found {
+		t.Fatalf("custom_test is not among %v", RegisteredDataTypes())
+	}
+
+	if err := RegisterDataType("custom_test", factory); err == nil {
+		t.Fatalf("registered custom_test twice")
+	}
+	if err := RegisterDataType("integer", factory); err == nil {
+		t.Fatalf("replaced a built-in data type")
+	}
+	if err := RegisterDataType(" ", factory); err == nil {
+		t.Fatalf("registered a data type without name")
+	}
+	if err := RegisterDataType("custom_nil_factory", nil); err == nil {
+		t.Fatalf("registered a nil factory")
+	}
+	if err := RegisterDataType("custom_nil_type", func(string) (NDIDataType, error) { return nil, nil }); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewDataType("custom_nil_type", ""); err == nil {
+		t.Fatalf("created a nil data type")
+	}
+}
+
+func int64Ptr(num int64) *int64 {
+	return &num
+}
+
+func uint64Ptr(num uint64) *uint64 {
+	return &num
+}
+
+func float64Ptr(num float64) *float64 {
+	return &num
+}
@@ -1,6 +1,14 @@
 package validator
 
-import sqldb "github.com/zhaoy17/ndid/internal/sql"
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	sqldb "github.com/zhaoy17/ndid/internal/sql"
+)
 
 // Represent string type for DIDDocument's field
 type NDIString struct {
@@ -17,11 +25,44 @@ type NDIString struct {
 	MustHaveRegexPattern string
 }
 
+// Create NDIString from the parameters of a field in the schema definition, which hold the
+// optional maximum length, optionally followed by a comma and the regex pattern the value
+// must have, e.g. "64" or ",^[a-z]+$". The pattern may itself contain commas.
+func NewNDIString(parameters string) (*NDIString, error) {
+	str := &NDIString{}
+	params := strings.SplitN(parameters, ",", 2)
+	if maxLen := strings.TrimSpace(params[0]); maxLen != "" {
+		num, err := strconv.Atoi(maxLen)
+		if err != nil || num < 0 {
+			return nil, fmt.Errorf("maximum length %s is not a non-negative int", maxLen)
+		}
+		str.MaxLen = num
+	}
+	if len(params) == 2 && params[1] != "" {
+		if _, err := regexp.Compile(params[1]); err != nil {
+			return nil, fmt.Errorf("invalid regex pattern %s: %w", params[1], err)
+		}
+		str.MustHaveRegexPattern = params[1]
+	}
+	return str, nil
+}
+
 func (str *NDIString) ToSqlDataType() (sqldb.SqlDataType, error) {
 	return &sqldb.SqlText{Len: 0, NotNull: false}, nil
 }
 
-func (str *NDIString) Validate(string) error {
-	// TODO: need to actually implement it
+func (str *NDIString) Validate(val string) error {
+	if str.MaxLen > 0 && utf8.RuneCountInString(val) > str.MaxLen {
+		return fmt.Errorf("%s cannot be longer than %d characters", val, str.MaxLen)
+	}
+	if str.MustHaveRegexPattern != "" {
+		matched, err := regexp.MatchString(str.MustHaveRegexPattern, val)
+		if err != nil {
+			return err
+		}
+		if !matched {
+			return fmt.Errorf("%s does not match the pattern %s", val, str.MustHaveRegexPattern)
+		}
+	}
 	return nil
 }
@@ -0,0 +1,182 @@
+package validator
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"sync"
+)
+
+// Unit of a physical quantity
+type Unit struct {
+	Symbol string
+
+	// Physical dimension measured by the unit, e.g. time
+	Dimension string
+
+	// Value of one unit in the canonical unit of the dimension, e.g. 0.001 for ms
+	Factor float64
+}
+
+// Set of units recognized by NDIQuantity. Each dimension has a canonical unit, in which
+// values are stored so that they can be compared regardless of the unit they were
+// written in. Safe for concurrent use.
+type UnitSystem struct {
+	lock      sync.RWMutex
+	units     map[string]*Unit
+	canonical map[string]*Unit
+}
+
+// SI prefixes, with the spellings of micro found in the wild
+var siPrefixes = map[string]float64{
+	"p": 1e-12,
+	"n": 1e-9,
+	"µ": 1e-6,
+	"μ": 1e-6,
+	"u": 1e-6,
+	"m": 1e-3,
+	"k": 1e3,
+	"M": 1e6,
+	"G": 1e9,
+}
+
+// Units recognized when NDIQuantity.Units is nil. Applications may add their own units
+// to it at startup.
+var DefaultUnitSystem = newDefaultUnitSystem()
+
+func NewUnitSystem() *UnitSystem {
+	return &UnitSystem{
+		units:     make(map[string]*Unit),
+		canonical: make(map[string]*Unit),
+	}
+}
+
+// Add a dimension along with its canonical unit and, if withPrefixes is set, the units
+// obtained by adding an SI prefix to the canonical one (e.g. ms, µs and ks for s)
+func (system *UnitSystem) AddDimension(dimension string, canonicalSymbol string, withPrefixes bool) error {
+	system.lock.Lock()
+	defer system.lock.Unlock()
+	if dimension == "" {
+		return fmt.Errorf("dimension cannot be empty")
+	}
+	if _, exists := system.canonical[dimension]; exists {
+		return fmt.Errorf("dimension %s has already been added", dimension)
+	}
+	unit := &Unit{Symbol: canonicalSymbol, Dimension: dimension, Factor: 1}
+	if err := system.addUnit(unit); err != nil {
+		return err
+	}
+	system.canonical[dimension] = unit
+	if withPrefixes {
+		for prefix, factor := range siPrefixes {
+			if err := system.addUnit(&Unit{Symbol: prefix + canonicalSymbol, Dimension: dimension, Factor: factor}); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Add a unit worth factor times the canonical unit of an existing dimension, e.g. "min"
+// for time with a factor of 60
+func (system *UnitSystem) AddUnit(symbol string, dimension string, factor float64) error {
+	system.lock.Lock()
+	defer system.lock.Unlock()
+	if _, exists := system.canonical[dimension]; !exists {
+		return fmt.Errorf("unknown dimension %s", dimension)
+	}
+	if factor <= 0 || math.IsInf(factor, 0) || math.IsNaN(factor) {
+		return fmt.Errorf("factor of unit %s has to be a positive number", symbol)
+	}
+	return system.addUnit(&Unit{Symbol: symbol, Dimension: dimension, Factor: factor})
+}
+
+// Get the unit with the given symbol. Symbols are case sensitive, since ms and Ms differ.
+func (system *UnitSystem) LookupUnit(symbol string) (*Unit, error) {
+	system.lock.RLock()
+	defer system.lock.RUnlock()
+	unit, ok := system.units[symbol]
+	if !ok {
+		return nil, fmt.Errorf("unknown unit %s", symbol)
+	}
+	return unit, nil
+}
+
+// Get the canonical unit of a dimension
+func (system *UnitSystem) CanonicalUnit(dimension string) (*Unit, error) {
+	system.lock.RLock()
+	defer system.lock.RUnlock()
+	unit, ok := system.canonical[dimension]
+	if !ok {
+		return nil, fmt.Errorf("unknown dimension %s", dimension)
+	}
+	return unit, nil
+}
+
+// Get the symbols of all the units of a dimension, in alphabetical order
+func (system *UnitSystem) Units(dimension string) []string {
+	system.lock.RLock()
+	defer system.lock.RUnlock()
+	symbols := make([]string, 0)
+	for symbol, unit := range system.units {
+		if unit.Dimension == dimension {
+			symbols = append(symbols, symbol)
+		}
+	}
+	sort.Strings(symbols)
+	return symbols
+}
+
+func (system *UnitSystem) addUnit(unit *Unit) error {
+	if unit.Symbol == "" {
+		return fmt.Errorf("symbol of unit cannot be empty")
+	}
+	if existing, exists := system.units[unit.Symbol]; exists {
+		return fmt.Errorf("unit %s has already been added for dimension %s", unit.Symbol, existing.Dimension)
+	}
+	system.units[unit.Symbol] = unit
+	return nil
+}
+
+// Units commonly found in electrophysiology and behavioral experiments
+func newDefaultUnitSystem() *UnitSystem {
+	system := NewUnitSystem()
+	prefixed := []struct{ dimension, symbol string }{
+		{"time", "s"},
+		{"frequency", "Hz"},
+		{"voltage", "V"},
+		{"current", "A"},
+		{"resistance", "Ω"},
+		{"capacitance", "F"},
+		{"length", "m"},
+		{"mass", "g"},
+		{"volume", "L"},
+	}
+	for _, dim := range prefixed {
+		if err := system.AddDimension(dim.dimension, dim.symbol, true); err != nil {
+			panic(err)
+		}
+	}
+	others := []struct {
+		symbol, dimension string
+		factor            float64
+	}{
+		{"min", "time", 60},
+		{"h", "time", 3600},
+		{"Ohm", "resistance", 1},
+		{"kOhm", "resistance", 1e3},
+		{"MOhm", "resistance", 1e6},
+		{"GOhm", "resistance", 1e9},
+		{"cm", "length", 1e-2},
+		{"rad", "angle", 180 / math.Pi},
+	}
+	if err := system.AddDimension("angle", "deg", false); err != nil {
+		panic(err)
+	}
+	for _, unit := range others {
+		if err := system.AddUnit(unit.symbol, unit.dimension, unit.factor); err != nil {
+			panic(err)
+		}
+	}
+	return system
+}
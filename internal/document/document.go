@@ -0,0 +1,107 @@
+package document
+
+import (
+	"fmt"
+
+	datatypes "github.com/zhaoy17/ndid/internal/datatypes"
+	"github.com/zhaoy17/ndid/internal/schema"
+)
+
+// Name of the field holding the identifier of a document
+const IDField = "id"
+
+// Represent a document: the content, decoded from JSON, of an instance of a schema
+type NDIDocument struct {
+	ID        string
+	ClassName string
+	Content   map[string]interface{}
+
+	// Documents this one depends on, by the names of the dependencies declared in the schema
+	DependsOn []*NDIDependencyReference
+
+	// Files attached to the document, by the names of the files declared in the schema
+	Files []*NDIFileReference
+}
+
+// Reference from a document to a document it depends on
+type NDIDependencyReference struct {
+	// Name of the dependency declared in the schema of the referencing document
+	Name string
+
+	// Identifier of the document depended on
+	DocumentID string
+}
+
+// Reference from a document to the content of one of its files
+type NDIFileReference struct {
+	// Name of the file declared in the schema of the document
+	Name string
+
+	// Key of the content of the file in the blob store
+	BlobKey string
+}
+
+// Create a document of the class described by class. The content is modified in place:
+// an identifier is generated if the class has an id field and the content does not set
+// it, and the default values of the missing fields are filled in. Return
+// schema.ValidationErrors if the content does not conform to the class, and an error if
+// dependsOn refers to a dependency the class does not declare. Whether the documents
+// depended on exist is checked when the document is inserted.
+func NewNDIDocument(class *schema.NDISchema, content map[string]interface{}, dependsOn []*NDIDependencyReference) (*NDIDocument, error) {
+	if class == nil {
+		return nil, fmt.Errorf("class of the document cannot be nil")
+	}
+	if content == nil {
+		content = make(map[string]interface{})
+	}
+	id, err := documentID(class, content)
+	if err != nil {
+		return nil, err
+	}
+	if err := class.PrepareContent(content); err != nil {
+		return nil, err
+	}
+	if err := checkDependencyNames(class, dependsOn); err != nil {
+		return nil, err
+	}
+	return &NDIDocument{ID: id, ClassName: class.SchemaName, Content: content, DependsOn: dependsOn}, nil
+}
+
+// Check that every reference is made through a dependency declared by the class
+func checkDependencyNames(class *schema.NDISchema, dependsOn []*NDIDependencyReference) error {
+	declared := make(map[string]bool)
+	for _, dependency := range class.AllDependencies() {
+		declared[dependency.DependencyName] = true
+	}
+	for _, ref := range dependsOn {
+		if ref == nil {
+			return fmt.Errorf("dependency reference cannot be nil")
+		}
+		if !declared[ref.Name] {
+			return fmt.Errorf("%s does not declare dependency %s", class.SchemaName, ref.Name)
+		}
+	}
+	return nil
+}
+
+// Get the identifier set in the content, generating one if there is none
+func documentID(class *schema.NDISchema, content map[string]interface{}) (string, error) {
+	if val, ok := content[IDField]; ok && val != nil && val != "" {
+		id, isString := val.(string)
+		if !isString {
+			return "", fmt.Errorf("%s must be a string", IDField)
+		}
+		return id, nil
+	}
+	id, err := datatypes.GenerateNDIIdentifier()
+	if err != nil {
+		return "", err
+	}
+	for _, field := range class.AllFields() {
+		if field.FieldName == IDField {
+			content[IDField] = id
+			break
+		}
+	}
+	return id, nil
+}
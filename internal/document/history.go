@@ -0,0 +1,140 @@
+package document
+
+import (
+	"reflect"
+	"sort"
+	"time"
+
+	"github.com/zhaoy17/ndid/internal/schema"
+)
+
+// Who made a change to documents and why, recorded in their history
+type NDIChange struct {
+	Author string
+	Reason string
+}
+
+// State of a document after a change. Every insertion, update, file attachment and
+// deletion of a document adds a version to its history, numbered from 1.
+type NDIDocumentVersion struct {
+	DocumentID string
+	Version    int
+
+	// The document as of this version, nil if the version records its deletion
+	Document *NDIDocument
+
+	Deleted   bool
+	Author    string
+	Reason    string
+	CreatedAt time.Time
+}
+
+// Difference between two versions of a document
+type NDIDocumentDiff struct {
+	DocumentID string
+	From       int
+	To         int
+
+	// Changes of the fields of the content, ordered by path
+	Content []*FieldChange
+
+	// References present in one version only
+	AddedDependencies   []*NDIDependencyReference
+	RemovedDependencies []*NDIDependencyReference
+
+	// Changes of the files, whose path is the name of the file and values the blob keys
+	Files []*FieldChange
+}
+
+// How a field differs between two versions
+const (
+	FieldAdded   = "added"
+	FieldRemoved = "removed"
+	FieldChanged = "changed"
+)
+
+// Change of a single field between two versions. Before is nil if the field was added,
+// and After if it was removed.
+type FieldChange struct {
+	// Path of the field, as given by schema.FlattenValues, e.g. "epoch.t0" or
+	// "channels[2].name"
+	Path string `json:"path"`
+
+	Op     string      `json:"op"`
+	Before interface{} `json:"before,omitempty"`
+	After  interface{} `json:"after,omitempty"`
+}
+
+// Compute the difference between two versions of a document. A version recording the
+// deletion of the document is compared as an empty document.
+func diffVersions(from *NDIDocumentVersion, to *NDIDocumentVersion) *NDIDocumentDiff {
+	before, after := from.Document, to.Document
+	if before == nil {
+		before = &NDIDocument{}
+	}
+	if after == nil {
+		after = &NDIDocument{}
+	}
+	diff := &NDIDocumentDiff{
+		DocumentID:          from.DocumentID,
+		From:                from.Version,
+		To:                  to.Version,
+		Content:             diffLeaves(schema.FlattenValues(before.Content), schema.FlattenValues(after.Content)),
+		AddedDependencies:   make([]*NDIDependencyReference, 0),
+		RemovedDependencies: make([]*NDIDependencyReference, 0),
+	}
+
+	dependencyKey := func(ref *NDIDependencyReference) string {
+		return ref.Name + "\x00" + ref.DocumentID
+	}
+	beforeRefs := make(map[string]bool)
+	for _, ref := range before.DependsOn {
+		beforeRefs[dependencyKey(ref)] = true
+	}
+	afterRefs := make(map[string]bool)
+	for _, ref := range after.DependsOn {
+		afterRefs[dependencyKey(ref)] = true
+		if !beforeRefs[dependencyKey(ref)] {
+			diff.AddedDependencies = append(diff.AddedDependencies, ref)
+		}
+	}
+	for _, ref := range before.DependsOn {
+		if !afterRefs[dependencyKey(ref)] {
+			diff.RemovedDependencies = append(diff.RemovedDependencies, ref)
+		}
+	}
+
+	beforeFiles := make(map[string]interface{})
+	for _, ref := range before.Files {
+		beforeFiles[ref.Name] = ref.BlobKey
+	}
+	afterFiles := make(map[string]interface{})
+	for _, ref := range after.Files {
+		afterFiles[ref.Name] = ref.BlobKey
+	}
+	diff.Files = diffLeaves(beforeFiles, afterFiles)
+	return diff
+}
+
+// Compare the values of two flattened contents, keyed by path
+func diffLeaves(before map[string]interface{}, after map[string]interface{}) []*FieldChange {
+	res := make([]*FieldChange, 0)
+	for path, val := range before {
+		afterVal, exists := after[path]
+		switch {
+		case !exists:
+			res = append(res, &FieldChange{Path: path, Op: FieldRemoved, Before: val})
+		case !reflect.DeepEqual(val, afterVal):
+			res = append(res, &FieldChange{Path: path, Op: FieldChanged, Before: val, After: afterVal})
+		}
+	}
+	for path, val := range after {
+		if _, exists := before[path]; !exists {
+			res = append(res, &FieldChange{Path: path, Op: FieldAdded, After: val})
+		}
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].Path < res[j].Path
+	})
+	return res
+}
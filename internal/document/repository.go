@@ -1 +1,119 @@
 package document
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/zhaoy17/ndid/internal/blob"
+	sql "github.com/zhaoy17/ndid/internal/sql"
+)
+
+type DIDDocumentRepository interface {
+	InsertDocuments(documents []*NDIDocument, change *NDIChange, ctx context.Context) error
+	GetDocument(id string, ctx context.Context) (*NDIDocument, error)
+	FindDocuments(className string, condition sql.SqlQueryFunction, ctx context.Context) ([]*NDIDocument, error)
+	UpdateDocument(document *NDIDocument, change *NDIChange, ctx context.Context) error
+	DeleteDocuments(ids []string, mode DeleteMode, change *NDIChange, ctx context.Context) (*DeleteResult, error)
+	GetUpstream(id string, ctx context.Context) (*NDIDependencyTree, error)
+	GetDownstream(id string, ctx context.Context) (*NDIDependencyTree, error)
+	AttachFile(id string, fileName string, blobKey string, change *NDIChange, ctx context.Context) error
+	StoreFile(id string, fileName string, r io.Reader, change *NDIChange, ctx context.Context) (*blob.BlobInfo, error)
+	CollectBlobs(gracePeriod time.Duration, ctx context.Context) ([]string, error)
+	OpenFile(id string, fileName string, ctx context.Context) (io.ReadSeekCloser, *blob.BlobInfo, error)
+	ListVersions(id string, ctx context.Context) ([]*NDIDocumentVersion, error)
+	GetVersion(id string, version int, ctx context.Context) (*NDIDocumentVersion, error)
+	DiffVersions(id string, from int, to int, ctx context.Context) (*NDIDocumentDiff, error)
+	GetDocumentAsOf(id string, t time.Time, ctx context.Context) (*NDIDocument, error)
+	GetDocumentsAsOf(t time.Time, ctx context.Context) ([]*NDIDocument, error)
+	Setup(context.Context) error
+}
+
+// Node of the tree of the documents a document depends on (upstream), or of the documents
+// depending on it (downstream). A document reached through several paths appears once
+// along each of them.
+type NDIDependencyTree struct {
+	DocumentID string
+
+	// Name of the dependency linking the document to its parent, empty for the root
+	DependencyName string
+
+	Children []*NDIDependencyTree
+}
+
+// Get the identifiers of all the documents in the tree but the root, each listed once, in
+// breadth-first order
+func (tree *NDIDependencyTree) DocumentIDs() []string {
+	res := make([]string, 0)
+	seen := map[string]bool{tree.DocumentID: true}
+	queue := tree.Children
+	for len(queue) > 0 {
+		node := queue[0]
+		queue = queue[1:]
+		// a document reached through several paths has the same children each time
+		if seen[node.DocumentID] {
+			continue
+		}
+		seen[node.DocumentID] = true
+		res = append(res, node.DocumentID)
+		queue = append(queue, node.Children...)
+	}
+	return res
+}
+
+// How DeleteDocuments treats the documents depending on the ones to delete
+type DeleteMode int
+
+const (
+	// Fail if any other document depends on the documents to delete
+	DeleteRestrict DeleteMode = iota
+
+	// Delete the documents along with every document depending on them, directly or not
+	DeleteCascade
+
+	// Report the documents DeleteCascade would delete, without deleting anything
+	DeleteDryRun
+)
+
+func (mode DeleteMode) String() string {
+	switch mode {
+	case DeleteRestrict:
+		return "restrict"
+	case DeleteCascade:
+		return "cascade"
+	case DeleteDryRun:
+		return "dry-run"
+	default:
+		return fmt.Sprintf("DeleteMode(%d)", int(mode))
+	}
+}
+
+// Outcome of DeleteDocuments
+type DeleteResult struct {
+	// Identifiers of the documents deleted, or that would be deleted in dry-run mode: the
+	// requested ones followed by their dependents
+	DocumentIDs []string
+
+	// Weather or not the documents have actually been deleted
+	Deleted bool
+}
+
+// Returned by DeleteDocuments in restrict mode when other documents depend on the
+// documents to delete
+type DependentsError struct {
+	// Identifiers of the depending documents, keyed by the identifier of the document they
+	// depend on
+	Dependents map[string][]string
+}
+
+func (err *DependentsError) Error() string {
+	documents := make([]string, 0, len(err.Dependents))
+	for id, dependents := range err.Dependents {
+		documents = append(documents, fmt.Sprintf("%s (needed by %s)", id, strings.Join(dependents, ", ")))
+	}
+	sort.Strings(documents)
+	return "cannot delete documents that other documents depend on: " + strings.Join(documents, "; ")
+}
@@ -0,0 +1,385 @@
+package document
+
+import (
+	"context"
+	dbsql "database/sql"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	sql "github.com/zhaoy17/ndid/internal/sql"
+)
+
+const VERSION_TABLE_NAME = "ndidocument_version"
+const VERSION_FILE_TABLE_NAME = "ndidocument_version_file"
+
+// Version of a document as stored in the history table. The references of the document
+// are stored as JSON along with its content.
+type versionRow struct {
+	DocumentID string    `sql:"document_id"`
+	Version    int       `sql:"version"`
+	ClassName  string    `sql:"class_name"`
+	Content    string    `sql:"content"`
+	DependsOn  string    `sql:"depends_on"`
+	Files      string    `sql:"files"`
+	Deleted    int       `sql:"deleted"`
+	Author     string    `sql:"author"`
+	Reason     string    `sql:"reason"`
+	CreatedAt  time.Time `sql:"created_at"`
+}
+
+var versionColumns = []string{"document_id", "version", "class_name", "content", "depends_on", "files",
+	"deleted", "author", "reason", "created_at"}
+
+// Reference as stored in the history table
+type storedReference struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+// Get the versions of the document, oldest first. The history of a deleted document is
+// kept, and ends with the version recording its deletion.
+func (documentRepository *SQLDocumentRepository) ListVersions(id string, ctx context.Context) ([]*NDIDocumentVersion, error) {
+	stmt, err := (&sql.SelectStmt{
+		Dialect:        documentRepository.db.Dialect,
+		ColumnsToQuery: versionColumns,
+		Tables:         []string{VERSION_TABLE_NAME},
+		QueryCondition: sql.SQLEqual("", "document_id", id),
+		OrderBy:        []sql.OrderByColumn{sql.Ascending("version")},
+	}).GenerateStmt()
+	if err != nil {
+		return nil, err
+	}
+	rows, err := sql.QueryStructs[versionRow](documentRepository.db, stmt, ctx)
+	if err != nil {
+		return nil, err
+	}
+	if len(rows) == 0 {
+		return nil, fmt.Errorf("%w: %s", ErrDocumentNotFound, id)
+	}
+	res := make([]*NDIDocumentVersion, len(rows))
+	for i, row := range rows {
+		if res[i], err = row.toVersion(); err != nil {
+			return nil, err
+		}
+	}
+	return res, nil
+}
+
+// Get the given version of the document
+func (documentRepository *SQLDocumentRepository) GetVersion(id string, version int, ctx context.Context) (*NDIDocumentVersion, error) {
+	stmt, err := (&sql.SelectStmt{
+		Dialect:        documentRepository.db.Dialect,
+		ColumnsToQuery: versionColumns,
+		Tables:         []string{VERSION_TABLE_NAME},
+		QueryCondition: sql.SQLAnd(sql.SQLEqual("", "document_id", id), sql.SQLEqual("", "version", strconv.Itoa(version))),
+	}).GenerateStmt()
+	if err != nil {
+		return nil, err
+	}
+	rows, err := sql.QueryStructs[versionRow](documentRepository.db, stmt, ctx)
+	if err != nil {
+		return nil, err
+	}
+	if len(rows) == 0 {
+		return nil, fmt.Errorf("%w: version %d of document %s", ErrDocumentNotFound, version, id)
+	}
+	return rows[0].toVersion()
+}
+
+// Compare two versions of the document
+func (documentRepository *SQLDocumentRepository) DiffVersions(id string, from int, to int, ctx context.Context) (*NDIDocumentDiff, error) {
+	fromVersion, err := documentRepository.GetVersion(id, from, ctx)
+	if err != nil {
+		return nil, err
+	}
+	toVersion, err := documentRepository.GetVersion(id, to, ctx)
+	if err != nil {
+		return nil, err
+	}
+	return diffVersions(fromVersion, toVersion), nil
+}
+
+// Get the document as it was at t. Return ErrDocumentNotFound if it did not exist then.
+func (documentRepository *SQLDocumentRepository) GetDocumentAsOf(id string, t time.Time, ctx context.Context) (*NDIDocument, error) {
+	stmt, err := (&sql.SelectStmt{
+		Dialect:        documentRepository.db.Dialect,
+		ColumnsToQuery: versionColumns,
+		Tables:         []string{VERSION_TABLE_NAME},
+		QueryCondition: sql.SQLAnd(sql.SQLEqual("", "document_id", id), sql.SQLAtOrBefore("", "created_at", t)),
+		OrderBy:        []sql.OrderByColumn{sql.Descending("version")},
+		Limit:          1,
+	}).GenerateStmt()
+	if err != nil {
+		return nil, err
+	}
+	rows, err := sql.QueryStructs[versionRow](documentRepository.db, stmt, ctx)
+	if err != nil {
+		return nil, err
+	}
+	if len(rows) == 0 || rows[0].Deleted != 0 {
+		return nil, fmt.Errorf("%w: %s as of %s", ErrDocumentNotFound, id, t.UTC().Format(time.RFC3339Nano))
+	}
+	version, err := rows[0].toVersion()
+	if err != nil {
+		return nil, err
+	}
+	return version.Document, nil
+}
+
+// Get all the documents as they were at t, ordered by identifier
+func (documentRepository *SQLDocumentRepository) GetDocumentsAsOf(t time.Time, ctx context.Context) ([]*NDIDocument, error) {
+	var placeholders [2]string
+	for i := range placeholders {
+		placeholder, err := documentRepository.db.Dialect.Placeholder(i + 1)
+		if err != nil {
+			return nil, err
+		}
+		placeholders[i] = placeholder
+	}
+	asOf := t.UTC().Format(sql.SqlDateTimeLayout)
+	quote := documentRepository.db.Dialect.QuoteIdentifier
+	columns := make([]string, len(versionColumns))
+	for i, col := range versionColumns {
+		columns[i] = "v." + quote(col)
+	}
+	table, documentID, version, createdAt := quote(VERSION_TABLE_NAME), quote("document_id"), quote("version"), quote("created_at")
+	// the latest version of each document created at or before t
+	stmt := &sql.SqlStmt{
+		Stmt: fmt.Sprintf(`SELECT %s
+FROM %s v
+WHERE v.%s <= %s AND v.%s = 0 AND v.%s = (
+	SELECT MAX(w.%s) FROM %s w WHERE w.%s = v.%s AND w.%s <= %s
+)
+ORDER BY v.%s;`, strings.Join(columns, ", "), table,
+			createdAt, placeholders[0], quote("deleted"), version,
+			version, table, documentID, documentID, createdAt, placeholders[1],
+			documentID),
+		Params: []string{asOf, asOf},
+	}
+	rows, err := sql.QueryStructs[versionRow](documentRepository.db, stmt, ctx)
+	if err != nil {
+		return nil, err
+	}
+	res := make([]*NDIDocument, len(rows))
+	for i, row := range rows {
+		version, err := row.toVersion()
+		if err != nil {
+			return nil, err
+		}
+		res[i] = version.Document
+	}
+	return res, nil
+}
+
+// Reason recorded with the first version of the documents stored before their history
+// was kept
+const historyStartedReason = "history started"
+
+// Record the current state of every document without history as its first version. The
+// time of the version is the time of the migration, since the one of the last change to
+// the document is unknown.
+func (documentRepository *SQLDocumentRepository) seedVersions(ctx context.Context) error {
+	quote := documentRepository.db.Dialect.QuoteIdentifier
+	stmt := &sql.SqlStmt{
+		Stmt: fmt.Sprintf(`SELECT d.%s
+FROM %s d
+WHERE NOT EXISTS (SELECT 1 FROM %s v WHERE v.%s = d.%s)
+ORDER BY d.%s;`, quote("id"), quote(DOCUMENT_TABLE_NAME), quote(VERSION_TABLE_NAME),
+			quote("document_id"), quote("id"), quote("id")),
+	}
+	return documentRepository.db.WithTransaction(ctx, dbsql.LevelDefault, func(ctx context.Context, tx *sql.TransactionManager) error {
+		rows, err := sql.QueryStructs[documentRow](documentRepository.db, stmt, ctx)
+		if err != nil {
+			return err
+		}
+		for _, row := range rows {
+			if err := documentRepository.recordVersion(row.ID, &NDIChange{Reason: historyStartedReason}, ctx); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Add the current state of the document to its history
+func (documentRepository *SQLDocumentRepository) recordVersion(id string, change *NDIChange, ctx context.Context) error {
+	document, err := documentRepository.GetDocument(id, ctx)
+	if err != nil {
+		return err
+	}
+	return documentRepository.insertVersion(id, document.ClassName, document, change, ctx)
+}
+
+// Add the deletion of the document to its history
+func (documentRepository *SQLDocumentRepository) recordDeletion(id string, className string, change *NDIChange, ctx context.Context) error {
+	return documentRepository.insertVersion(id, className, nil, change, ctx)
+}
+
+// Insert the next version of the document, numbered after the latest one in the same
+// statement. The row of the document is locked first, so that concurrent writes to the
+// document wait for each other instead of both reading the same latest version. The blobs of the files of the version are
+// recorded in a table of their own, so that they are kept as long as the version refers
+// to them (see deleteBlobIfUnreferenced).
+func (documentRepository *SQLDocumentRepository) insertVersion(id string, className string, document *NDIDocument, change *NDIChange, ctx context.Context) error {
+	if change == nil {
+		change = &NDIChange{}
+	}
+	if err := documentRepository.lockDocument(id, ctx); err != nil {
+		return err
+	}
+	content, dependsOn, files, deleted := "{}", "[]", "[]", "1"
+	if document != nil {
+		encodedContent, err := json.Marshal(document.Content)
+		if err != nil {
+			return err
+		}
+		references := make([]storedReference, len(document.DependsOn))
+		for i, ref := range document.DependsOn {
+			references[i] = storedReference{Name: ref.Name, Value: ref.DocumentID}
+		}
+		encodedDependsOn, err := json.Marshal(references)
+		if err != nil {
+			return err
+		}
+		references = make([]storedReference, len(document.Files))
+		for i, ref := range document.Files {
+			references[i] = storedReference{Name: ref.Name, Value: ref.BlobKey}
+		}
+		encodedFiles, err := json.Marshal(references)
+		if err != nil {
+			return err
+		}
+		content, dependsOn, files, deleted = string(encodedContent), string(encodedDependsOn), string(encodedFiles), "0"
+	}
+	params := []string{id, className, content, dependsOn, files, deleted,
+		change.Author, change.Reason, time.Now().UTC().Format(sql.SqlDateTimeLayout), id}
+	placeholders := make([]string, len(params))
+	for i := range params {
+		placeholder, err := documentRepository.db.Dialect.Placeholder(i + 1)
+		if err != nil {
+			return err
+		}
+		placeholders[i] = placeholder
+	}
+	quote := documentRepository.db.Dialect.QuoteIdentifier
+	columns := make([]string, len(versionColumns))
+	for i, col := range versionColumns {
+		columns[i] = quote(col)
+	}
+	// the version is the second of versionColumns
+	stmt := &sql.SqlStmt{
+		Stmt: fmt.Sprintf(`INSERT INTO %s (%s)
+SELECT %s, COALESCE(MAX(%s), 0) + 1, %s
+FROM %s
+WHERE %s = %s;`, quote(VERSION_TABLE_NAME), strings.Join(columns, ", "),
+			placeholders[0], quote("version"), strings.Join(placeholders[1:len(placeholders)-1], ", "),
+			quote(VERSION_TABLE_NAME), quote("document_id"), placeholders[len(placeholders)-1]),
+		Params: params,
+	}
+	if _, err := documentRepository.db.ExecuteSQL(stmt, ctx); err != nil {
+		return err
+	}
+	if document == nil || len(document.Files) == 0 {
+		return nil
+	}
+	version, err := documentRepository.latestVersion(id, ctx)
+	if err != nil {
+		return err
+	}
+	for _, ref := range document.Files {
+		stmt, err := (&sql.InsertStmt{
+			Dialect: documentRepository.db.Dialect,
+			Table:   VERSION_FILE_TABLE_NAME,
+			Columns: []string{"document_id", "version", "file_name", "blob_key"},
+			Values:  []string{id, strconv.Itoa(version), ref.Name, ref.BlobKey},
+		}).GenerateStmt()
+		if err != nil {
+			return err
+		}
+		if _, err := documentRepository.db.ExecuteSQL(stmt, ctx); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Lock the row of the document until the end of the transaction, if it still exists.
+// Deleted documents need not be locked, since the statement deleting their row already
+// holds the lock.
+func (documentRepository *SQLDocumentRepository) lockDocument(id string, ctx context.Context) error {
+	placeholder, err := documentRepository.db.Dialect.Placeholder(1)
+	if err != nil {
+		return err
+	}
+	quote := documentRepository.db.Dialect.QuoteIdentifier
+	stmt := &sql.SqlStmt{
+		Stmt: fmt.Sprintf("UPDATE %s SET %s = %s WHERE %s = %s;", quote(DOCUMENT_TABLE_NAME),
+			quote("class_name"), quote("class_name"), quote("id"), placeholder),
+		Params: []string{id},
+	}
+	_, err = documentRepository.db.ExecuteSQL(stmt, ctx)
+	return err
+}
+
+// Get the number of the latest version of the document, 0 if it has no history
+func (documentRepository *SQLDocumentRepository) latestVersion(id string, ctx context.Context) (int, error) {
+	stmt, err := (&sql.SelectStmt{
+		Dialect:        documentRepository.db.Dialect,
+		ColumnsToQuery: []string{"version"},
+		Tables:         []string{VERSION_TABLE_NAME},
+		QueryCondition: sql.SQLEqual("", "document_id", id),
+		OrderBy:        []sql.OrderByColumn{sql.Descending("version")},
+		Limit:          1,
+	}).GenerateStmt()
+	if err != nil {
+		return 0, err
+	}
+	rows, err := sql.QueryStructs[versionRow](documentRepository.db, stmt, ctx)
+	if err != nil || len(rows) == 0 {
+		return 0, err
+	}
+	return rows[0].Version, nil
+}
+
+func (row *versionRow) toVersion() (*NDIDocumentVersion, error) {
+	version := &NDIDocumentVersion{
+		DocumentID: row.DocumentID,
+		Version:    row.Version,
+		Deleted:    row.Deleted != 0,
+		Author:     row.Author,
+		Reason:     row.Reason,
+		CreatedAt:  row.CreatedAt.UTC(),
+	}
+	if version.Deleted {
+		return version, nil
+	}
+	content, err := decodeContent(row.Content)
+	if err != nil {
+		return nil, fmt.Errorf("content of version %d of document %s: %w", row.Version, row.DocumentID, err)
+	}
+	var dependsOn, files []storedReference
+	if err := json.Unmarshal([]byte(row.DependsOn), &dependsOn); err != nil {
+		return nil, fmt.Errorf("dependencies of version %d of document %s: %w", row.Version, row.DocumentID, err)
+	}
+	if err := json.Unmarshal([]byte(row.Files), &files); err != nil {
+		return nil, fmt.Errorf("files of version %d of document %s: %w", row.Version, row.DocumentID, err)
+	}
+	document := &NDIDocument{
+		ID:        row.DocumentID,
+		ClassName: row.ClassName,
+		Content:   content,
+		DependsOn: make([]*NDIDependencyReference, len(dependsOn)),
+		Files:     make([]*NDIFileReference, len(files)),
+	}
+	for i, ref := range dependsOn {
+		document.DependsOn[i] = &NDIDependencyReference{Name: ref.Name, DocumentID: ref.Value}
+	}
+	for i, ref := range files {
+		document.Files[i] = &NDIFileReference{Name: ref.Name, BlobKey: ref.Value}
+	}
+	version.Document = document
+	return version, nil
+}
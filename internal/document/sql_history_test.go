@@ -0,0 +1,302 @@
+package document
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/zhaoy17/ndid/internal/schema"
+	sql "github.com/zhaoy17/ndid/internal/sql"
+)
+
+var historyTime = time.Date(2023, 4, 1, 12, 0, 0, 0, time.UTC)
+
+func TestDiffVersions(t *testing.T) {
+	from := &NDIDocumentVersion{DocumentID: "doc", Version: 1, Document: &NDIDocument{
+		Content: map[string]interface{}{
+			"name":     "probe",
+			"epoch":    map[string]interface{}{"t0": "start", "t1": "end"},
+			"channels": []interface{}{map[string]interface{}{"name": "a"}},
+		},
+		DependsOn: []*NDIDependencyReference{{Name: "subject", DocumentID: "s1"}, {Name: "session", DocumentID: "x"}},
+		Files:     []*NDIFileReference{{Name: "data", BlobKey: "k1"}, {Name: "notes", BlobKey: "k2"}},
+	}}
+	to := &NDIDocumentVersion{DocumentID: "doc", Version: 3, Document: &NDIDocument{
+		Content: map[string]interface{}{
+			"name":     "probe",
+			"epoch":    map[string]interface{}{"t0": "restart"},
+			"channels": []interface{}{map[string]interface{}{"name": "a"}, map[string]interface{}{"name": "b"}},
+			"tags":     []interface{}{},
+		},
+		DependsOn: []*NDIDependencyReference{{Name: "subject", DocumentID: "s2"}, {Name: "session", DocumentID: "x"}},
+		Files:     []*NDIFileReference{{Name: "data", BlobKey: "k3"}},
+	}}
+
+	diff := diffVersions(from, to)
+	if diff.DocumentID != "doc" || diff.From != 1 || diff.To != 3 {
+		t.Fatalf("got diff of %s from %d to %d", diff.DocumentID, diff.From, diff.To)
+	}
+	assertChanges(t, diff.Content, []*FieldChange{
+		{Path: "channels[1].name", Op: FieldAdded, After: "b"},
+		{Path: "epoch.t0", Op: FieldChanged, Before: "start", After: "restart"},
+		{Path: "epoch.t1", Op: FieldRemoved, Before: "end"},
+		{Path: "tags", Op: FieldAdded, After: []interface{}{}},
+	})
+	assertChanges(t, diff.Files, []*FieldChange{
+		{Path: "data", Op: FieldChanged, Before: "k1", After: "k3"},
+		{Path: "notes", Op: FieldRemoved, Before: "k2"},
+	})
+	if len(diff.AddedDependencies) != 1 || diff.AddedDependencies[0].DocumentID != "s2" {
+		t.Fatalf("got added dependencies %v", diff.AddedDependencies)
+	}
+	if len(diff.RemovedDependencies) != 1 || diff.RemovedDependencies[0].DocumentID != "s1" {
+		t.Fatalf("got removed dependencies %v", diff.RemovedDependencies)
+	}
+
+	// a deletion is compared as an empty document
+	deleted := &NDIDocumentVersion{DocumentID: "doc", Version: 4, Deleted: true}
+	diff = diffVersions(to, deleted)
+	if len(diff.Content) != 5 || len(diff.Files) != 1 || len(diff.RemovedDependencies) != 2 {
+		t.Fatalf("deletion removed %d fields, %d files and %d dependencies", len(diff.Content), len(diff.Files), len(diff.RemovedDependencies))
+	}
+	for _, change := range append(diff.Content, diff.Files...) {
+		if change.Op != FieldRemoved {
+			t.Fatalf("got %s %s on deletion", change.Path, change.Op)
+		}
+	}
+}
+
+func assertChanges(t *testing.T, got []*FieldChange, want []*FieldChange) {
+	t.Helper()
+	if len(got) != len(want) {
+		t.Fatalf("got %d changes, want %d: %s", len(got), len(want), describeChanges(got))
+	}
+	for i := range want {
+		if !reflect.DeepEqual(got[i], want[i]) {
+			t.Fatalf("got change %+v, want %+v", got[i], want[i])
+		}
+	}
+}
+
+func describeChanges(changes []*FieldChange) string {
+	res := ""
+	for _, change := range changes {
+		res += fmt.Sprintf("%+v ", *change)
+	}
+	return res
+}
+
+var noteClass = &schema.NDISchema{
+	SchemaName:           "note",
+	Superclasses:         []*schema.NDISchema{baseClass},
+	AdditionalProperties: true,
+}
+
+// Move the version of the document to the given time, since versions are recorded at the
+// time they are written
+func setVersionTime(t *testing.T, repo *SQLDocumentRepository, id string, version int, at time.Time) {
+	t.Helper()
+	dialect := repo.db.Dialect
+	var placeholders [3]string
+	for i := range placeholders {
+		placeholders[i], _ = dialect.Placeholder(i + 1)
+	}
+	stmt := &sql.SqlStmt{
+		Stmt: fmt.Sprintf("UPDATE %s SET %s = %s WHERE %s = %s AND %s = %s;",
+			dialect.QuoteIdentifier(VERSION_TABLE_NAME), dialect.QuoteIdentifier("created_at"), placeholders[0],
+			dialect.QuoteIdentifier("document_id"), placeholders[1], dialect.QuoteIdentifier("version"), placeholders[2]),
+		Params: []string{at.UTC().Format(sql.SqlDateTimeLayout), id, strconv.Itoa(version)},
+	}
+	rows, err := repo.db.ExecuteSQL(stmt, context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rows != 1 {
+		t.Fatalf("moved %d versions of %s, want 1", rows, id)
+	}
+}
+
+func TestGetDocumentAsOf(t *testing.T) {
+	repo := newTestRepository(t, sql.SqlLite, baseClass, noteClass)
+	ctx := context.Background()
+	note := newTestDocument(t, noteClass, map[string]interface{}{"text": "first"})
+	if err := repo.InsertDocuments([]*NDIDocument{note}, nil, ctx); err != nil {
+		t.Fatal(err)
+	}
+	note.Content["text"] = "second"
+	if err := repo.UpdateDocument(note, nil, ctx); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := repo.DeleteDocuments([]string{note.ID}, DeleteRestrict, nil, ctx); err != nil {
+		t.Fatal(err)
+	}
+	for version := 1; version <= 3; version++ {
+		setVersionTime(t, repo, note.ID, version, historyTime.Add(time.Duration(version-1)*time.Hour))
+	}
+
+	tests := []struct {
+		name string
+		at   time.Time
+		want string
+	}{
+		{"BeforeInsertion", historyTime.Add(-time.Second), ""},
+		{"AtInsertion", historyTime, "first"},
+		{"BetweenVersions", historyTime.Add(30 * time.Minute), "first"},
+		{"AtUpdate", historyTime.Add(time.Hour), "second"},
+		{"AtDeletion", historyTime.Add(2 * time.Hour), ""},
+		{"AfterDeletion", historyTime.Add(3 * time.Hour), ""},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			document, err := repo.GetDocumentAsOf(note.ID, test.at, ctx)
+			if test.want == "" {
+				if !errors.Is(err, ErrDocumentNotFound) {
+					t.Fatalf("got %v, want ErrDocumentNotFound", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if text := document.Content["text"]; text != test.want {
+				t.Fatalf("got text %v, want %s", text, test.want)
+			}
+		})
+	}
+}
+
+func TestGetDocumentsAsOf(t *testing.T) {
+	repo := newTestRepository(t, sql.SqlLite, baseClass, noteClass)
+	ctx := context.Background()
+	kept := newTestDocument(t, noteClass, map[string]interface{}{"text": "kept"})
+	deleted := newTestDocument(t, noteClass, map[string]interface{}{"text": "deleted"})
+	if err := repo.InsertDocuments([]*NDIDocument{kept, deleted}, nil, ctx); err != nil {
+		t.Fatal(err)
+	}
+	kept.Content["text"] = "kept, updated"
+	if err := repo.UpdateDocument(kept, nil, ctx); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := repo.DeleteDocuments([]string{deleted.ID}, DeleteRestrict, nil, ctx); err != nil {
+		t.Fatal(err)
+	}
+	setVersionTime(t, repo, kept.ID, 1, historyTime)
+	setVersionTime(t, repo, deleted.ID, 1, historyTime.Add(time.Hour))
+	setVersionTime(t, repo, kept.ID, 2, historyTime.Add(2*time.Hour))
+	setVersionTime(t, repo, deleted.ID, 2, historyTime.Add(2*time.Hour))
+
+	tests := []struct {
+		name string
+		at   time.Time
+		want map[string]string
+	}{
+		{"BeforeAll", historyTime.Add(-time.Second), map[string]string{}},
+		{"AtFirstInsertion", historyTime, map[string]string{kept.ID: "kept"}},
+		{"AtSecondInsertion", historyTime.Add(time.Hour), map[string]string{kept.ID: "kept", deleted.ID: "deleted"}},
+		{"AtUpdateAndDeletion", historyTime.Add(2 * time.Hour), map[string]string{kept.ID: "kept, updated"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			documents, err := repo.GetDocumentsAsOf(test.at, ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := make(map[string]string)
+			for i, document := range documents {
+				if i > 0 && documents[i-1].ID >= document.ID {
+					t.Fatalf("documents are not ordered by identifier")
+				}
+				got[document.ID] = fmt.Sprint(document.Content["text"])
+			}
+			if !reflect.DeepEqual(got, test.want) {
+				t.Fatalf("got %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+// Documents stored before the history table existed get their first version from Setup,
+// once
+func TestSetupSeedsVersions(t *testing.T) {
+	repo := newTestRepository(t, sql.SqlLite, baseClass, noteClass)
+	ctx := context.Background()
+	notes := []*NDIDocument{
+		newTestDocument(t, noteClass, map[string]interface{}{"text": "a"}),
+		newTestDocument(t, noteClass, map[string]interface{}{"text": "b"}),
+	}
+	if err := repo.InsertDocuments(notes, nil, ctx); err != nil {
+		t.Fatal(err)
+	}
+	stmt := &sql.SqlStmt{Stmt: fmt.Sprintf("DELETE FROM %s;", repo.db.Dialect.QuoteIdentifier(VERSION_TABLE_NAME))}
+	if _, err := repo.db.ExecuteSQL(stmt, ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	for run := 0; run < 2; run++ {
+		if err := repo.Setup(ctx); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, note := range notes {
+		versions, err := repo.ListVersions(note.ID, ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(versions) != 1 || versions[0].Version != 1 || versions[0].Reason != historyStartedReason {
+			t.Fatalf("got versions %+v of %s, want a single seeded one", versions, note.ID)
+		}
+		if text := versions[0].Document.Content["text"]; text != note.Content["text"] {
+			t.Fatalf("seeded version has text %v, want %v", text, note.Content["text"])
+		}
+	}
+
+	notes[0].Content["text"] = "changed"
+	if err := repo.UpdateDocument(notes[0], nil, ctx); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := repo.GetVersion(notes[0].ID, 2, ctx); err != nil {
+		t.Fatalf("history did not continue after the seeded version: %v", err)
+	}
+}
+
+// Concurrent changes to a document are numbered one after the other
+func TestConcurrentVersions(t *testing.T) {
+	const writers = 8
+	repo := newTestRepository(t, sql.SqlLite, baseClass, noteClass)
+	ctx := context.Background()
+	note := newTestDocument(t, noteClass, map[string]interface{}{"text": "a"})
+	if err := repo.InsertDocuments([]*NDIDocument{note}, nil, ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < writers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			update := &NDIDocument{ID: note.ID, ClassName: note.ClassName, Content: map[string]interface{}{"text": strconv.Itoa(i)}}
+			if err := repo.UpdateDocument(update, &NDIChange{Reason: strconv.Itoa(i)}, ctx); err != nil {
+				t.Error(err)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	versions, err := repo.ListVersions(note.ID, ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(versions) != writers+1 {
+		t.Fatalf("got %d versions, want %d", len(versions), writers+1)
+	}
+	for i, version := range versions {
+		if version.Version != i+1 {
+			t.Fatalf("version %d is numbered %d", i+1, version.Version)
+		}
+	}
+}
@@ -0,0 +1,390 @@
+package document
+
+import (
+	"context"
+	dbsql "database/sql"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/zhaoy17/ndid/internal/schema"
+	sql "github.com/zhaoy17/ndid/internal/sql"
+)
+
+// Get the documents of the class, including the ones of its subclasses, whose querable
+// fields match condition, ordered by identifier. The fields are referred to by the column
+// schema.ColumnNameForPath gives for their path, which is the path itself unless it is too
+// long, e.g. sql.SQLBefore("", "epoch.t0", t), and hold their normalized values: timestamps
+// in UTC and quantities in their canonical unit. All the documents of the class are
+// returned if condition is nil.
+func (documentRepository *SQLDocumentRepository) FindDocuments(className string, condition sql.SqlQueryFunction, ctx context.Context) ([]*NDIDocument, error) {
+	class, err := documentRepository.schemas(className, ctx)
+	if err != nil {
+		return nil, err
+	}
+	if err := documentRepository.ensureQuerableTable(class, ctx); err != nil {
+		return nil, err
+	}
+	return documentRepository.queryDocuments(&sql.SelectStmt{
+		Dialect:        documentRepository.db.Dialect,
+		ColumnsToQuery: []string{"id"},
+		Tables:         []string{schema.TableNameForSchema(class.SchemaName)},
+		QueryCondition: condition,
+	}, ctx)
+}
+
+// Get the documents whose identifier is selected by match, ordered by identifier. The
+// documents, their references and their files are each read with a single query joining
+// their table with match, which must select the id column only and must not be sorted.
+func (documentRepository *SQLDocumentRepository) queryDocuments(match *sql.SelectStmt, ctx context.Context) ([]*NDIDocument, error) {
+	matchStmt, err := match.GenerateStmt()
+	if err != nil {
+		return nil, err
+	}
+	quote := documentRepository.db.Dialect.QuoteIdentifier
+	subquery := strings.TrimSuffix(matchStmt.Stmt, ";")
+	joined := func(columns string, table string, idCol string, orderBy string) *sql.SqlStmt {
+		return &sql.SqlStmt{
+			Stmt: fmt.Sprintf("SELECT %s\nFROM %s t INNER JOIN (%s) m ON t.%s = m.id\nORDER BY %s;",
+				columns, quote(table), subquery, idCol, orderBy),
+			Params: matchStmt.Params,
+		}
+	}
+
+	rows, err := sql.QueryStructs[documentRow](documentRepository.db,
+		joined("t.id, t.class_name, t.content", DOCUMENT_TABLE_NAME, "id", "t.id"), ctx)
+	if err != nil {
+		return nil, err
+	}
+	edges, err := sql.QueryStructs[dependencyEdge](documentRepository.db,
+		joined("t.document_id, t.dependency_name, t.depends_on_id", DEPENDENCY_TABLE_NAME, "document_id",
+			"t.document_id, t.dependency_name, t.depends_on_id"), ctx)
+	if err != nil {
+		return nil, err
+	}
+	files, err := sql.QueryStructs[fileRow](documentRepository.db,
+		joined("t.document_id, t.file_name, t.blob_key", FILE_TABLE_NAME, "document_id",
+			"t.document_id, t.file_name"), ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	edgesByID := make(map[string][]dependencyEdge)
+	for _, edge := range edges {
+		edgesByID[edge.DocumentID] = append(edgesByID[edge.DocumentID], edge)
+	}
+	filesByID := make(map[string][]fileRow)
+	for _, file := range files {
+		filesByID[file.DocumentID] = append(filesByID[file.DocumentID], file)
+	}
+	res := make([]*NDIDocument, len(rows))
+	for i, row := range rows {
+		if res[i], err = newDocumentFromRows(row, edgesByID[row.ID], filesByID[row.ID]); err != nil {
+			return nil, err
+		}
+	}
+	return res, nil
+}
+
+// Get the class of the document and its ancestors, each of which has a querable table the
+// document is stored in
+func (documentRepository *SQLDocumentRepository) querableClasses(className string, ctx context.Context) ([]*schema.NDISchema, error) {
+	class, err := documentRepository.schemas(className, ctx)
+	if err != nil {
+		return nil, err
+	}
+	return class.Linearization()
+}
+
+// Create the querable tables of the class and its ancestors, or add the columns they miss.
+// Creating tables inside a transaction commits it on MySQL, so the tables cannot be
+// created once ctx carries a transaction: call EnsureQuerableTables before writing
+// documents of a new class, or documents whose class gained querable fields, inside a
+// transaction of your own. The repository does so before the transactions it starts.
+func (documentRepository *SQLDocumentRepository) EnsureQuerableTables(className string, ctx context.Context) error {
+	classes, err := documentRepository.querableClasses(className, ctx)
+	if err != nil {
+		return err
+	}
+	for _, class := range classes {
+		if err := documentRepository.ensureQuerableTable(class, ctx); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Make sure the querable table of the class has a column for each of its querable fields.
+// The documents of the class are stored in the table if it had to be created or given new
+// columns, since they have no value there yet. If they cannot be, the table is dropped so
+// that it is filled next time rather than left incomplete. Only the table is locked while
+// this is done, and only the check runs if ctx carries a transaction.
+func (documentRepository *SQLDocumentRepository) ensureQuerableTable(class *schema.NDISchema, ctx context.Context) error {
+	columns, err := class.QuerableColumns()
+	if err != nil {
+		return err
+	}
+	table := schema.TableNameForSchema(class.SchemaName)
+	if documentRepository.hasQuerableColumns(table, columns) {
+		return nil
+	}
+
+	if sql.InTransaction(ctx) {
+		existing, err := documentRepository.describeQuerableTable(table, ctx)
+		if err != nil {
+			return fmt.Errorf("querable table of class %s cannot be created inside a transaction: %w", class.SchemaName, err)
+		}
+		if missing := missingColumns(columns, existing); len(missing) > 0 {
+			return fmt.Errorf("querable table of class %s lacks columns %s, which cannot be added inside a transaction",
+				class.SchemaName, strings.Join(missing, ", "))
+		}
+		documentRepository.setQuerableColumns(table, existing)
+		return nil
+	}
+
+	lock := documentRepository.querableTableLock(table)
+	lock.Lock()
+	defer lock.Unlock()
+	// another caller may have been completing the table meanwhile
+	if documentRepository.hasQuerableColumns(table, columns) {
+		return nil
+	}
+	existing, err := documentRepository.describeQuerableTable(table, ctx)
+	created := err != nil
+	if created {
+		// the table does not exist yet
+		tableSchema := sql.TableSchema{
+			TableName:  table,
+			Columns:    map[string]sql.SqlDataType{"id": &sql.SqlText{Len: 33, NotNull: true}},
+			PrimaryKey: []string{"id"},
+		}
+		for col, dataType := range columns {
+			tableSchema.Columns[col] = dataType
+		}
+		stmt, err := (&sql.CreateTableStmt{Dialect: documentRepository.db.Dialect, TableSchema: tableSchema, IfNotExists: true}).GenerateStmt()
+		if err != nil {
+			return err
+		}
+		if _, err := documentRepository.db.ExecuteSQL(stmt, ctx); err != nil {
+			return err
+		}
+		if existing, err = documentRepository.describeQuerableTable(table, ctx); err != nil {
+			return err
+		}
+	}
+	missing := missingColumns(columns, existing)
+	for _, col := range missing {
+		stmt, err := (&sql.AddColumnStmt{
+			Dialect:  documentRepository.db.Dialect,
+			Table:    table,
+			Column:   col,
+			DataType: columns[col],
+		}).GenerateStmt()
+		if err != nil {
+			return err
+		}
+		if _, err := documentRepository.db.ExecuteSQL(stmt, ctx); err != nil {
+			return err
+		}
+		existing[col] = true
+	}
+	if created || len(missing) > 0 {
+		if err := documentRepository.reindexClass(class, ctx); err != nil {
+			drop := &sql.SqlStmt{Stmt: fmt.Sprintf("DROP TABLE %s;", documentRepository.db.Dialect.QuoteIdentifier(table))}
+			if _, dropErr := documentRepository.db.ExecuteSQL(drop, ctx); dropErr != nil {
+				return fmt.Errorf("%w (dropping querable table %s failed: %v)", err, table, dropErr)
+			}
+			return err
+		}
+	}
+	documentRepository.setQuerableColumns(table, existing)
+	return nil
+}
+
+// Report whether the querable table is known to have all the columns
+func (documentRepository *SQLDocumentRepository) hasQuerableColumns(table string, columns map[string]sql.SqlDataType) bool {
+	documentRepository.querableMu.Lock()
+	defer documentRepository.querableMu.Unlock()
+	known := documentRepository.querableColumns[table]
+	return known != nil && len(missingColumns(columns, known)) == 0
+}
+
+func (documentRepository *SQLDocumentRepository) setQuerableColumns(table string, columns map[string]bool) {
+	documentRepository.querableMu.Lock()
+	defer documentRepository.querableMu.Unlock()
+	documentRepository.querableColumns[table] = columns
+}
+
+// Get the lock held while the querable table is created or altered
+func (documentRepository *SQLDocumentRepository) querableTableLock(table string) *sync.Mutex {
+	documentRepository.querableMu.Lock()
+	defer documentRepository.querableMu.Unlock()
+	lock, ok := documentRepository.querableLocks[table]
+	if !ok {
+		lock = &sync.Mutex{}
+		documentRepository.querableLocks[table] = lock
+	}
+	return lock
+}
+
+// Get the columns that are not among existing, sorted
+func missingColumns(columns map[string]sql.SqlDataType, existing map[string]bool) []string {
+	missing := make([]string, 0)
+	for col := range columns {
+		if !existing[col] {
+			missing = append(missing, col)
+		}
+	}
+	sort.Strings(missing)
+	return missing
+}
+
+// Get the columns of the querable table. Fail if the table does not exist.
+func (documentRepository *SQLDocumentRepository) describeQuerableTable(table string, ctx context.Context) (map[string]bool, error) {
+	stmt, err := (&sql.SelectStmt{
+		Dialect: documentRepository.db.Dialect,
+		Tables:  []string{table},
+		Limit:   1,
+	}).GenerateStmt()
+	if err != nil {
+		return nil, err
+	}
+	iter, err := documentRepository.db.QueryRows(stmt, ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer iter.Close()
+	columns := make(map[string]bool)
+	for _, col := range iter.Columns() {
+		columns[col] = true
+	}
+	return columns, nil
+}
+
+type classNameRow struct {
+	ClassName string `sql:"class_name"`
+}
+
+// Store every document of the class, or of one of its subclasses, in the querable table
+// of the class. The documents are read one class at a time, and only for the classes
+// stored documents belong to.
+func (documentRepository *SQLDocumentRepository) reindexClass(class *schema.NDISchema, ctx context.Context) error {
+	quote := documentRepository.db.Dialect.QuoteIdentifier
+	stmt := &sql.SqlStmt{Stmt: fmt.Sprintf("SELECT DISTINCT %s FROM %s;", quote("class_name"), quote(DOCUMENT_TABLE_NAME))}
+	rows, err := sql.QueryStructs[classNameRow](documentRepository.db, stmt, ctx)
+	if err != nil {
+		return err
+	}
+	return documentRepository.db.WithTransaction(ctx, dbsql.LevelDefault, func(ctx context.Context, tx *sql.TransactionManager) error {
+		for _, row := range rows {
+			documentClass, err := documentRepository.schemas(row.ClassName, ctx)
+			if err != nil {
+				return err
+			}
+			if !documentClass.IsSubclassOf(class.SchemaName) {
+				continue
+			}
+			documents, err := documentRepository.queryDocuments(&sql.SelectStmt{
+				Dialect:        documentRepository.db.Dialect,
+				ColumnsToQuery: []string{"id"},
+				Tables:         []string{DOCUMENT_TABLE_NAME},
+				QueryCondition: sql.SQLEqual("", "class_name", row.ClassName),
+			}, ctx)
+			if err != nil {
+				return err
+			}
+			for _, document := range documents {
+				if err := documentRepository.writeQuerableRow(class, document, ctx); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+}
+
+// Store the querable fields of the document in the tables of its class and ancestors,
+// replacing the ones stored before
+func (documentRepository *SQLDocumentRepository) indexDocument(document *NDIDocument, ctx context.Context) error {
+	classes, err := documentRepository.querableClasses(document.ClassName, ctx)
+	if err != nil {
+		return err
+	}
+	for _, class := range classes {
+		if err := documentRepository.writeQuerableRow(class, document, ctx); err != nil {
+			// the table may have been dropped by rolling back the transaction it was
+			// created in, so it is checked again next time
+			documentRepository.forgetQuerableTable(class)
+			return err
+		}
+	}
+	return nil
+}
+
+func (documentRepository *SQLDocumentRepository) forgetQuerableTable(class *schema.NDISchema) {
+	documentRepository.querableMu.Lock()
+	defer documentRepository.querableMu.Unlock()
+	delete(documentRepository.querableColumns, schema.TableNameForSchema(class.SchemaName))
+}
+
+// Store the querable fields of the document, as seen by class, in the table of the class
+func (documentRepository *SQLDocumentRepository) writeQuerableRow(class *schema.NDISchema, document *NDIDocument, ctx context.Context) error {
+	values, err := class.FlattenContent(document.Content)
+	if err != nil {
+		return fmt.Errorf("document %s: %w", document.ID, err)
+	}
+	table := schema.TableNameForSchema(class.SchemaName)
+	if err := documentRepository.deleteQuerableRow(table, document.ID, ctx); err != nil {
+		return err
+	}
+	paths := make([]string, 0, len(values))
+	for path := range values {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	columns := []string{"id"}
+	params := []string{document.ID}
+	for _, path := range paths {
+		columns = append(columns, schema.ColumnNameForPath(path))
+		params = append(params, values[path])
+	}
+	stmt, err := (&sql.InsertStmt{
+		Dialect: documentRepository.db.Dialect,
+		Table:   table,
+		Columns: columns,
+		Values:  params,
+	}).GenerateStmt()
+	if err != nil {
+		return err
+	}
+	_, err = documentRepository.db.ExecuteSQL(stmt, ctx)
+	return err
+}
+
+// Remove the document from the querable tables of its class and ancestors
+func (documentRepository *SQLDocumentRepository) unindexDocument(id string, className string, ctx context.Context) error {
+	classes, err := documentRepository.querableClasses(className, ctx)
+	if err != nil {
+		return err
+	}
+	for _, class := range classes {
+		if err := documentRepository.deleteQuerableRow(schema.TableNameForSchema(class.SchemaName), id, ctx); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (documentRepository *SQLDocumentRepository) deleteQuerableRow(table string, id string, ctx context.Context) error {
+	stmt, err := (&sql.DeleteStmt{
+		Dialect:        documentRepository.db.Dialect,
+		Table:          table,
+		QueryCondition: sql.SQLEqual("", "id", id),
+	}).GenerateStmt()
+	if err != nil {
+		return err
+	}
+	_, err = documentRepository.db.ExecuteSQL(stmt, ctx)
+	return err
+}
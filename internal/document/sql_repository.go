@@ -0,0 +1,1042 @@
+package document
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	dbsql "database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/zhaoy17/ndid/internal/blob"
+	"github.com/zhaoy17/ndid/internal/schema"
+	sql "github.com/zhaoy17/ndid/internal/sql"
+)
+
+const DOCUMENT_TABLE_NAME = "ndidocument"
+const DEPENDENCY_TABLE_NAME = "ndidocument_dependency"
+const FILE_TABLE_NAME = "ndidocument_file"
+
+var ErrDocumentNotFound = errors.New("document not found")
+var ErrFileNotFound = errors.New("file not found")
+var ErrFileNotDeclared = errors.New("file not declared")
+
+// Look up the schema of a class by its name
+type SchemaResolver func(className string, ctx context.Context) (*schema.NDISchema, error)
+
+// Store documents in three tables: one holding the content of each document as JSON, one
+// holding the references between documents, which the dependency trees are computed from
+// with recursive common table expressions, and one holding the keys of the blobs attached
+// to documents. Every change to a document is also recorded in a history table (see
+// ListVersions), along with the keys of the blobs attached to each version. The content
+// of the files is kept in a blob store, where a blob is kept as long as a document or a
+// version refers to it: only the blobs that were never attached, e.g. because attaching
+// them failed, are deleted (see CollectBlobs).
+//
+// The querable fields of each document are copied, flattened and normalized, into the
+// table of its class and the ones of its ancestors (see schema.TableNameForSchema), where
+// FindDocuments looks them up. These tables are created, and given the columns of new
+// querable fields, the first time documents of the class are written or looked up outside
+// of a transaction (see EnsureQuerableTables).
+//
+// Since the blob store keeps a single copy of each content, storing a file may hand out a
+// blob that is about to be deleted because nothing refers to it yet. Blobs are therefore
+// checked for references and deleted with blobMu held for writing, while writes referring
+// to blobs hold it for reading until their transaction ends, and StoreFile marks the blob
+// it stores as pending as soon as its content is read, before the store looks for an
+// existing copy. Writes running inside a transaction of the caller release blobMu before
+// that transaction is committed, so the blobs they refer to are only protected by the
+// grace period of CollectBlobs in the meantime, and they leave the blobs they fail to
+// attach to CollectBlobs. None of this holds across repositories: processes sharing a
+// database and a blob store rely on the grace period alone.
+type SQLDocumentRepository struct {
+	db      *sql.SqlDatabase
+	schemas SchemaResolver
+	blobs   blob.BlobStore
+
+	// held for reading while blobs are referred to, and for writing while they are checked
+	// for references and deleted
+	blobMu sync.RWMutex
+	// number of StoreFile calls storing each blob and not done attaching it, which is not
+	// deleted meanwhile
+	pendingBlobs map[string]int
+	// guards pendingBlobs, and is held while a blob is checked and deleted so that it
+	// cannot become pending in between
+	pendingMu sync.Mutex
+
+	// columns of the querable tables known to exist, by table
+	querableColumns map[string]map[string]bool
+	// held while a querable table is created or altered, by table
+	querableLocks map[string]*sync.Mutex
+	// guards querableColumns and querableLocks
+	querableMu sync.Mutex
+}
+
+// Edge between a document and a document it depends on
+type dependencyEdge struct {
+	DocumentID     string `sql:"document_id"`
+	DependencyName string `sql:"dependency_name"`
+	DependsOnID    string `sql:"depends_on_id"`
+}
+
+// Link between a document and the blob holding the content of one of its files
+type fileRow struct {
+	DocumentID string `sql:"document_id"`
+	FileName   string `sql:"file_name"`
+	BlobKey    string `sql:"blob_key"`
+}
+
+type documentRow struct {
+	ID        string `sql:"id"`
+	ClassName string `sql:"class_name"`
+	Content   string `sql:"content"`
+}
+
+// Create a repository storing documents in db, looking up their classes with schemas to
+// check their dependencies and files, and keeping the content of their files in blobs
+func NewSQLDocumentRepository(db *sql.SqlDatabase, schemas SchemaResolver, blobs blob.BlobStore) *SQLDocumentRepository {
+	return &SQLDocumentRepository{
+		db:              db,
+		schemas:         schemas,
+		blobs:           blobs,
+		querableColumns: make(map[string]map[string]bool),
+		querableLocks:   make(map[string]*sync.Mutex),
+		pendingBlobs:    make(map[string]int),
+	}
+}
+
+// Insert the documents in a single transaction, after validating their content against
+// their class and checking that each of their references points to an existing document
+// (possibly one inserted earlier in the same call) whose class is the one required by the
+// dependency, or a subclass of it, and that each of their files is declared by their
+// class and refers to a stored blob
+func (documentRepository *SQLDocumentRepository) InsertDocuments(documents []*NDIDocument, change *NDIChange, ctx context.Context) error {
+	classNames := make(map[string]bool)
+	for _, document := range documents {
+		if classNames[document.ClassName] {
+			continue
+		}
+		classNames[document.ClassName] = true
+		if err := documentRepository.EnsureQuerableTables(document.ClassName, ctx); err != nil {
+			return err
+		}
+	}
+	documentRepository.blobMu.RLock()
+	defer documentRepository.blobMu.RUnlock()
+	return documentRepository.db.WithTransaction(ctx, dbsql.LevelDefault, func(ctx context.Context, tx *sql.TransactionManager) error {
+		for _, document := range documents {
+			if err := documentRepository.prepareContent(document, ctx); err != nil {
+				return err
+			}
+			if err := documentRepository.checkDependencies(document, ctx); err != nil {
+				return err
+			}
+			if err := documentRepository.checkFiles(document, ctx); err != nil {
+				return err
+			}
+			if err := documentRepository.insertDocument(document, ctx); err != nil {
+				return err
+			}
+			if err := documentRepository.indexDocument(document, ctx); err != nil {
+				return err
+			}
+			if err := documentRepository.recordVersion(document.ID, change, ctx); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Replace the content and the references of a stored document, keeping the previous
+// version in its history. The class of the document cannot change, and its content is
+// validated against the class as on insert. The files of the document are kept if
+// document.Files is nil, and replaced otherwise. The replaced blobs are kept along with
+// the version referring to them.
+func (documentRepository *SQLDocumentRepository) UpdateDocument(document *NDIDocument, change *NDIChange, ctx context.Context) error {
+	if err := documentRepository.EnsureQuerableTables(document.ClassName, ctx); err != nil {
+		return err
+	}
+	documentRepository.blobMu.RLock()
+	defer documentRepository.blobMu.RUnlock()
+	return documentRepository.db.WithTransaction(ctx, dbsql.LevelDefault, func(ctx context.Context, tx *sql.TransactionManager) error {
+		className, err := documentRepository.getClassName(document.ID, ctx)
+		if err != nil {
+			return err
+		}
+		if className != document.ClassName {
+			return fmt.Errorf("document %s is of class %s and cannot become of class %s", document.ID, className, document.ClassName)
+		}
+		if err := documentRepository.prepareContent(document, ctx); err != nil {
+			return err
+		}
+		if err := documentRepository.checkDependencies(document, ctx); err != nil {
+			return err
+		}
+		if err := documentRepository.checkFiles(document, ctx); err != nil {
+			return err
+		}
+		content, err := json.Marshal(document.Content)
+		if err != nil {
+			return err
+		}
+		stmt, err := (&sql.InsertStmt{
+			Dialect:         documentRepository.db.Dialect,
+			Table:           DOCUMENT_TABLE_NAME,
+			Columns:         []string{"id", "class_name", "content"},
+			Values:          []string{document.ID, document.ClassName, string(content)},
+			ConflictColumns: []string{"id"},
+		}).GenerateStmt()
+		if err != nil {
+			return err
+		}
+		if _, err := documentRepository.db.ExecuteSQL(stmt, ctx); err != nil {
+			return err
+		}
+		stmts := []*sql.DeleteStmt{
+			{
+				Dialect:        documentRepository.db.Dialect,
+				Table:          DEPENDENCY_TABLE_NAME,
+				QueryCondition: sql.SQLEqual("", "document_id", document.ID),
+			},
+		}
+		if document.Files != nil {
+			stmts = append(stmts, &sql.DeleteStmt{
+				Dialect:        documentRepository.db.Dialect,
+				Table:          FILE_TABLE_NAME,
+				QueryCondition: sql.SQLEqual("", "document_id", document.ID),
+			})
+		}
+		for _, stmt := range stmts {
+			sqlStmt, err := stmt.GenerateStmt()
+			if err != nil {
+				return err
+			}
+			if _, err := documentRepository.db.ExecuteSQL(sqlStmt, ctx); err != nil {
+				return err
+			}
+		}
+		if err := documentRepository.insertReferences(document, ctx); err != nil {
+			return err
+		}
+		if err := documentRepository.indexDocument(document, ctx); err != nil {
+			return err
+		}
+		return documentRepository.recordVersion(document.ID, change, ctx)
+	})
+}
+
+func (documentRepository *SQLDocumentRepository) GetDocument(id string, ctx context.Context) (*NDIDocument, error) {
+	stmt, err := (&sql.SelectStmt{
+		Dialect:        documentRepository.db.Dialect,
+		ColumnsToQuery: []string{"id", "class_name", "content"},
+		Tables:         []string{DOCUMENT_TABLE_NAME},
+		QueryCondition: sql.SQLEqual("", "id", id),
+	}).GenerateStmt()
+	if err != nil {
+		return nil, err
+	}
+	rows, err := sql.QueryStructs[documentRow](documentRepository.db, stmt, ctx)
+	if err != nil {
+		return nil, err
+	}
+	if len(rows) == 0 {
+		return nil, fmt.Errorf("%w: %s", ErrDocumentNotFound, id)
+	}
+	edges, err := documentRepository.queryEdges("document_id", id, ctx)
+	if err != nil {
+		return nil, err
+	}
+	files, err := documentRepository.queryFiles(id, ctx)
+	if err != nil {
+		return nil, err
+	}
+	return newDocumentFromRows(rows[0], edges, files)
+}
+
+// Assemble a document from its row, the edges to the documents it depends on, ordered by
+// dependency name, and its files, ordered by name
+func newDocumentFromRows(row documentRow, edges []dependencyEdge, fileRows []fileRow) (*NDIDocument, error) {
+	content, err := decodeContent(row.Content)
+	if err != nil {
+		return nil, fmt.Errorf("content of document %s: %w", row.ID, err)
+	}
+	dependsOn := make([]*NDIDependencyReference, len(edges))
+	for i, edge := range edges {
+		dependsOn[i] = &NDIDependencyReference{Name: edge.DependencyName, DocumentID: edge.DependsOnID}
+	}
+	files := make([]*NDIFileReference, len(fileRows))
+	for i, file := range fileRows {
+		files[i] = &NDIFileReference{Name: file.FileName, BlobKey: file.BlobKey}
+	}
+	return &NDIDocument{ID: row.ID, ClassName: row.ClassName, Content: content, DependsOn: dependsOn, Files: files}, nil
+}
+
+// Delete the documents in a single transaction. In restrict mode, fail with a
+// DependentsError if any document that is not being deleted depends on them. In cascade
+// mode, delete the documents depending on them as well. In dry-run mode, only report what
+// cascade mode would delete. The blobs of the files of the documents are kept along with
+// their history.
+func (documentRepository *SQLDocumentRepository) DeleteDocuments(ids []string, mode DeleteMode, change *NDIChange, ctx context.Context) (*DeleteResult, error) {
+	if mode != DeleteRestrict && mode != DeleteCascade && mode != DeleteDryRun {
+		return nil, fmt.Errorf("unknown delete mode %s", mode)
+	}
+	var res *DeleteResult
+	err := documentRepository.db.WithTransaction(ctx, dbsql.LevelDefault, func(ctx context.Context, tx *sql.TransactionManager) error {
+		res = &DeleteResult{DocumentIDs: make([]string, 0, len(ids))}
+		toDelete := make(map[string]bool)
+		for _, id := range ids {
+			if toDelete[id] {
+				continue
+			}
+			if _, err := documentRepository.getClassName(id, ctx); err != nil {
+				return err
+			}
+			toDelete[id] = true
+			res.DocumentIDs = append(res.DocumentIDs, id)
+		}
+
+		if mode == DeleteRestrict {
+			dependentsErr := &DependentsError{Dependents: make(map[string][]string)}
+			for _, id := range res.DocumentIDs {
+				edges, err := documentRepository.queryEdges("depends_on_id", id, ctx)
+				if err != nil {
+					return err
+				}
+				seen := make(map[string]bool)
+				for _, edge := range edges {
+					if !toDelete[edge.DocumentID] && !seen[edge.DocumentID] {
+						seen[edge.DocumentID] = true
+						dependentsErr.Dependents[id] = append(dependentsErr.Dependents[id], edge.DocumentID)
+					}
+				}
+			}
+			if len(dependentsErr.Dependents) > 0 {
+				return dependentsErr
+			}
+		} else {
+			for _, id := range append([]string{}, res.DocumentIDs...) {
+				downstream, err := documentRepository.getDependencyTree(id, false, ctx)
+				if err != nil {
+					return err
+				}
+				for _, dependentID := range downstream.DocumentIDs() {
+					if !toDelete[dependentID] {
+						toDelete[dependentID] = true
+						res.DocumentIDs = append(res.DocumentIDs, dependentID)
+					}
+				}
+			}
+		}
+
+		if mode == DeleteDryRun {
+			return nil
+		}
+		for _, id := range res.DocumentIDs {
+			className, err := documentRepository.getClassName(id, ctx)
+			if err != nil {
+				return err
+			}
+			if err := documentRepository.deleteDocument(id, ctx); err != nil {
+				return err
+			}
+			if err := documentRepository.unindexDocument(id, className, ctx); err != nil {
+				return err
+			}
+			if err := documentRepository.recordDeletion(id, className, change, ctx); err != nil {
+				return err
+			}
+		}
+		res.Deleted = true
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// Attach the blob to the document as the file named fileName, which the class of the
+// document must declare, replacing the blob previously attached as that file. The
+// replaced blob is kept along with the version referring to it. The blob must already be
+// referred to by a document or a version, or have been stored less than the grace period
+// of CollectBlobs ago, since it may be deleted meanwhile otherwise (see StoreFile).
+func (documentRepository *SQLDocumentRepository) AttachFile(id string, fileName string, blobKey string, change *NDIChange, ctx context.Context) error {
+	documentRepository.blobMu.RLock()
+	defer documentRepository.blobMu.RUnlock()
+	return documentRepository.attachFile(id, fileName, blobKey, change, ctx)
+}
+
+// Store the content read from r in the blob store and attach it to the document as the
+// file named fileName, like AttachFile. The blob cannot be deleted between the two, even
+// if it was already stored and is being collected: it is marked as pending once its
+// content has been read, which the store does before looking for an existing copy. No
+// lock is held while the content is stored. The blob is deleted if attaching it fails and
+// nothing refers to it.
+func (documentRepository *SQLDocumentRepository) StoreFile(id string, fileName string, r io.Reader, change *NDIChange, ctx context.Context) (*blob.BlobInfo, error) {
+	pending := &pendingBlobReader{r: r, hash: sha256.New(), repository: documentRepository}
+	defer pending.release()
+	info, err := documentRepository.blobs.Put(pending, ctx)
+	if err != nil {
+		return nil, err
+	}
+	if pending.key != info.Key {
+		return nil, fmt.Errorf("blob store returned key %s for content whose digest is %s", info.Key, pending.key)
+	}
+	documentRepository.blobMu.RLock()
+	err = documentRepository.attachFile(id, fileName, info.Key, change, ctx)
+	documentRepository.blobMu.RUnlock()
+	if err != nil {
+		pending.release()
+		if deleteErr := documentRepository.deleteUnreferencedBlobs([]string{info.Key}, ctx); deleteErr != nil {
+			return nil, errors.Join(err, deleteErr)
+		}
+		return nil, err
+	}
+	return info, nil
+}
+
+// Reader hashing the content of a blob being stored, and marking the blob as pending once
+// the whole content has been read
+type pendingBlobReader struct {
+	r          io.Reader
+	hash       hash.Hash
+	repository *SQLDocumentRepository
+
+	// key of the blob, set once it is pending
+	key      string
+	released bool
+}
+
+func (reader *pendingBlobReader) Read(p []byte) (int, error) {
+	n, err := reader.r.Read(p)
+	reader.hash.Write(p[:n])
+	if err == io.EOF && reader.key == "" {
+		reader.key = hex.EncodeToString(reader.hash.Sum(nil))
+		reader.repository.pendingMu.Lock()
+		reader.repository.pendingBlobs[reader.key]++
+		reader.repository.pendingMu.Unlock()
+	}
+	return n, err
+}
+
+// Let the blob be deleted again, unless it was already released
+func (reader *pendingBlobReader) release() {
+	if reader.key == "" || reader.released {
+		return
+	}
+	reader.released = true
+	reader.repository.pendingMu.Lock()
+	defer reader.repository.pendingMu.Unlock()
+	if reader.repository.pendingBlobs[reader.key]--; reader.repository.pendingBlobs[reader.key] == 0 {
+		delete(reader.repository.pendingBlobs, reader.key)
+	}
+}
+
+// Attach the blob to the document in a transaction. blobMu must be held.
+func (documentRepository *SQLDocumentRepository) attachFile(id string, fileName string, blobKey string, change *NDIChange, ctx context.Context) error {
+	return documentRepository.db.WithTransaction(ctx, dbsql.LevelDefault, func(ctx context.Context, tx *sql.TransactionManager) error {
+		className, err := documentRepository.getClassName(id, ctx)
+		if err != nil {
+			return err
+		}
+		document := &NDIDocument{ID: id, ClassName: className, Files: []*NDIFileReference{{Name: fileName, BlobKey: blobKey}}}
+		if err := documentRepository.checkFiles(document, ctx); err != nil {
+			return err
+		}
+		stmt, err := (&sql.DeleteStmt{
+			Dialect:        documentRepository.db.Dialect,
+			Table:          FILE_TABLE_NAME,
+			QueryCondition: sql.SQLAnd(sql.SQLEqual("", "document_id", id), sql.SQLEqual("", "file_name", fileName)),
+		}).GenerateStmt()
+		if err != nil {
+			return err
+		}
+		if _, err := documentRepository.db.ExecuteSQL(stmt, ctx); err != nil {
+			return err
+		}
+		if err := documentRepository.insertFile(id, document.Files[0], ctx); err != nil {
+			return err
+		}
+		return documentRepository.recordVersion(id, change, ctx)
+	})
+}
+
+// Open the content of the file of the document named fileName. Return ErrFileNotFound if
+// the document has no such file.
+func (documentRepository *SQLDocumentRepository) OpenFile(id string, fileName string, ctx context.Context) (io.ReadSeekCloser, *blob.BlobInfo, error) {
+	if _, err := documentRepository.getClassName(id, ctx); err != nil {
+		return nil, nil, err
+	}
+	files, err := documentRepository.queryFiles(id, ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, file := range files {
+		if file.FileName == fileName {
+			return documentRepository.blobs.Open(file.BlobKey, ctx)
+		}
+	}
+	return nil, nil, fmt.Errorf("%w: document %s has no file %s", ErrFileNotFound, id, fileName)
+}
+
+// Get the tree of the documents the document depends on, directly or not
+func (documentRepository *SQLDocumentRepository) GetUpstream(id string, ctx context.Context) (*NDIDependencyTree, error) {
+	return documentRepository.getDependencyTree(id, true, ctx)
+}
+
+// Get the tree of the documents depending on the document, directly or not
+func (documentRepository *SQLDocumentRepository) GetDownstream(id string, ctx context.Context) (*NDIDependencyTree, error) {
+	return documentRepository.getDependencyTree(id, false, ctx)
+}
+
+// Create the tables of the repository, leaving the existing ones untouched so that Setup
+// can be run on every startup, and start the history of the documents stored before the
+// history table existed
+func (documentRepository *SQLDocumentRepository) Setup(ctx context.Context) error {
+	tables := []sql.TableSchema{
+		{
+			TableName: DOCUMENT_TABLE_NAME,
+			Columns: map[string]sql.SqlDataType{
+				"id":         &sql.SqlText{Len: 33, NotNull: true},
+				"class_name": &sql.SqlText{Len: 255, NotNull: true},
+				"content":    &sql.SqlJSON{NotNull: true},
+			},
+			PrimaryKey: []string{"id"},
+		},
+		{
+			TableName: DEPENDENCY_TABLE_NAME,
+			Columns: map[string]sql.SqlDataType{
+				"document_id":     &sql.SqlText{Len: 33, NotNull: true},
+				"dependency_name": &sql.SqlText{Len: 255, NotNull: true},
+				"depends_on_id":   &sql.SqlText{Len: 33, NotNull: true},
+			},
+			PrimaryKey: []string{"document_id", "dependency_name", "depends_on_id"},
+		},
+		{
+			TableName: FILE_TABLE_NAME,
+			Columns: map[string]sql.SqlDataType{
+				"document_id": &sql.SqlText{Len: 33, NotNull: true},
+				"file_name":   &sql.SqlText{Len: 255, NotNull: true},
+				"blob_key":    &sql.SqlText{Len: 64, NotNull: true},
+			},
+			PrimaryKey: []string{"document_id", "file_name"},
+		},
+		{
+			TableName: VERSION_TABLE_NAME,
+			Columns: map[string]sql.SqlDataType{
+				"document_id": &sql.SqlText{Len: 33, NotNull: true},
+				"version":     &sql.SqlInteger{NotNull: true},
+				"class_name":  &sql.SqlText{Len: 255, NotNull: true},
+				"content":     &sql.SqlJSON{NotNull: true},
+				"depends_on":  &sql.SqlJSON{NotNull: true},
+				"files":       &sql.SqlJSON{NotNull: true},
+				"deleted":     &sql.SqlInteger{NotNull: true},
+				"author":      &sql.SqlText{Len: 255},
+				"reason":      &sql.SqlText{},
+				"created_at":  &sql.SqlDateTime{NotNull: true},
+			},
+			PrimaryKey: []string{"document_id", "version"},
+		},
+		{
+			TableName: VERSION_FILE_TABLE_NAME,
+			Columns: map[string]sql.SqlDataType{
+				"document_id": &sql.SqlText{Len: 33, NotNull: true},
+				"version":     &sql.SqlInteger{NotNull: true},
+				"file_name":   &sql.SqlText{Len: 255, NotNull: true},
+				"blob_key":    &sql.SqlText{Len: 64, NotNull: true},
+			},
+			PrimaryKey: []string{"document_id", "version", "file_name"},
+		},
+	}
+	err := documentRepository.db.WithTransaction(ctx, dbsql.LevelDefault, func(ctx context.Context, tx *sql.TransactionManager) error {
+		for _, table := range tables {
+			stmt, err := (&sql.CreateTableStmt{
+				Dialect:     documentRepository.db.Dialect,
+				TableSchema: table,
+				IfNotExists: true,
+			}).GenerateStmt()
+			if err != nil {
+				return err
+			}
+			if _, err := documentRepository.db.ExecuteSQL(stmt, ctx); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	// in a transaction of its own, since creating a table commits the transaction it is
+	// created in on MySQL
+	return documentRepository.seedVersions(ctx)
+}
+
+// Fill in the default values of the fields missing from the content of the document, and
+// validate the content against its class. Documents built by hand or decoded from JSON
+// have not been through NewNDIDocument, so they are checked again before being written.
+// Return schema.ValidationErrors if the content does not conform to the class.
+func (documentRepository *SQLDocumentRepository) prepareContent(document *NDIDocument, ctx context.Context) error {
+	class, err := documentRepository.schemas(document.ClassName, ctx)
+	if err != nil {
+		return err
+	}
+	if document.Content == nil {
+		document.Content = make(map[string]interface{})
+	}
+	return class.PrepareContent(document.Content)
+}
+
+func (documentRepository *SQLDocumentRepository) checkDependencies(document *NDIDocument, ctx context.Context) error {
+	if len(document.DependsOn) == 0 {
+		return nil
+	}
+	class, err := documentRepository.schemas(document.ClassName, ctx)
+	if err != nil {
+		return err
+	}
+	declared := make(map[string]*schema.NDIDependency)
+	for _, dependency := range class.AllDependencies() {
+		declared[dependency.DependencyName] = dependency
+	}
+	for _, ref := range document.DependsOn {
+		dependency, ok := declared[ref.Name]
+		if !ok {
+			return fmt.Errorf("%s does not declare dependency %s", class.SchemaName, ref.Name)
+		}
+		if ref.DocumentID == "" {
+			return fmt.Errorf("dependency %s of document %s does not refer to any document", ref.Name, document.ID)
+		}
+		className, err := documentRepository.getClassName(ref.DocumentID, ctx)
+		if errors.Is(err, ErrDocumentNotFound) {
+			return fmt.Errorf("dependency %s of document %s refers to document %s, which does not exist",
+				ref.Name, document.ID, ref.DocumentID)
+		}
+		if err != nil {
+			return err
+		}
+		if dependency.SchemaDependsOn == nil || className == dependency.SchemaDependsOn.SchemaName {
+			continue
+		}
+		dependedOnClass, err := documentRepository.schemas(className, ctx)
+		if err != nil {
+			return err
+		}
+		if !dependedOnClass.IsSubclassOf(dependency.SchemaDependsOn.SchemaName) {
+			return fmt.Errorf("dependency %s of document %s must refer to a document of class %s, but document %s is of class %s",
+				ref.Name, document.ID, dependency.SchemaDependsOn.SchemaName, ref.DocumentID, className)
+		}
+	}
+	return nil
+}
+
+// Check that every file of the document is declared by its class, is attached once, and
+// refers to a stored blob
+func (documentRepository *SQLDocumentRepository) checkFiles(document *NDIDocument, ctx context.Context) error {
+	if len(document.Files) == 0 {
+		return nil
+	}
+	class, err := documentRepository.schemas(document.ClassName, ctx)
+	if err != nil {
+		return err
+	}
+	declared := make(map[string]bool)
+	for _, file := range class.AllFiles() {
+		declared[file.FileName] = true
+	}
+	attached := make(map[string]bool)
+	for _, ref := range document.Files {
+		if ref == nil {
+			return fmt.Errorf("file reference cannot be nil")
+		}
+		if !declared[ref.Name] {
+			return fmt.Errorf("%w: %s does not declare file %s", ErrFileNotDeclared, class.SchemaName, ref.Name)
+		}
+		if attached[ref.Name] {
+			return fmt.Errorf("file %s of document %s is attached more than once", ref.Name, document.ID)
+		}
+		attached[ref.Name] = true
+		if _, err := documentRepository.blobs.Stat(ref.BlobKey, ctx); err != nil {
+			return fmt.Errorf("file %s of document %s: %w", ref.Name, document.ID, err)
+		}
+	}
+	return nil
+}
+
+func (documentRepository *SQLDocumentRepository) insertDocument(document *NDIDocument, ctx context.Context) error {
+	content, err := json.Marshal(document.Content)
+	if err != nil {
+		return err
+	}
+	stmt, err := (&sql.InsertStmt{
+		Dialect: documentRepository.db.Dialect,
+		Table:   DOCUMENT_TABLE_NAME,
+		Columns: []string{"id", "class_name", "content"},
+		Values:  []string{document.ID, document.ClassName, string(content)},
+	}).GenerateStmt()
+	if err != nil {
+		return err
+	}
+	if _, err := documentRepository.db.ExecuteSQL(stmt, ctx); err != nil {
+		return err
+	}
+	return documentRepository.insertReferences(document, ctx)
+}
+
+// Insert the references of the document to the documents it depends on and to its files
+func (documentRepository *SQLDocumentRepository) insertReferences(document *NDIDocument, ctx context.Context) error {
+	for _, ref := range document.DependsOn {
+		stmt, err := (&sql.InsertStmt{
+			Dialect: documentRepository.db.Dialect,
+			Table:   DEPENDENCY_TABLE_NAME,
+			Columns: []string{"document_id", "dependency_name", "depends_on_id"},
+			Values:  []string{document.ID, ref.Name, ref.DocumentID},
+		}).GenerateStmt()
+		if err != nil {
+			return err
+		}
+		if _, err := documentRepository.db.ExecuteSQL(stmt, ctx); err != nil {
+			return err
+		}
+	}
+	for _, ref := range document.Files {
+		if err := documentRepository.insertFile(document.ID, ref, ctx); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (documentRepository *SQLDocumentRepository) insertFile(id string, ref *NDIFileReference, ctx context.Context) error {
+	stmt, err := (&sql.InsertStmt{
+		Dialect: documentRepository.db.Dialect,
+		Table:   FILE_TABLE_NAME,
+		Columns: []string{"document_id", "file_name", "blob_key"},
+		Values:  []string{id, ref.Name, ref.BlobKey},
+	}).GenerateStmt()
+	if err != nil {
+		return err
+	}
+	_, err = documentRepository.db.ExecuteSQL(stmt, ctx)
+	return err
+}
+
+// Get the files attached to the document, ordered by name
+func (documentRepository *SQLDocumentRepository) queryFiles(id string, ctx context.Context) ([]fileRow, error) {
+	stmt, err := (&sql.SelectStmt{
+		Dialect:        documentRepository.db.Dialect,
+		ColumnsToQuery: []string{"document_id", "file_name", "blob_key"},
+		Tables:         []string{FILE_TABLE_NAME},
+		QueryCondition: sql.SQLEqual("", "document_id", id),
+		OrderBy:        []sql.OrderByColumn{sql.Ascending("file_name")},
+	}).GenerateStmt()
+	if err != nil {
+		return nil, err
+	}
+	return sql.QueryStructs[fileRow](documentRepository.db, stmt, ctx)
+}
+
+// Delete the blobs that no document or version refers to. Each blob is checked and
+// deleted with blobMu held, so that no write can start referring to it in between.
+// Nothing is deleted if ctx carries a transaction, whose references are not settled until
+// it is committed; CollectBlobs deletes the blobs that end up unreferenced.
+func (documentRepository *SQLDocumentRepository) deleteUnreferencedBlobs(keys []string, ctx context.Context) error {
+	if sql.InTransaction(ctx) {
+		return nil
+	}
+	seen := make(map[string]bool)
+	for _, key := range keys {
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		if _, err := documentRepository.deleteBlobIfUnreferenced(key, ctx); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Delete the blob if no document or version of the history refers to it and it is not
+// pending, and report whether it was deleted
+func (documentRepository *SQLDocumentRepository) deleteBlobIfUnreferenced(key string, ctx context.Context) (bool, error) {
+	documentRepository.blobMu.Lock()
+	defer documentRepository.blobMu.Unlock()
+	documentRepository.pendingMu.Lock()
+	defer documentRepository.pendingMu.Unlock()
+	if documentRepository.pendingBlobs[key] > 0 {
+		return false, nil
+	}
+	for _, table := range []string{FILE_TABLE_NAME, VERSION_FILE_TABLE_NAME} {
+		stmt, err := (&sql.SelectStmt{
+			Dialect:        documentRepository.db.Dialect,
+			ColumnsToQuery: []string{"document_id", "file_name", "blob_key"},
+			Tables:         []string{table},
+			QueryCondition: sql.SQLEqual("", "blob_key", key),
+			Limit:          1,
+		}).GenerateStmt()
+		if err != nil {
+			return false, err
+		}
+		rows, err := sql.QueryStructs[fileRow](documentRepository.db, stmt, ctx)
+		if err != nil {
+			return false, err
+		}
+		if len(rows) > 0 {
+			return false, nil
+		}
+	}
+	if err := documentRepository.blobs.Delete(key, ctx); err != nil {
+		return false, fmt.Errorf("deleting unreferenced blob %s: %w", key, err)
+	}
+	return true, nil
+}
+
+// Delete the blobs that no document or version refers to and that were stored more than
+// gracePeriod ago, such as the ones of uploads that failed to be attached, and return their keys.
+// The grace period keeps the blobs other processes are about to attach. Fail if the blob
+// store cannot list its blobs (see blob.BlobLister).
+func (documentRepository *SQLDocumentRepository) CollectBlobs(gracePeriod time.Duration, ctx context.Context) ([]string, error) {
+	lister, ok := documentRepository.blobs.(blob.BlobLister)
+	if !ok {
+		return nil, fmt.Errorf("blob store %T cannot list its blobs", documentRepository.blobs)
+	}
+	cutoff := time.Now().Add(-gracePeriod)
+	candidates := make([]string, 0)
+	err := lister.List(func(info *blob.BlobInfo) error {
+		if info.ModTime.Before(cutoff) {
+			candidates = append(candidates, info.Key)
+		}
+		return nil
+	}, ctx)
+	if err != nil {
+		return nil, err
+	}
+	deleted := make([]string, 0)
+	for _, key := range candidates {
+		ok, err := documentRepository.deleteBlobIfUnreferenced(key, ctx)
+		if err != nil {
+			return deleted, err
+		}
+		if ok {
+			deleted = append(deleted, key)
+		}
+	}
+	return deleted, nil
+}
+
+// Delete the document along with its references to other documents and theirs to it, and
+// the links to the blobs of its files
+func (documentRepository *SQLDocumentRepository) deleteDocument(id string, ctx context.Context) error {
+	stmts := []*sql.DeleteStmt{
+		{
+			Dialect:        documentRepository.db.Dialect,
+			Table:          FILE_TABLE_NAME,
+			QueryCondition: sql.SQLEqual("", "document_id", id),
+		},
+		{
+			Dialect:        documentRepository.db.Dialect,
+			Table:          DEPENDENCY_TABLE_NAME,
+			QueryCondition: sql.SQLOr(sql.SQLEqual("", "document_id", id), sql.SQLEqual("", "depends_on_id", id)),
+		},
+		{
+			Dialect:        documentRepository.db.Dialect,
+			Table:          DOCUMENT_TABLE_NAME,
+			QueryCondition: sql.SQLEqual("", "id", id),
+		},
+	}
+	for _, stmt := range stmts {
+		sqlStmt, err := stmt.GenerateStmt()
+		if err != nil {
+			return err
+		}
+		if _, err := documentRepository.db.ExecuteSQL(sqlStmt, ctx); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (documentRepository *SQLDocumentRepository) getClassName(id string, ctx context.Context) (string, error) {
+	stmt, err := (&sql.SelectStmt{
+		Dialect:        documentRepository.db.Dialect,
+		ColumnsToQuery: []string{"class_name"},
+		Tables:         []string{DOCUMENT_TABLE_NAME},
+		QueryCondition: sql.SQLEqual("", "id", id),
+	}).GenerateStmt()
+	if err != nil {
+		return "", err
+	}
+	rows, err := sql.QueryStructs[documentRow](documentRepository.db, stmt, ctx)
+	if err != nil {
+		return "", err
+	}
+	if len(rows) == 0 {
+		return "", fmt.Errorf("%w: %s", ErrDocumentNotFound, id)
+	}
+	return rows[0].ClassName, nil
+}
+
+func (documentRepository *SQLDocumentRepository) getDependencyTree(id string, upstream bool, ctx context.Context) (*NDIDependencyTree, error) {
+	if _, err := documentRepository.getClassName(id, ctx); err != nil {
+		return nil, err
+	}
+	var edges []dependencyEdge
+	var err error
+	if with, supported := sql.RecursiveWith(documentRepository.db.Dialect); supported {
+		edges, err = documentRepository.queryEdgesRecursively(with, id, upstream, ctx)
+	} else {
+		edges, err = documentRepository.walkEdges(id, upstream, ctx)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return buildDependencyTree(id, edges, upstream), nil
+}
+
+// Get all the edges reachable from the document in a single query
+func (documentRepository *SQLDocumentRepository) queryEdgesRecursively(with string, id string, upstream bool, ctx context.Context) ([]dependencyEdge, error) {
+	placeholder, err := documentRepository.db.Dialect.Placeholder(1)
+	if err != nil {
+		return nil, err
+	}
+	// column holding the document an edge starts from, and the one it leads to
+	quote := documentRepository.db.Dialect.QuoteIdentifier
+	fromCol, toCol := quote("document_id"), quote("depends_on_id")
+	if !upstream {
+		fromCol, toCol = toCol, fromCol
+	}
+	table := quote(DEPENDENCY_TABLE_NAME)
+	// UNION discards the edges already found, so that each edge is produced once however
+	// many paths lead to it, and cycles end the recursion
+	stmt := &sql.SqlStmt{
+		Stmt: fmt.Sprintf(`%s dependency_edges (document_id, dependency_name, depends_on_id) AS (
+	SELECT document_id, dependency_name, depends_on_id FROM %s WHERE %s = %s
+	UNION
+	SELECT d.document_id, d.dependency_name, d.depends_on_id
+	FROM %s d INNER JOIN dependency_edges e ON d.%s = e.%s
+)
+SELECT document_id, dependency_name, depends_on_id FROM dependency_edges;`,
+			with, table, fromCol, placeholder,
+			table, fromCol, toCol),
+		Params: []string{id},
+	}
+	return sql.QueryStructs[dependencyEdge](documentRepository.db, stmt, ctx)
+}
+
+// Get all the edges reachable from the document one level at a time, for the dialects
+// without recursive common table expressions
+func (documentRepository *SQLDocumentRepository) walkEdges(id string, upstream bool, ctx context.Context) ([]dependencyEdge, error) {
+	fromCol := "document_id"
+	if !upstream {
+		fromCol = "depends_on_id"
+	}
+	res := make([]dependencyEdge, 0)
+	visited := map[string]bool{id: true}
+	level := []string{id}
+	for len(level) > 0 {
+		nextLevel := make([]string, 0)
+		for _, from := range level {
+			edges, err := documentRepository.queryEdges(fromCol, from, ctx)
+			if err != nil {
+				return nil, err
+			}
+			for _, edge := range edges {
+				res = append(res, edge)
+				to := edge.next(upstream)
+				if !visited[to] {
+					visited[to] = true
+					nextLevel = append(nextLevel, to)
+				}
+			}
+		}
+		level = nextLevel
+	}
+	return res, nil
+}
+
+// Get the edges whose col is id, ordered by dependency name
+func (documentRepository *SQLDocumentRepository) queryEdges(col string, id string, ctx context.Context) ([]dependencyEdge, error) {
+	stmt, err := (&sql.SelectStmt{
+		Dialect:        documentRepository.db.Dialect,
+		ColumnsToQuery: []string{"document_id", "dependency_name", "depends_on_id"},
+		Tables:         []string{DEPENDENCY_TABLE_NAME},
+		QueryCondition: sql.SQLEqual("", col, id),
+		OrderBy:        []sql.OrderByColumn{sql.Ascending("dependency_name"), sql.Ascending("depends_on_id"), sql.Ascending("document_id")},
+	}).GenerateStmt()
+	if err != nil {
+		return nil, err
+	}
+	return sql.QueryStructs[dependencyEdge](documentRepository.db, stmt, ctx)
+}
+
+// Get the document the edge leads to when walking it upstream or downstream
+func (edge dependencyEdge) next(upstream bool) string {
+	if upstream {
+		return edge.DependsOnID
+	}
+	return edge.DocumentID
+}
+
+func (edge dependencyEdge) from(upstream bool) string {
+	if upstream {
+		return edge.DocumentID
+	}
+	return edge.DependsOnID
+}
+
+// Assemble the edges into a tree rooted at the document. The children of a document are
+// only computed once and shared between the paths leading to it, and cycles are cut.
+func buildDependencyTree(root string, edges []dependencyEdge, upstream bool) *NDIDependencyTree {
+	sort.Slice(edges, func(i, j int) bool {
+		if edges[i].DependencyName != edges[j].DependencyName {
+			return edges[i].DependencyName < edges[j].DependencyName
+		}
+		return edges[i].next(upstream) < edges[j].next(upstream)
+	})
+	edgesFrom := make(map[string][]dependencyEdge)
+	for _, edge := range edges {
+		edgesFrom[edge.from(upstream)] = append(edgesFrom[edge.from(upstream)], edge)
+	}
+
+	children := make(map[string][]*NDIDependencyTree)
+	onPath := make(map[string]bool)
+	var build func(id string, dependencyName string) *NDIDependencyTree
+	build = func(id string, dependencyName string) *NDIDependencyTree {
+		node := &NDIDependencyTree{DocumentID: id, DependencyName: dependencyName, Children: []*NDIDependencyTree{}}
+		if onPath[id] {
+			return node
+		}
+		if computed, ok := children[id]; ok {
+			node.Children = computed
+			return node
+		}
+		onPath[id] = true
+		for _, edge := range edgesFrom[id] {
+			node.Children = append(node.Children, build(edge.next(upstream), edge.DependencyName))
+		}
+		onPath[id] = false
+		children[id] = node.Children
+		return node
+	}
+	return build(root, "")
+}
+
+// Decode the content of a document, keeping numbers as written
+func decodeContent(content string) (map[string]interface{}, error) {
+	decoder := json.NewDecoder(bytes.NewReader([]byte(content)))
+	decoder.UseNumber()
+	var res map[string]interface{}
+	if err := decoder.Decode(&res); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
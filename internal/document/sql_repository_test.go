@@ -0,0 +1,684 @@
+package document
+
+import (
+	"context"
+	dbsql "database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/zhaoy17/ndid/internal/blob"
+	dt "github.com/zhaoy17/ndid/internal/datatypes"
+	"github.com/zhaoy17/ndid/internal/schema"
+	sql "github.com/zhaoy17/ndid/internal/sql"
+)
+
+// SQLite dialect without recursive common table expressions, to exercise walkEdges. Only the
+// methods of Dialect are promoted from the embedded interface.
+type noRecursiveWithDialect struct{ sql.Dialect }
+
+// Create a repository backed by a SQLite database and a blob store in temporary
+// directories, resolving the given classes
+func newTestRepository(t *testing.T, dialect sql.Dialect, classes ...*schema.NDISchema) *SQLDocumentRepository {
+	t.Helper()
+	conn, err := dbsql.Open("sqlite3", filepath.Join(t.TempDir(), "documents.db")+"?_busy_timeout=5000")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db := sql.NewSqlDatabase(dialect, conn, sql.DefaultStmtCacheSize)
+	t.Cleanup(func() { db.Close() })
+	blobs, err := blob.NewLocalBlobStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	byName := make(map[string]*schema.NDISchema)
+	for _, class := range classes {
+		byName[class.SchemaName] = class
+	}
+	repo := NewSQLDocumentRepository(db, func(className string, ctx context.Context) (*schema.NDISchema, error) {
+		class, ok := byName[className]
+		if !ok {
+			return nil, fmt.Errorf("unknown class %s", className)
+		}
+		return class, nil
+	}, blobs)
+	if err := repo.Setup(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	return repo
+}
+
+func newTestDocument(t *testing.T, class *schema.NDISchema, content map[string]interface{}, dependsOn ...*NDIDependencyReference) *NDIDocument {
+	t.Helper()
+	document, err := NewNDIDocument(class, content, dependsOn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return document
+}
+
+var baseClass = &schema.NDISchema{
+	SchemaName:   "base",
+	SchemaFields: []*schema.NDIField{{FieldName: "id", DataType: &dt.NDIIdentifier{}}},
+}
+
+func TestSetupIsIdempotent(t *testing.T) {
+	repo := newTestRepository(t, sql.SqlLite, baseClass)
+	if err := repo.Setup(context.Background()); err != nil {
+		t.Fatalf("second setup failed: %v", err)
+	}
+}
+
+// Every node of a level depends on both nodes of the next level, so the number of paths
+// doubles with each level while the number of edges grows linearly
+func TestDependencyTreeOfDiamonds(t *testing.T) {
+	const levels = 24
+	node := &schema.NDISchema{SchemaName: "node", Superclasses: []*schema.NDISchema{baseClass}}
+	node.Dependencies = []*schema.NDIDependency{{DependencyName: "parent", SchemaDependsOn: node}}
+
+	for _, dialect := range []sql.Dialect{sql.SqlLite, noRecursiveWithDialect{sql.SqlLite}} {
+		t.Run(fmt.Sprintf("%T", dialect), func(t *testing.T) {
+			repo := newTestRepository(t, dialect, baseClass, node)
+			ctx := context.Background()
+			var documents []*NDIDocument
+			var previous []*NDIDocument
+			for level := 0; level < levels; level++ {
+				current := make([]*NDIDocument, 2)
+				for i := range current {
+					refs := make([]*NDIDependencyReference, len(previous))
+					for j, parent := range previous {
+						refs[j] = &NDIDependencyReference{Name: "parent", DocumentID: parent.ID}
+					}
+					current[i] = newTestDocument(t, node, nil, refs...)
+				}
+				documents = append(documents, current...)
+				previous = current
+			}
+			if err := repo.InsertDocuments(documents, nil, ctx); err != nil {
+				t.Fatal(err)
+			}
+
+			leaf := documents[len(documents)-1]
+			upstream, err := repo.GetUpstream(leaf.ID, ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if ids := upstream.DocumentIDs(); len(ids) != 2*(levels-1) {
+				t.Fatalf("got %d upstream documents, want %d", len(ids), 2*(levels-1))
+			}
+
+			downstream, err := repo.GetDownstream(documents[0].ID, ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if ids := downstream.DocumentIDs(); len(ids) != 2*(levels-1) {
+				t.Fatalf("got %d downstream documents, want %d", len(ids), 2*(levels-1))
+			}
+		})
+	}
+}
+
+// Store a diamond, in which c depends on a and b, which both depend on root, along with a
+// document depending on nothing
+func newDiamond(t *testing.T, repo *SQLDocumentRepository, node *schema.NDISchema) (root, a, b, c, unrelated *NDIDocument) {
+	t.Helper()
+	parent := func(document *NDIDocument) *NDIDependencyReference {
+		return &NDIDependencyReference{Name: "parent", DocumentID: document.ID}
+	}
+	root = newTestDocument(t, node, nil)
+	a = newTestDocument(t, node, nil, parent(root))
+	b = newTestDocument(t, node, nil, parent(root))
+	c = newTestDocument(t, node, nil, parent(a), parent(b))
+	unrelated = newTestDocument(t, node, nil)
+	if err := repo.InsertDocuments([]*NDIDocument{root, a, b, c, unrelated}, nil, context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	return root, a, b, c, unrelated
+}
+
+// Report the documents that are still stored among the given ones, sorted
+func storedIDs(t *testing.T, repo *SQLDocumentRepository, documents ...*NDIDocument) []string {
+	t.Helper()
+	ids := make([]string, 0)
+	for _, document := range documents {
+		_, err := repo.GetDocument(document.ID, context.Background())
+		if err == nil {
+			ids = append(ids, document.ID)
+		} else if !errors.Is(err, ErrDocumentNotFound) {
+			t.Fatal(err)
+		}
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+func sortedIDs(documents ...*NDIDocument) []string {
+	ids := make([]string, len(documents))
+	for i, document := range documents {
+		ids[i] = document.ID
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+func TestDeleteDocumentsRestrict(t *testing.T) {
+	node := &schema.NDISchema{SchemaName: "node", Superclasses: []*schema.NDISchema{baseClass}}
+	node.Dependencies = []*schema.NDIDependency{{DependencyName: "parent", SchemaDependsOn: node}}
+	repo := newTestRepository(t, sql.SqlLite, baseClass, node)
+	ctx := context.Background()
+	root, a, b, c, unrelated := newDiamond(t, repo, node)
+	all := []*NDIDocument{root, a, b, c, unrelated}
+
+	_, err := repo.DeleteDocuments([]string{root.ID, a.ID}, DeleteRestrict, nil, ctx)
+	var dependentsErr *DependentsError
+	if !errors.As(err, &dependentsErr) {
+		t.Fatalf("got error %v, want a DependentsError", err)
+	}
+	// a is deleted along with root, so only b and c are reported
+	for _, dependents := range dependentsErr.Dependents {
+		sort.Strings(dependents)
+	}
+	want := map[string][]string{root.ID: {b.ID}, a.ID: {c.ID}}
+	if !reflect.DeepEqual(dependentsErr.Dependents, want) {
+		t.Fatalf("got dependents %v, want %v", dependentsErr.Dependents, want)
+	}
+	if got := storedIDs(t, repo, all...); !reflect.DeepEqual(got, sortedIDs(all...)) {
+		t.Fatalf("stored %v after a failed deletion, want %v", got, sortedIDs(all...))
+	}
+
+	// the documents depending on each other are deleted together
+	res, err := repo.DeleteDocuments([]string{c.ID, b.ID, a.ID, root.ID, c.ID}, DeleteRestrict, nil, ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !res.Deleted || !reflect.DeepEqual(res.DocumentIDs, []string{c.ID, b.ID, a.ID, root.ID}) {
+		t.Fatalf("got %+v", res)
+	}
+	if got := storedIDs(t, repo, all...); !reflect.DeepEqual(got, []string{unrelated.ID}) {
+		t.Fatalf("stored %v, want only %s", got, unrelated.ID)
+	}
+}
+
+func TestDeleteDocumentsCascade(t *testing.T) {
+	node := &schema.NDISchema{SchemaName: "node", Superclasses: []*schema.NDISchema{baseClass}}
+	node.Dependencies = []*schema.NDIDependency{{DependencyName: "parent", SchemaDependsOn: node}}
+	leaf := &schema.NDISchema{SchemaName: "leaf", Superclasses: []*schema.NDISchema{node}}
+	repo := newTestRepository(t, sql.SqlLite, baseClass, node, leaf)
+	ctx := context.Background()
+	root, a, b, c, unrelated := newDiamond(t, repo, node)
+	last := newTestDocument(t, leaf, nil, &NDIDependencyReference{Name: "parent", DocumentID: c.ID})
+	if err := repo.InsertDocuments([]*NDIDocument{last}, nil, ctx); err != nil {
+		t.Fatal(err)
+	}
+	all := []*NDIDocument{root, a, b, c, last, unrelated}
+
+	// failing on the last document of the subtree rolls back the whole deletion
+	resolve := repo.schemas
+	repo.schemas = func(className string, ctx context.Context) (*schema.NDISchema, error) {
+		if className == leaf.SchemaName {
+			return nil, errors.New("leaf is unavailable")
+		}
+		return resolve(className, ctx)
+	}
+	if _, err := repo.DeleteDocuments([]string{root.ID}, DeleteCascade, nil, ctx); err == nil {
+		t.Fatalf("deleted a document whose class is unavailable")
+	}
+	repo.schemas = resolve
+	if got := storedIDs(t, repo, all...); !reflect.DeepEqual(got, sortedIDs(all...)) {
+		t.Fatalf("stored %v after a failed deletion, want %v", got, sortedIDs(all...))
+	}
+
+	res, err := repo.DeleteDocuments([]string{root.ID}, DeleteCascade, nil, ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !res.Deleted || res.DocumentIDs[0] != root.ID {
+		t.Fatalf("got %+v, want root first", res)
+	}
+	// c is reached through both a and b, and reported once
+	deleted := append([]string{}, res.DocumentIDs...)
+	sort.Strings(deleted)
+	if !reflect.DeepEqual(deleted, sortedIDs(root, a, b, c, last)) {
+		t.Fatalf("deleted %v, want %v", res.DocumentIDs, sortedIDs(root, a, b, c, last))
+	}
+	if got := storedIDs(t, repo, all...); !reflect.DeepEqual(got, []string{unrelated.ID}) {
+		t.Fatalf("stored %v, want only %s", got, unrelated.ID)
+	}
+	versions, err := repo.ListVersions(c.ID, ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(versions) != 2 || !versions[1].Deleted {
+		t.Fatalf("got versions %+v, want the deletion recorded", versions)
+	}
+}
+
+func TestDeleteDocumentsDryRun(t *testing.T) {
+	node := &schema.NDISchema{SchemaName: "node", Superclasses: []*schema.NDISchema{baseClass}}
+	node.Dependencies = []*schema.NDIDependency{{DependencyName: "parent", SchemaDependsOn: node}}
+	repo := newTestRepository(t, sql.SqlLite, baseClass, node)
+	ctx := context.Background()
+	root, a, b, c, unrelated := newDiamond(t, repo, node)
+	all := []*NDIDocument{root, a, b, c, unrelated}
+
+	res, err := repo.DeleteDocuments([]string{a.ID}, DeleteDryRun, nil, ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Deleted || !reflect.DeepEqual(res.DocumentIDs, []string{a.ID, c.ID}) {
+		t.Fatalf("got %+v, want a and c to be reported only", res)
+	}
+	if got := storedIDs(t, repo, all...); !reflect.DeepEqual(got, sortedIDs(all...)) {
+		t.Fatalf("stored %v after a dry run, want %v", got, sortedIDs(all...))
+	}
+	versions, err := repo.ListVersions(a.ID, ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(versions) != 1 {
+		t.Fatalf("got %d versions after a dry run, want 1", len(versions))
+	}
+
+	if _, err := repo.DeleteDocuments([]string{"missing"}, DeleteDryRun, nil, ctx); !errors.Is(err, ErrDocumentNotFound) {
+		t.Fatalf("got error %v, want ErrDocumentNotFound", err)
+	}
+}
+
+func TestFindDocuments(t *testing.T) {
+	probe := &schema.NDISchema{
+		SchemaName:   "probe",
+		Superclasses: []*schema.NDISchema{baseClass},
+		SchemaFields: []*schema.NDIField{
+			{FieldName: "name", DataType: &dt.NDIString{MaxLen: 64}, Querable: true},
+			{FieldName: "site", Subfields: []*schema.NDIField{
+				{FieldName: "area", DataType: &dt.NDIString{MaxLen: 64}, Querable: true},
+			}},
+		},
+	}
+	electrode := &schema.NDISchema{
+		SchemaName:   "electrode",
+		Superclasses: []*schema.NDISchema{probe},
+		SchemaFields: []*schema.NDIField{
+			{FieldName: "material", DataType: &dt.NDIString{MaxLen: 64}, Querable: true},
+		},
+	}
+	repo := newTestRepository(t, sql.SqlLite, baseClass, probe, electrode)
+	ctx := context.Background()
+
+	first := newTestDocument(t, probe, map[string]interface{}{"name": "p1", "site": map[string]interface{}{"area": "v1"}})
+	if err := repo.InsertDocuments([]*NDIDocument{first}, nil, ctx); err != nil {
+		t.Fatal(err)
+	}
+	second := newTestDocument(t, electrode, map[string]interface{}{"name": "e1", "material": "gold", "site": map[string]interface{}{"area": "v1"}})
+	third := newTestDocument(t, electrode, map[string]interface{}{"name": "e2", "material": "gold", "site": map[string]interface{}{"area": "v2"}})
+	if err := repo.InsertDocuments([]*NDIDocument{second, third}, nil, ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	find := func(className string, condition sql.SqlQueryFunction) []string {
+		t.Helper()
+		documents, err := repo.FindDocuments(className, condition, ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids := make([]string, len(documents))
+		for i, document := range documents {
+			ids[i] = document.ID
+		}
+		sort.Strings(ids)
+		return ids
+	}
+	want := func(documents ...*NDIDocument) []string {
+		ids := make([]string, len(documents))
+		for i, document := range documents {
+			ids[i] = document.ID
+		}
+		sort.Strings(ids)
+		return ids
+	}
+	assert := func(got []string, want []string) {
+		t.Helper()
+		if strings.Join(got, ",") != strings.Join(want, ",") {
+			t.Fatalf("got documents %v, want %v", got, want)
+		}
+	}
+
+	assert(find("probe", sql.SQLEqual("", "site.area", "v1")), want(first, second))
+	assert(find("electrode", sql.SQLEqual("", "site.area", "v1")), want(second))
+	assert(find("electrode", sql.SQLEqual("", "material", "gold")), want(second, third))
+	assert(find("probe", nil), want(first, second, third))
+	documents, err := repo.FindDocuments("probe", nil, ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, document := range documents {
+		stored, err := repo.GetDocument(document.ID, ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(document, stored) {
+			t.Fatalf("found %+v, want %+v as returned by GetDocument", document, stored)
+		}
+	}
+
+	third.Content["site"] = map[string]interface{}{"area": "v1"}
+	if err := repo.UpdateDocument(third, nil, ctx); err != nil {
+		t.Fatal(err)
+	}
+	assert(find("probe", sql.SQLEqual("", "site.area", "v1")), want(first, second, third))
+
+	if _, err := repo.DeleteDocuments([]string{second.ID}, DeleteRestrict, nil, ctx); err != nil {
+		t.Fatal(err)
+	}
+	assert(find("probe", sql.SQLEqual("", "site.area", "v1")), want(first, third))
+	assert(find("electrode", nil), want(third))
+}
+
+// Querable tables are created and filled once, given new columns when the class gains
+// querable fields, and never altered inside a transaction of the caller
+func TestQuerableTables(t *testing.T) {
+	probe := &schema.NDISchema{
+		SchemaName:   "probe",
+		Superclasses: []*schema.NDISchema{baseClass},
+		SchemaFields: []*schema.NDIField{
+			{FieldName: "name", DataType: &dt.NDIString{MaxLen: 64}, Querable: true},
+			{FieldName: "area", DataType: &dt.NDIString{MaxLen: 64}},
+		},
+		AdditionalProperties: true,
+	}
+	repo := newTestRepository(t, sql.SqlLite, baseClass, probe)
+	ctx := context.Background()
+	first := newTestDocument(t, probe, map[string]interface{}{"name": "p1", "area": "v1"})
+	second := newTestDocument(t, probe, map[string]interface{}{"name": "p2", "area": "v2"})
+	if err := repo.InsertDocuments([]*NDIDocument{first, second}, nil, ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	// statements reading the documents, as done to fill a querable table
+	reads := 0
+	repo.db.Hooks = append(repo.db.Hooks, sql.QueryHookFunc(func(ctx context.Context, event *sql.QueryEvent) {
+		if strings.Contains(event.Stmt.Stmt, "FROM "+repo.db.Dialect.QuoteIdentifier(DOCUMENT_TABLE_NAME)) {
+			reads++
+		}
+	}))
+
+	// a restarted repository finds the table complete
+	restarted := NewSQLDocumentRepository(repo.db, repo.schemas, repo.blobs)
+	if err := restarted.EnsureQuerableTables("probe", ctx); err != nil {
+		t.Fatal(err)
+	}
+	if reads != 0 {
+		t.Fatalf("read the documents %d times to fill a complete table", reads)
+	}
+
+	// the class gains a querable field, whose column is filled from the stored documents
+	probe.SchemaFields[1] = &schema.NDIField{FieldName: "area", DataType: &dt.NDIString{MaxLen: 64}, Querable: true}
+	err := restarted.db.WithTransaction(ctx, dbsql.LevelDefault, func(ctx context.Context, tx *sql.TransactionManager) error {
+		_, err := restarted.FindDocuments("probe", sql.SQLEqual("", "area", "v1"), ctx)
+		return err
+	})
+	if err == nil || !strings.Contains(err.Error(), "cannot be added inside a transaction") {
+		t.Fatalf("got error %v, want the column not to be added inside a transaction", err)
+	}
+	documents, err := restarted.FindDocuments("probe", sql.SQLEqual("", "area", "v1"), ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(documents) != 1 || documents[0].ID != first.ID || documents[0].Content["name"] != "p1" {
+		t.Fatalf("got %v, want %s", documents, first.ID)
+	}
+	if reads == 0 {
+		t.Fatalf("the new column was not filled")
+	}
+
+	// once complete, the table is written inside transactions as well
+	third := newTestDocument(t, probe, map[string]interface{}{"name": "p3", "area": "v1"})
+	err = restarted.db.WithTransaction(ctx, dbsql.LevelDefault, func(ctx context.Context, tx *sql.TransactionManager) error {
+		return restarted.InsertDocuments([]*NDIDocument{third}, nil, ctx)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if documents, err = restarted.FindDocuments("probe", sql.SQLEqual("", "area", "v1"), ctx); err != nil || len(documents) != 2 {
+		t.Fatalf("got %v, %v, want 2 documents", documents, err)
+	}
+}
+
+var recordingClass = &schema.NDISchema{
+	SchemaName:   "recording",
+	Superclasses: []*schema.NDISchema{baseClass},
+	Files:        []*schema.NDIFile{{FileName: "data"}},
+}
+
+// Storing content that is already stored hands out the existing blob, which must not be
+// collected before being attached
+func TestStoreFileWhileCollectingBlobs(t *testing.T) {
+	repo := newTestRepository(t, sql.SqlLite, baseClass, recordingClass)
+	ctx := context.Background()
+	for i := 0; i < 20; i++ {
+		content := fmt.Sprintf("recording %d", i)
+		document := newTestDocument(t, recordingClass, nil)
+		if err := repo.InsertDocuments([]*NDIDocument{document}, nil, ctx); err != nil {
+			t.Fatal(err)
+		}
+		// stored by an upload that failed before attaching it
+		if _, err := repo.blobs.Put(strings.NewReader(content), ctx); err != nil {
+			t.Fatal(err)
+		}
+
+		var wg sync.WaitGroup
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := repo.CollectBlobs(0, ctx); err != nil {
+				t.Error(err)
+			}
+		}()
+		info, err := repo.StoreFile(document.ID, "data", strings.NewReader(content), nil, ctx)
+		wg.Wait()
+		if err != nil {
+			t.Fatal(err)
+		}
+		file, _, err := repo.OpenFile(document.ID, "data", ctx)
+		if err != nil {
+			t.Fatalf("blob %s attached to %s is gone: %v", info.Key, document.ID, err)
+		}
+		file.Close()
+	}
+}
+
+func TestCollectBlobs(t *testing.T) {
+	repo := newTestRepository(t, sql.SqlLite, baseClass, recordingClass)
+	ctx := context.Background()
+	document := newTestDocument(t, recordingClass, nil)
+	if err := repo.InsertDocuments([]*NDIDocument{document}, nil, ctx); err != nil {
+		t.Fatal(err)
+	}
+	attached, err := repo.StoreFile(document.ID, "data", strings.NewReader("attached"), nil, ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// stored by an upload that failed before attaching it
+	orphan, err := repo.blobs.Put(strings.NewReader("orphan"), ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	deleted, err := repo.CollectBlobs(time.Hour, ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(deleted) != 0 {
+		t.Fatalf("collected %v within the grace period", deleted)
+	}
+
+	deleted, err = repo.CollectBlobs(0, ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(deleted) != 1 || deleted[0] != orphan.Key {
+		t.Fatalf("collected %v, want only %s", deleted, orphan.Key)
+	}
+	if _, err := repo.blobs.Stat(orphan.Key, ctx); !errors.Is(err, blob.ErrBlobNotFound) {
+		t.Fatalf("orphan blob still stored: %v", err)
+	}
+	if _, err := repo.blobs.Stat(attached.Key, ctx); err != nil {
+		t.Fatalf("attached blob was collected: %v", err)
+	}
+}
+
+// The blobs replaced or left by deleted documents are kept for the versions referring to
+// them
+func TestCollectBlobsKeepsHistory(t *testing.T) {
+	repo := newTestRepository(t, sql.SqlLite, baseClass, recordingClass)
+	ctx := context.Background()
+	document := newTestDocument(t, recordingClass, nil)
+	if err := repo.InsertDocuments([]*NDIDocument{document}, nil, ctx); err != nil {
+		t.Fatal(err)
+	}
+	replaced, err := repo.StoreFile(document.ID, "data", strings.NewReader("first take"), nil, ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	deleted, err := repo.StoreFile(document.ID, "data", strings.NewReader("second take"), nil, ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := repo.DeleteDocuments([]string{document.ID}, DeleteRestrict, nil, ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	collected, err := repo.CollectBlobs(0, ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(collected) != 0 {
+		t.Fatalf("collected %v, which the history refers to", collected)
+	}
+	versions, err := repo.ListVersions(document.ID, ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys := make([]string, 0)
+	for _, version := range versions {
+		if version.Document != nil && len(version.Document.Files) > 0 {
+			keys = append(keys, version.Document.Files[0].BlobKey)
+		}
+	}
+	if want := []string{replaced.Key, deleted.Key}; !reflect.DeepEqual(keys, want) {
+		t.Fatalf("versions refer to %v, want %v", keys, want)
+	}
+	for _, key := range keys {
+		if _, err := repo.blobs.Stat(key, ctx); err != nil {
+			t.Fatalf("blob %s of the history is gone: %v", key, err)
+		}
+	}
+}
+
+// A blob that fails to be attached is deleted, unless the attempt was made inside a
+// transaction of the caller, in which case it is left to CollectBlobs
+func TestStoreFileFailure(t *testing.T) {
+	repo := newTestRepository(t, sql.SqlLite, baseClass, recordingClass)
+	ctx := context.Background()
+	document := newTestDocument(t, recordingClass, nil)
+	if err := repo.InsertDocuments([]*NDIDocument{document}, nil, ctx); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := repo.StoreFile(document.ID, "undeclared", strings.NewReader("outside"), nil, ctx); !errors.Is(err, ErrFileNotDeclared) {
+		t.Fatalf("got error %v, want ErrFileNotDeclared", err)
+	}
+	collected, err := repo.CollectBlobs(0, ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(collected) != 0 {
+		t.Fatalf("blobs %v were left behind", collected)
+	}
+
+	err = repo.db.WithTransaction(ctx, dbsql.LevelDefault, func(ctx context.Context, tx *sql.TransactionManager) error {
+		_, err := repo.StoreFile(document.ID, "undeclared", strings.NewReader("inside"), nil, ctx)
+		return err
+	})
+	if !errors.Is(err, ErrFileNotDeclared) {
+		t.Fatalf("got error %v, want ErrFileNotDeclared", err)
+	}
+	collected, err = repo.CollectBlobs(0, ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(collected) != 1 {
+		t.Fatalf("collected %v, want the blob stored inside the transaction", collected)
+	}
+}
+
+// Blob reader blocking until released, once the content has been read
+type blockingReader struct {
+	r       io.Reader
+	reading chan struct{}
+	release chan struct{}
+}
+
+func (reader *blockingReader) Read(p []byte) (int, error) {
+	n, err := reader.r.Read(p)
+	if err == io.EOF {
+		close(reader.reading)
+		<-reader.release
+	}
+	return n, err
+}
+
+// Storing a large file does not hold up the collection of blobs
+func TestStoreFileDoesNotBlockCollection(t *testing.T) {
+	repo := newTestRepository(t, sql.SqlLite, baseClass, recordingClass)
+	ctx := context.Background()
+	document := newTestDocument(t, recordingClass, nil)
+	if err := repo.InsertDocuments([]*NDIDocument{document}, nil, ctx); err != nil {
+		t.Fatal(err)
+	}
+	orphan, err := repo.blobs.Put(strings.NewReader("orphan"), ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	reader := &blockingReader{r: strings.NewReader("uploading"), reading: make(chan struct{}), release: make(chan struct{})}
+	errs := make(chan error, 1)
+	go func() {
+		_, err := repo.StoreFile(document.ID, "data", reader, nil, ctx)
+		errs <- err
+	}()
+	<-reader.reading
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := repo.CollectBlobs(0, ctx)
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("collection waited for the upload")
+	}
+	close(reader.release)
+	if err := <-errs; err != nil {
+		t.Fatal(err)
+	}
+	if _, err := repo.blobs.Stat(orphan.Key, ctx); !errors.Is(err, blob.ErrBlobNotFound) {
+		t.Fatalf("orphan blob still stored: %v", err)
+	}
+	file, _, err := repo.OpenFile(document.ID, "data", ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file.Close()
+}
@@ -0,0 +1,366 @@
+package schema
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	datatypes "github.com/zhaoy17/ndid/internal/datatypes"
+	sql "github.com/zhaoy17/ndid/internal/sql"
+)
+
+// Separator between the names of nested fields in a field path, e.g. "epoch.t0.value"
+const FieldPathSeparator = "."
+
+// Get the path of the field named name nested in the structure at path, or the one of a
+// top-level field if path is empty
+func FieldPath(path string, name string) string {
+	if path == "" {
+		return name
+	}
+	return path + FieldPathSeparator + name
+}
+
+// Get the path of the element at index of the array at path, e.g. "channels[2]"
+func ElementPath(path string, index int) string {
+	return fmt.Sprintf("%s[%d]", path, index)
+}
+
+// Longest column name ColumnNameForPath returns, since MySQL does not accept longer
+// identifiers
+const MaxColumnNameLength = 64
+
+// Get the name of the column storing a querable field, given its path. Nested fields are
+// stored in columns named after their dotted path, e.g. "epoch.t0.value", so that they can
+// be queried by path with the conditions of the sql package. Paths longer than
+// MaxColumnNameLength, or holding characters other than letters, digits, underscores and
+// separators, have them replaced by underscores and are cut short, followed by a hash of
+// the whole path to keep them apart.
+func ColumnNameForPath(path string) string {
+	if len(path) <= MaxColumnNameLength && isSafeColumnName(path) {
+		return path
+	}
+	sum := sha1.Sum([]byte(path))
+	suffix := "_" + hex.EncodeToString(sum[:])[:12]
+	var name strings.Builder
+	for _, r := range path {
+		if name.Len() == MaxColumnNameLength-len(suffix) {
+			break
+		}
+		if isColumnNameRune(r) {
+			name.WriteRune(r)
+		} else {
+			name.WriteByte('_')
+		}
+	}
+	return name.String() + suffix
+}
+
+func isSafeColumnName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for _, field := range strings.Split(name, FieldPathSeparator) {
+		if field == "" {
+			return false
+		}
+		for _, r := range field {
+			if !isColumnNameRune(r) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func isColumnNameRune(r rune) bool {
+	return (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') || r == '_'
+}
+
+// Validate the content of a document, decoded from JSON, against the fields of the schema,
+// including the inherited ones. Structures are validated recursively and may hold an object
+// or an array of objects. Every failure is reported, as ValidationErrors. Fields missing
+// from the content are not reported.
+func (schema *NDISchema) ValidateContent(content map[string]interface{}) error {
+	return schema.validateContent(content, false)
+}
+
+// Prepare the content of a document being created: fill in the default values of the
+// missing fields, in place, then validate the content, reporting as well the missing
+// required fields and, unless the schema allows additional properties, the fields that
+// are not defined in the schema. Defaults of a subclass override the ones of its
+// superclasses.
+func (schema *NDISchema) PrepareContent(content map[string]interface{}) error {
+	applyDefaults(schema.AllFields(), content)
+	return schema.validateContent(content, true)
+}
+
+func (schema *NDISchema) validateContent(content map[string]interface{}, strict bool) error {
+	validator := &contentValidator{strict: strict, additionalProperties: schema.AdditionalProperties}
+	validator.validateFields(schema.AllFields(), content, "")
+	if len(validator.errs) > 0 {
+		return validator.errs
+	}
+	return nil
+}
+
+// Get the querable fields of the schema, keyed by their path. Querable fields nested in
+// structures are flattened so that they can be stored and queried like top-level ones.
+func (schema *NDISchema) QuerableFields() map[string]*NDIField {
+	res := make(map[string]*NDIField)
+	collectQuerableFields(schema.AllFields(), "", res)
+	return res
+}
+
+// Get the columns storing the querable fields of the schema, with their SQL data type
+func (schema *NDISchema) QuerableColumns() (map[string]sql.SqlDataType, error) {
+	res := make(map[string]sql.SqlDataType)
+	// column names are not case sensitive in every database
+	paths := make(map[string]string)
+	for path, field := range schema.QuerableFields() {
+		if field.DataType == nil {
+			return nil, fmt.Errorf("field %s has no data type", path)
+		}
+		dataType, err := field.DataType.ToSqlDataType()
+		if err != nil {
+			return nil, fmt.Errorf("field %s: %w", path, err)
+		}
+		col := ColumnNameForPath(path)
+		if other, ok := paths[strings.ToLower(col)]; ok {
+			return nil, fmt.Errorf("fields %s and %s would be stored in the same column", other, path)
+		}
+		paths[strings.ToLower(col)] = path
+		res[col] = dataType
+	}
+	return res, nil
+}
+
+// Extract the values of the querable fields from the content of a document, keyed by
+// their path, in the form stored in the database (see datatypes.NDINormalizer). The
+// content itself is left untouched. Fields missing from the content are left out, and so
+// are fields nested in arrays of structures since they cannot be stored in a single
+// column.
+func (schema *NDISchema) FlattenContent(content map[string]interface{}) (map[string]string, error) {
+	res := make(map[string]string)
+	for path, field := range schema.QuerableFields() {
+		val, ok := lookupPath(content, path)
+		if !ok || val == nil {
+			continue
+		}
+		str, err := contentValueToString(val)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		if normalizer, ok := field.DataType.(datatypes.NDINormalizer); ok {
+			if str, err = normalizer.Normalize(str); err != nil {
+				return nil, fmt.Errorf("%s: %w", path, err)
+			}
+		}
+		res[path] = str
+	}
+	return res, nil
+}
+
+// Get the values of the content that are neither objects nor arrays, keyed by their path,
+// written like the ones of FlattenContent and ValidationError.FieldPath, e.g. "epoch.t0" or
+// "channels[2].name". Empty objects and arrays are kept as values, so that they are not
+// lost.
+func FlattenValues(content map[string]interface{}) map[string]interface{} {
+	res := make(map[string]interface{})
+	var flatten func(path string, val interface{})
+	flatten = func(path string, val interface{}) {
+		switch v := val.(type) {
+		case map[string]interface{}:
+			if len(v) == 0 && path != "" {
+				res[path] = v
+			}
+			for name, element := range v {
+				flatten(FieldPath(path, name), element)
+			}
+		case []interface{}:
+			if len(v) == 0 {
+				res[path] = v
+			}
+			for i, element := range v {
+				flatten(ElementPath(path, i), element)
+			}
+		default:
+			res[path] = v
+		}
+	}
+	flatten("", content)
+	return res
+}
+
+// Fill in the default values of the fields missing from the content, looking into
+// structures that are present
+func applyDefaults(fields []*NDIField, content map[string]interface{}) {
+	for _, field := range fields {
+		val, ok := content[field.FieldName]
+		if !ok || val == nil {
+			if defaultVal, hasDefault := field.Default(); hasDefault {
+				// the schema is shared by every document, so its default must not be
+				// modified through one of them
+				content[field.FieldName] = copyContentValue(defaultVal)
+			}
+			continue
+		}
+		if !field.IsStructure() {
+			continue
+		}
+		switch v := val.(type) {
+		case map[string]interface{}:
+			applyDefaults(field.Subfields, v)
+		case []interface{}:
+			for _, element := range v {
+				if structure, isObject := element.(map[string]interface{}); isObject {
+					applyDefaults(field.Subfields, structure)
+				}
+			}
+		}
+	}
+}
+
+// Deep copy a value decoded from JSON
+func copyContentValue(val interface{}) interface{} {
+	switch v := val.(type) {
+	case map[string]interface{}:
+		res := make(map[string]interface{}, len(v))
+		for key, element := range v {
+			res[key] = copyContentValue(element)
+		}
+		return res
+	case []interface{}:
+		res := make([]interface{}, len(v))
+		for i, element := range v {
+			res[i] = copyContentValue(element)
+		}
+		return res
+	default:
+		return v
+	}
+}
+
+type contentValidator struct {
+	// Weather or not missing required fields and unknown fields are reported
+	strict               bool
+	additionalProperties bool
+	errs                 ValidationErrors
+}
+
+func (validator *contentValidator) validateFields(fields []*NDIField, content map[string]interface{}, structurePath string) {
+	for _, field := range fields {
+		path := FieldPath(structurePath, field.FieldName)
+		val, ok := content[field.FieldName]
+		if !ok || val == nil {
+			if validator.strict && field.IsRequired() {
+				validator.errs.add(path, val, RuleRequired, "is required")
+			}
+			continue
+		}
+		validator.validateFieldValue(field, val, path)
+	}
+	if validator.strict && !validator.additionalProperties {
+		known := make(map[string]bool, len(fields))
+		for _, field := range fields {
+			known[field.FieldName] = true
+		}
+		names := make([]string, 0)
+		for name := range content {
+			if !known[name] {
+				names = append(names, name)
+			}
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			validator.errs.add(FieldPath(structurePath, name), content[name], RuleUnknownField, "is not defined in the schema")
+		}
+	}
+}
+
+func (validator *contentValidator) validateFieldValue(field *NDIField, val interface{}, path string) {
+	if !field.IsStructure() {
+		if field.DataType == nil {
+			return
+		}
+		str, err := contentValueToString(val)
+		if err != nil {
+			validator.errs.add(path, val, RuleDataType, err.Error())
+			return
+		}
+		if err := field.DataType.Validate(str); err != nil {
+			validator.errs.add(path, val, RuleDataType, err.Error())
+		}
+		return
+	}
+
+	switch v := val.(type) {
+	case map[string]interface{}:
+		validator.validateFields(field.Subfields, v, path)
+	case []interface{}:
+		for i, element := range v {
+			elementPath := ElementPath(path, i)
+			structure, isObject := element.(map[string]interface{})
+			if !isObject {
+				validator.errs.add(elementPath, element, RuleStructure, "must be an object")
+				continue
+			}
+			validator.validateFields(field.Subfields, structure, elementPath)
+		}
+	default:
+		validator.errs.add(path, val, RuleStructure, "must be an object or an array of objects")
+	}
+}
+
+func collectQuerableFields(fields []*NDIField, structurePath string, res map[string]*NDIField) {
+	for _, field := range fields {
+		path := FieldPath(structurePath, field.FieldName)
+		if field.IsStructure() {
+			collectQuerableFields(field.Subfields, path, res)
+		} else if field.Querable {
+			res[path] = field
+		}
+	}
+}
+
+// Get the value at the given path in the content of a document
+func lookupPath(content map[string]interface{}, path string) (interface{}, bool) {
+	var current interface{} = content
+	for _, name := range strings.Split(path, FieldPathSeparator) {
+		structure, isObject := current.(map[string]interface{})
+		if !isObject {
+			return nil, false
+		}
+		val, exists := structure[name]
+		if !exists {
+			return nil, false
+		}
+		current = val
+	}
+	return current, true
+}
+
+// Convert a value decoded from JSON to the string passed to NDIDataType.Validate. Arrays
+// and objects are encoded back to JSON.
+func contentValueToString(val interface{}) (string, error) {
+	switch v := val.(type) {
+	case string:
+		return v, nil
+	case json.Number:
+		return v.String(), nil
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), nil
+	case bool:
+		return strconv.FormatBool(v), nil
+	default:
+		encoded, err := json.Marshal(v)
+		if err != nil {
+			return "", err
+		}
+		return string(encoded), nil
+	}
+}
@@ -0,0 +1,132 @@
+package schema
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	datatypes "github.com/zhaoy17/ndid/internal/datatypes"
+)
+
+func TestPrepareContentCopiesDefaults(t *testing.T) {
+	class := &NDISchema{
+		SchemaName: "tagged",
+		SchemaFields: []*NDIField{
+			{FieldName: "tags", DefaultValue: []interface{}{"raw"}},
+			{FieldName: "meta", DefaultValue: map[string]interface{}{"source": map[string]interface{}{"lab": "a"}}},
+		},
+	}
+
+	first := map[string]interface{}{}
+	if err := class.PrepareContent(first); err != nil {
+		t.Fatal(err)
+	}
+	first["tags"].([]interface{})[0] = "changed"
+	first["meta"].(map[string]interface{})["source"].(map[string]interface{})["lab"] = "b"
+
+	second := map[string]interface{}{}
+	if err := class.PrepareContent(second); err != nil {
+		t.Fatal(err)
+	}
+	if tag := second["tags"].([]interface{})[0]; tag != "raw" {
+		t.Fatalf("got tag %v, want the default to be left untouched", tag)
+	}
+	if lab := second["meta"].(map[string]interface{})["source"].(map[string]interface{})["lab"]; lab != "a" {
+		t.Fatalf("got lab %v, want the default to be left untouched", lab)
+	}
+}
+
+func TestFlattenValuesSharesPathsWithFlattenContent(t *testing.T) {
+	class := &NDISchema{
+		SchemaName: "epoch",
+		SchemaFields: []*NDIField{
+			{FieldName: "epoch", Subfields: []*NDIField{
+				{FieldName: "t0", DataType: &datatypes.NDIString{}, Querable: true},
+			}},
+			{FieldName: "channels", Subfields: []*NDIField{
+				{FieldName: "name", DataType: &datatypes.NDIString{MustHaveRegexPattern: "^[a-z]+$"}},
+			}},
+		},
+	}
+	content := map[string]interface{}{
+		"epoch":    map[string]interface{}{"t0": "start"},
+		"channels": []interface{}{map[string]interface{}{"name": "a"}, map[string]interface{}{"name": "b"}},
+		"notes":    []interface{}{},
+	}
+
+	values := FlattenValues(content)
+	want := map[string]interface{}{
+		"epoch.t0":         "start",
+		"channels[0].name": "a",
+		"channels[1].name": "b",
+		"notes":            []interface{}{},
+	}
+	if len(values) != len(want) {
+		t.Fatalf("got %v, want %v", values, want)
+	}
+	for path, val := range want {
+		if got, ok := values[path]; !ok || fmt.Sprint(got) != fmt.Sprint(val) {
+			t.Fatalf("got %v at %s, want %v", got, path, val)
+		}
+	}
+
+	querable, err := class.FlattenContent(content)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for path, val := range querable {
+		if values[path] != val {
+			t.Fatalf("querable field %s = %v is %v in FlattenValues", path, val, values[path])
+		}
+	}
+
+	content["channels"].([]interface{})[1].(map[string]interface{})["name"] = "B"
+	var errs ValidationErrors
+	if err := class.ValidateContent(content); !errors.As(err, &errs) {
+		t.Fatalf("got %v, want ValidationErrors", err)
+	}
+	if _, ok := values[errs[0].FieldPath]; !ok {
+		t.Fatalf("failure reported at %s, which FlattenValues does not know", errs[0].FieldPath)
+	}
+}
+
+func TestColumnNameForPath(t *testing.T) {
+	for _, path := range []string{"name", "epoch.t0.value", "t_0"} {
+		if got := ColumnNameForPath(path); got != path {
+			t.Fatalf("ColumnNameForPath(%s) = %s, want the path itself", path, got)
+		}
+	}
+
+	long := strings.Repeat("structure.", 10) + "value"
+	names := make(map[string]bool)
+	for _, path := range []string{long, long + "s", "sample rate", "électrode", "a-b", "a_b_"} {
+		got := ColumnNameForPath(path)
+		if len(got) > MaxColumnNameLength || !isSafeColumnName(got) || strings.Contains(got, FieldPathSeparator) {
+			t.Fatalf("ColumnNameForPath(%s) = %s", path, got)
+		}
+		if got != ColumnNameForPath(path) {
+			t.Fatalf("ColumnNameForPath(%s) is not stable", path)
+		}
+		if names[got] {
+			t.Fatalf("ColumnNameForPath(%s) = %s is shared with another path", path, got)
+		}
+		names[got] = true
+	}
+	if got := ColumnNameForPath(long); !strings.HasPrefix(got, "structure_structure_") {
+		t.Fatalf("got %s, want it to start like the path", got)
+	}
+}
+
+func TestQuerableColumnsRejectsSharedColumns(t *testing.T) {
+	class := &NDISchema{
+		SchemaName: "cased",
+		SchemaFields: []*NDIField{
+			{FieldName: "Name", DataType: &datatypes.NDIString{}, Querable: true},
+			{FieldName: "name", DataType: &datatypes.NDIString{}, Querable: true},
+		},
+	}
+	if _, err := class.QuerableColumns(); err == nil {
+		t.Fatalf("stored Name and name in the same column")
+	}
+}
@@ -0,0 +1,280 @@
+package schema
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Returned when the superclasses of a schema lead back to it
+type CycleError struct {
+	// Names of the schemas along the cycle, starting and ending with the same one
+	Path []string
+}
+
+func (err *CycleError) Error() string {
+	return fmt.Sprintf("schema %s inherits from itself: %s", err.Path[0], strings.Join(err.Path, " -> "))
+}
+
+// Returned when two superclasses of a schema define the same field differently, and the
+// schema does not define it itself
+type FieldConflictError struct {
+	SchemaName string
+	FieldPath  string
+
+	// Names of the two conflicting superclasses, and the types they give to the field
+	Superclasses [2]string
+	Types        [2]string
+}
+
+func (err *FieldConflictError) Error() string {
+	return fmt.Sprintf("schema %s inherits conflicting definitions of field %s: %s from %s, %s from %s",
+		err.SchemaName, err.FieldPath, err.Types[0], err.Superclasses[0], err.Types[1], err.Superclasses[1])
+}
+
+// Check that the schema does not inherit from itself, that its superclasses can be
+// linearized, and that they do not define the same field differently
+func (schema *NDISchema) CheckHierarchy() error {
+	mro, err := schema.Linearization()
+	if err != nil {
+		return err
+	}
+	return schema.checkFieldConflicts(mro)
+}
+
+// Get the method resolution order of the schema: the schema followed by its ancestors,
+// computed with C3 linearization (as in Python). Every schema comes before its
+// superclasses, which keep the order they are declared in, so that the first schema
+// defining a field is the one it is looked up from. The order is computed once, when the
+// schema is built or first used, so the superclasses must not change afterwards and the
+// result must not be modified.
+func (schema *NDISchema) Linearization() ([]*NDISchema, error) {
+	schema.hierarchyOnce.Do(schema.computeHierarchy)
+	return schema.mro, schema.mroErr
+}
+
+// Compute the method resolution order, and the order walkHierarchy falls back to if the
+// hierarchy cannot be linearized
+func (schema *NDISchema) computeHierarchy() {
+	if schema.mroErr = schema.checkCycles(); schema.mroErr == nil {
+		schema.mro, schema.mroErr = schema.linearize()
+	}
+	if schema.mroErr == nil {
+		schema.hierarchy = schema.mro
+		return
+	}
+	seenSchemas := make(map[*NDISchema]bool)
+	var walk func(s *NDISchema)
+	walk = func(s *NDISchema) {
+		if s == nil || seenSchemas[s] {
+			return
+		}
+		seenSchemas[s] = true
+		schema.hierarchy = append(schema.hierarchy, s)
+		for _, superclass := range s.Superclasses {
+			walk(superclass)
+		}
+	}
+	walk(schema)
+}
+
+// Merge the linearizations of the superclasses, which have no cycle since the schema has
+// none
+func (schema *NDISchema) linearize() ([]*NDISchema, error) {
+	sequences := make([][]*NDISchema, 0, len(schema.Superclasses)+1)
+	for _, superclass := range schema.Superclasses {
+		mro, err := superclass.Linearization()
+		if err != nil {
+			return nil, err
+		}
+		sequences = append(sequences, append([]*NDISchema{}, mro...))
+	}
+	sequences = append(sequences, append([]*NDISchema{}, schema.Superclasses...))
+
+	res := []*NDISchema{schema}
+	for {
+		nonEmpty := sequences[:0]
+		for _, seq := range sequences {
+			if len(seq) > 0 {
+				nonEmpty = append(nonEmpty, seq)
+			}
+		}
+		sequences = nonEmpty
+		if len(sequences) == 0 {
+			break
+		}
+		// take the first head that does not appear in the tail of any sequence
+		var next *NDISchema
+		for _, seq := range sequences {
+			if !inTail(seq[0], sequences) {
+				next = seq[0]
+				break
+			}
+		}
+		if next == nil {
+			names := make([]string, len(schema.Superclasses))
+			for i, superclass := range schema.Superclasses {
+				names[i] = superclass.SchemaName
+			}
+			return nil, fmt.Errorf("superclasses of schema %s cannot be put in a consistent order: %s",
+				schema.SchemaName, strings.Join(names, ", "))
+		}
+		res = append(res, next)
+		for i, seq := range sequences {
+			if seq[0] == next {
+				sequences[i] = seq[1:]
+			}
+		}
+	}
+	return res, nil
+}
+
+func inTail(schema *NDISchema, sequences [][]*NDISchema) bool {
+	for _, seq := range sequences {
+		for _, s := range seq[1:] {
+			if s == schema {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Look for a path from the schema back to one of the schemas on the way to it
+func (schema *NDISchema) checkCycles() error {
+	done := make(map[*NDISchema]bool)
+	path := make([]*NDISchema, 0)
+	onPath := make(map[*NDISchema]int)
+	var visit func(s *NDISchema) error
+	visit = func(s *NDISchema) error {
+		if start, ok := onPath[s]; ok {
+			names := make([]string, 0, len(path)-start+1)
+			for _, p := range path[start:] {
+				names = append(names, p.SchemaName)
+			}
+			return &CycleError{Path: append(names, s.SchemaName)}
+		}
+		if done[s] {
+			return nil
+		}
+		onPath[s] = len(path)
+		path = append(path, s)
+		for _, superclass := range s.Superclasses {
+			if superclass == nil {
+				return fmt.Errorf("schema %s has a nil superclass", s.SchemaName)
+			}
+			if err := visit(superclass); err != nil {
+				return err
+			}
+		}
+		path = path[:len(path)-1]
+		delete(onPath, s)
+		done[s] = true
+		return nil
+	}
+	return visit(schema)
+}
+
+// Compare the definitions of the fields inherited from different superclasses. A
+// definition overridden by a subclass of the schema it comes from is not compared, since
+// the one of the subclass is the one inherited.
+func (schema *NDISchema) checkFieldConflicts(mro []*NDISchema) error {
+	own := make(map[string]bool)
+	for _, field := range schema.SchemaFields {
+		own[field.FieldName] = true
+	}
+	type origin struct {
+		field  *NDIField
+		schema *NDISchema
+	}
+	inherited := make(map[string][]origin)
+	for _, ancestor := range mro[1:] {
+		for _, field := range ancestor.SchemaFields {
+			if own[field.FieldName] {
+				continue
+			}
+			overridden := false
+			for _, earlier := range inherited[field.FieldName] {
+				overridden = overridden || earlier.schema.IsSubclassOf(ancestor.SchemaName)
+			}
+			if overridden {
+				continue
+			}
+			for _, earlier := range inherited[field.FieldName] {
+				if path, type1, type2, conflict := compareFields(earlier.field, field, field.FieldName); conflict {
+					return &FieldConflictError{
+						SchemaName:   schema.SchemaName,
+						FieldPath:    path,
+						Superclasses: [2]string{earlier.schema.SchemaName, ancestor.SchemaName},
+						Types:        [2]string{type1, type2},
+					}
+				}
+			}
+			inherited[field.FieldName] = append(inherited[field.FieldName], origin{field: field, schema: ancestor})
+		}
+	}
+	return nil
+}
+
+// Compare two definitions of a field, and return the path of the first difference found
+// along with the type of each definition
+func compareFields(field1 *NDIField, field2 *NDIField, path string) (string, string, string, bool) {
+	if field1 == field2 {
+		return "", "", "", false
+	}
+	type1, type2 := describeFieldType(field1), describeFieldType(field2)
+	if type1 != type2 {
+		return path, type1, type2, true
+	}
+	if !field1.IsStructure() {
+		return "", "", "", false
+	}
+	subfields2 := make(map[string]*NDIField, len(field2.Subfields))
+	for _, subfield := range field2.Subfields {
+		subfields2[subfield.FieldName] = subfield
+	}
+	for _, subfield1 := range field1.Subfields {
+		subfieldPath := path + FieldPathSeparator + subfield1.FieldName
+		subfield2, ok := subfields2[subfield1.FieldName]
+		if !ok {
+			return subfieldPath, describeFieldType(subfield1), "nothing", true
+		}
+		if p, t1, t2, conflict := compareFields(subfield1, subfield2, subfieldPath); conflict {
+			return p, t1, t2, true
+		}
+	}
+	if len(field1.Subfields) != len(field2.Subfields) {
+		for _, subfield2 := range field2.Subfields {
+			if !containsField(field1.Subfields, subfield2.FieldName) {
+				return path + FieldPathSeparator + subfield2.FieldName, "nothing", describeFieldType(subfield2), true
+			}
+		}
+	}
+	return "", "", "", false
+}
+
+// Describe the type of a field for comparison: its type name and parameters if known, the
+// Go type of its data type otherwise
+func describeFieldType(field *NDIField) string {
+	switch {
+	case field.IsStructure():
+		return "structure"
+	case field.TypeName != "":
+		if field.Parameters == "" {
+			return strings.ToLower(field.TypeName)
+		}
+		return fmt.Sprintf("%s(%s)", strings.ToLower(field.TypeName), field.Parameters)
+	case field.DataType == nil:
+		return "untyped"
+	default:
+		return fmt.Sprintf("%T", field.DataType)
+	}
+}
+
+func containsField(fields []*NDIField, name string) bool {
+	for _, field := range fields {
+		if field.FieldName == name {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,187 @@
+package schema
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func integerField(name string, parameters string) *NDIField {
+	return &NDIField{FieldName: name, TypeName: "integer", Parameters: parameters}
+}
+
+func schemaNames(schemas []*NDISchema) []string {
+	names := make([]string, len(schemas))
+	for i, schema := range schemas {
+		names[i] = schema.SchemaName
+	}
+	return names
+}
+
+// The example of the C3 paper, as used by Python
+func TestLinearization(t *testing.T) {
+	o := &NDISchema{SchemaName: "o"}
+	a := &NDISchema{SchemaName: "a", Superclasses: []*NDISchema{o}}
+	b := &NDISchema{SchemaName: "b", Superclasses: []*NDISchema{o}}
+	c := &NDISchema{SchemaName: "c", Superclasses: []*NDISchema{o}}
+	d := &NDISchema{SchemaName: "d", Superclasses: []*NDISchema{o}}
+	e := &NDISchema{SchemaName: "e", Superclasses: []*NDISchema{o}}
+	k1 := &NDISchema{SchemaName: "k1", Superclasses: []*NDISchema{a, b, c}}
+	k2 := &NDISchema{SchemaName: "k2", Superclasses: []*NDISchema{d, b, e}}
+	k3 := &NDISchema{SchemaName: "k3", Superclasses: []*NDISchema{d, a}}
+	z := &NDISchema{SchemaName: "z", Superclasses: []*NDISchema{k1, k2, k3}}
+
+	mro, err := z.Linearization()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"z", "k1", "k2", "k3", "d", "a", "b", "c", "e", "o"}
+	if got := schemaNames(mro); !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	// computed once
+	if again, _ := z.Linearization(); &again[0] != &mro[0] {
+		t.Fatalf("the linearization was computed again")
+	}
+	if !z.IsSubclassOf("o") || !z.IsSubclassOf("z") || k1.IsSubclassOf("d") {
+		t.Fatalf("IsSubclassOf does not follow the hierarchy")
+	}
+}
+
+func TestLinearizationInconsistentOrder(t *testing.T) {
+	x := &NDISchema{SchemaName: "x"}
+	y := &NDISchema{SchemaName: "y"}
+	a := &NDISchema{SchemaName: "a", Superclasses: []*NDISchema{x, y}}
+	b := &NDISchema{SchemaName: "b", Superclasses: []*NDISchema{y, x}}
+	z := &NDISchema{SchemaName: "z", Superclasses: []*NDISchema{a, b}}
+	if _, err := z.Linearization(); err == nil || !strings.Contains(err.Error(), "consistent order") {
+		t.Fatalf("got error %v, want the order to be rejected", err)
+	}
+	// the hierarchy can still be walked
+	if !z.IsSubclassOf("y") {
+		t.Fatalf("z does not inherit from y")
+	}
+}
+
+func TestCheckHierarchyCycle(t *testing.T) {
+	a := &NDISchema{SchemaName: "a"}
+	b := &NDISchema{SchemaName: "b", Superclasses: []*NDISchema{a}}
+	c := &NDISchema{SchemaName: "c", Superclasses: []*NDISchema{b}}
+	a.Superclasses = []*NDISchema{c}
+	d := &NDISchema{SchemaName: "d", Superclasses: []*NDISchema{b}}
+
+	var cycleErr *CycleError
+	if err := d.CheckHierarchy(); !errors.As(err, &cycleErr) {
+		t.Fatalf("got error %v, want a CycleError", err)
+	}
+	if want := []string{"b", "a", "c", "b"}; !reflect.DeepEqual(cycleErr.Path, want) {
+		t.Fatalf("got path %v, want %v", cycleErr.Path, want)
+	}
+	if !d.IsSubclassOf("c") {
+		t.Fatalf("d does not inherit from c")
+	}
+}
+
+func TestCheckHierarchyFieldConflicts(t *testing.T) {
+	tests := []struct {
+		name    string
+		schema  func() *NDISchema
+		wantErr *FieldConflictError
+	}{
+		{
+			// c{f: integer(0,100)} <- b{f: integer(0,10)} <- a
+			name: "subclass overriding its superclass",
+			schema: func() *NDISchema {
+				c := &NDISchema{SchemaName: "c", SchemaFields: []*NDIField{integerField("f", "0,100")}}
+				b := &NDISchema{SchemaName: "b", Superclasses: []*NDISchema{c}, SchemaFields: []*NDIField{integerField("f", "0,10")}}
+				return &NDISchema{SchemaName: "a", Superclasses: []*NDISchema{b}}
+			},
+		},
+		{
+			// one branch of a diamond overrides the field, the other inherits it
+			name: "override in one branch of a diamond",
+			schema: func() *NDISchema {
+				base := &NDISchema{SchemaName: "base", SchemaFields: []*NDIField{integerField("f", "0,100")}}
+				left := &NDISchema{SchemaName: "left", Superclasses: []*NDISchema{base}, SchemaFields: []*NDIField{integerField("f", "0,10")}}
+				right := &NDISchema{SchemaName: "right", Superclasses: []*NDISchema{base}}
+				return &NDISchema{SchemaName: "bottom", Superclasses: []*NDISchema{left, right}}
+			},
+		},
+		{
+			name: "unrelated branches",
+			schema: func() *NDISchema {
+				left := &NDISchema{SchemaName: "left", SchemaFields: []*NDIField{integerField("f", "0,10")}}
+				right := &NDISchema{SchemaName: "right", SchemaFields: []*NDIField{integerField("f", "0,100")}}
+				return &NDISchema{SchemaName: "bottom", Superclasses: []*NDISchema{left, right}}
+			},
+			wantErr: &FieldConflictError{
+				SchemaName:   "bottom",
+				FieldPath:    "f",
+				Superclasses: [2]string{"left", "right"},
+				Types:        [2]string{"integer(0,10)", "integer(0,100)"},
+			},
+		},
+		{
+			// both branches override the field of their common superclass differently
+			name: "branches overriding differently",
+			schema: func() *NDISchema {
+				base := &NDISchema{SchemaName: "base", SchemaFields: []*NDIField{integerField("f", "")}}
+				left := &NDISchema{SchemaName: "left", Superclasses: []*NDISchema{base}, SchemaFields: []*NDIField{integerField("f", "0,10")}}
+				right := &NDISchema{SchemaName: "right", Superclasses: []*NDISchema{base}, SchemaFields: []*NDIField{integerField("f", "0,100")}}
+				return &NDISchema{SchemaName: "bottom", Superclasses: []*NDISchema{left, right}}
+			},
+			wantErr: &FieldConflictError{
+				SchemaName:   "bottom",
+				FieldPath:    "f",
+				Superclasses: [2]string{"left", "right"},
+				Types:        [2]string{"integer(0,10)", "integer(0,100)"},
+			},
+		},
+		{
+			name: "nested fields",
+			schema: func() *NDISchema {
+				left := &NDISchema{SchemaName: "left", SchemaFields: []*NDIField{
+					{FieldName: "epoch", Subfields: []*NDIField{integerField("t0", "")}},
+				}}
+				right := &NDISchema{SchemaName: "right", SchemaFields: []*NDIField{
+					{FieldName: "epoch", Subfields: []*NDIField{integerField("t0", ""), integerField("t1", "")}},
+				}}
+				return &NDISchema{SchemaName: "bottom", Superclasses: []*NDISchema{left, right}}
+			},
+			wantErr: &FieldConflictError{
+				SchemaName:   "bottom",
+				FieldPath:    "epoch.t1",
+				Superclasses: [2]string{"left", "right"},
+				Types:        [2]string{"nothing", "integer"},
+			},
+		},
+		{
+			// the schema settles the conflict by defining the field itself
+			name: "conflict resolved by the schema",
+			schema: func() *NDISchema {
+				left := &NDISchema{SchemaName: "left", SchemaFields: []*NDIField{integerField("f", "0,10")}}
+				right := &NDISchema{SchemaName: "right", SchemaFields: []*NDIField{integerField("f", "0,100")}}
+				return &NDISchema{SchemaName: "bottom", Superclasses: []*NDISchema{left, right}, SchemaFields: []*NDIField{integerField("f", "0,5")}}
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := test.schema().CheckHierarchy()
+			if test.wantErr == nil {
+				if err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			var conflictErr *FieldConflictError
+			if !errors.As(err, &conflictErr) {
+				t.Fatalf("got error %v, want a FieldConflictError", err)
+			}
+			if !reflect.DeepEqual(conflictErr, test.wantErr) {
+				t.Fatalf("got %+v, want %+v", conflictErr, test.wantErr)
+			}
+		})
+	}
+}
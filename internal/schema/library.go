@@ -0,0 +1,41 @@
+package schema
+
+import (
+	"embed"
+	"fmt"
+	"path"
+	"sort"
+)
+
+// Version of the schema library shipped with the binary. Bump it whenever a definition in
+// library/ changes, so that SQLSchemaRepository.UpgradeLibrary updates the stored copies.
+const LibraryVersion = 1
+
+// Definitions of the standard NDI classes
+//
+//go:embed library/*.json
+var libraryFiles embed.FS
+
+// Get the definitions of the schema library, ordered by class name
+func LibraryDefinitions() ([]*NDISchemaDefinition, error) {
+	entries, err := libraryFiles.ReadDir("library")
+	if err != nil {
+		return nil, err
+	}
+	definitions := make([]*NDISchemaDefinition, 0, len(entries))
+	for _, entry := range entries {
+		data, err := libraryFiles.ReadFile(path.Join("library", entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+		definition, err := ParseSchemaDefinition(data)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", entry.Name(), err)
+		}
+		definitions = append(definitions, definition)
+	}
+	sort.Slice(definitions, func(i, j int) bool {
+		return definitions[i].ClassName < definitions[j].ClassName
+	})
+	return definitions, nil
+}
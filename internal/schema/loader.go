@@ -1 +1,344 @@
 package schema
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	datatypes "github.com/zhaoy17/ndid/internal/datatypes"
+)
+
+// Definition of a schema, as written in JSON (see README)
+type NDISchemaDefinition struct {
+	ClassName     string `json:"classname"`
+	Documentation string `json:"documentation,omitempty"`
+
+	Superclasses []*NDISuperclassDefinition `json:"superclasses"`
+
+	// Each entry maps the name of a dependency to the class of the documents it may refer
+	// to, which is empty if any class is accepted
+	DependsOn []map[string]string `json:"depends_on"`
+
+	// Each entry declares a file by mapping its name to an empty string, and may set its
+	// location with a key starting with "location", e.g. { "raw": "", "location": "raw.bin" }
+	Files []map[string]string `json:"file,omitempty"`
+
+	Fields []*NDIFieldDefinition `json:"field"`
+
+	AdditionalProperties bool `json:"additional_properties,omitempty"`
+}
+
+// Key of an entry of the file section holding the location of the file
+const fileLocationKey = "location"
+
+type NDISuperclassDefinition struct {
+	Name string `json:"name"`
+}
+
+// Definition of a field. Either Subfield is set, making the field a structure, or the
+// other properties are. An empty default_value means that the field has no default.
+type NDIFieldDefinition struct {
+	Name          string      `json:"name,omitempty"`
+	Type          string      `json:"type,omitempty"`
+	DefaultValue  interface{} `json:"default_value,omitempty"`
+	Parameters    string      `json:"parameters,omitempty"`
+	Queryable     jsonFlag    `json:"queryable,omitempty"`
+	Required      jsonFlag    `json:"required,omitempty"`
+	Documentation string      `json:"documentation,omitempty"`
+
+	Subfield *NDISubfieldDefinition `json:"subfield,omitempty"`
+}
+
+type NDISubfieldDefinition struct {
+	Name          string                `json:"name"`
+	Documentation string                `json:"documentation,omitempty"`
+	Fields        []*NDIFieldDefinition `json:"field"`
+}
+
+// Get the name of the field, or of the structure if the field is one
+func (definition *NDIFieldDefinition) fieldName() string {
+	if definition.Subfield != nil {
+		return definition.Subfield.Name
+	}
+	return definition.Name
+}
+
+// Boolean written as 1 or 0, as in the schema definitions of NDI. true and false are
+// accepted as well.
+type jsonFlag bool
+
+func (flag jsonFlag) MarshalJSON() ([]byte, error) {
+	if flag {
+		return []byte("1"), nil
+	}
+	return []byte("0"), nil
+}
+
+func (flag *jsonFlag) UnmarshalJSON(data []byte) error {
+	switch string(bytes.TrimSpace(data)) {
+	case "1", "true", `"1"`, `"true"`:
+		*flag = true
+	case "0", "false", `"0"`, `"false"`, `""`, "null":
+		*flag = false
+	default:
+		return fmt.Errorf("%s is not a valid flag, which must be 1 or 0", string(data))
+	}
+	return nil
+}
+
+// Decode a schema definition from JSON
+func ParseSchemaDefinition(data []byte) (*NDISchemaDefinition, error) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	definition := &NDISchemaDefinition{}
+	if err := decoder.Decode(definition); err != nil {
+		return nil, fmt.Errorf("invalid schema definition: %w", err)
+	}
+	if definition.ClassName == "" {
+		return nil, fmt.Errorf("invalid schema definition: classname is required")
+	}
+	return definition, nil
+}
+
+// Build the schemas described by the definitions. Superclasses and the classes required
+// by dependencies are looked up among the definitions first, then among existing, which
+// may be nil. The definitions can be given in any order.
+func BuildSchemas(definitions []*NDISchemaDefinition, existing map[string]*NDISchema) (map[string]*NDISchema, error) {
+	res := make(map[string]*NDISchema, len(definitions))
+	for _, definition := range definitions {
+		if _, duplicate := res[definition.ClassName]; duplicate {
+			return nil, fmt.Errorf("schema %s is defined more than once", definition.ClassName)
+		}
+		fields, err := buildFields(definition.Fields, "")
+		if err != nil {
+			return nil, fmt.Errorf("schema %s: %w", definition.ClassName, err)
+		}
+		res[definition.ClassName] = &NDISchema{
+			SchemaName:           definition.ClassName,
+			Description:          definition.Documentation,
+			SchemaFields:         fields,
+			AdditionalProperties: definition.AdditionalProperties,
+		}
+	}
+	lookup := func(name string) (*NDISchema, bool) {
+		if schema, ok := res[name]; ok {
+			return schema, true
+		}
+		schema, ok := existing[name]
+		return schema, ok
+	}
+	for _, definition := range definitions {
+		schema := res[definition.ClassName]
+		for _, superclass := range definition.Superclasses {
+			superSchema, ok := lookup(superclass.Name)
+			if !ok {
+				return nil, fmt.Errorf("superclass %s of schema %s is not defined", superclass.Name, definition.ClassName)
+			}
+			schema.Superclasses = append(schema.Superclasses, superSchema)
+		}
+		for _, dependencies := range definition.DependsOn {
+			// sort the names so that the order does not depend on the map
+			names := make([]string, 0, len(dependencies))
+			for name := range dependencies {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+			for _, name := range names {
+				dependency := &NDIDependency{DependencyName: name}
+				if className := dependencies[name]; className != "" {
+					dependedOn, ok := lookup(className)
+					if !ok {
+						return nil, fmt.Errorf("class %s of dependency %s of schema %s is not defined", className, name, definition.ClassName)
+					}
+					dependency.SchemaDependsOn = dependedOn
+				}
+				schema.Dependencies = append(schema.Dependencies, dependency)
+			}
+		}
+		seenFiles := make(map[string]bool)
+		for _, entry := range definition.Files {
+			file, err := buildFile(entry)
+			if err != nil {
+				return nil, fmt.Errorf("schema %s: %w", definition.ClassName, err)
+			}
+			if seenFiles[file.FileName] {
+				return nil, fmt.Errorf("schema %s: file %s is declared more than once", definition.ClassName, file.FileName)
+			}
+			seenFiles[file.FileName] = true
+			schema.Files = append(schema.Files, file)
+		}
+	}
+	// the hierarchy is complete, so the method resolution orders are computed now rather
+	// than on first use; the hierarchies that cannot be linearized are reported by
+	// CheckHierarchy
+	for _, schema := range res {
+		schema.Linearization()
+	}
+	return res, nil
+}
+
+// Build a file from an entry of the file section of a schema definition
+func buildFile(entry map[string]string) (*NDIFile, error) {
+	file := &NDIFile{}
+	hasLocation := false
+	for key, val := range entry {
+		if strings.HasPrefix(key, fileLocationKey) {
+			if hasLocation {
+				return nil, fmt.Errorf("an entry of the file section has more than one location")
+			}
+			file.Location, hasLocation = val, true
+			continue
+		}
+		if file.FileName != "" {
+			return nil, fmt.Errorf("an entry of the file section declares both %s and %s",
+				file.FileName, key)
+		}
+		file.FileName = key
+	}
+	if file.FileName == "" {
+		return nil, fmt.Errorf("an entry of the file section has no name")
+	}
+	return file, nil
+}
+
+// Get the definition of the schema, to be stored as JSON. Every field must have been
+// given the name of its data type.
+func (schema *NDISchema) Definition() (*NDISchemaDefinition, error) {
+	fields, err := fieldDefinitions(schema.SchemaFields, "")
+	if err != nil {
+		return nil, fmt.Errorf("schema %s: %w", schema.SchemaName, err)
+	}
+	definition := &NDISchemaDefinition{
+		ClassName:            schema.SchemaName,
+		Documentation:        schema.Description,
+		Superclasses:         make([]*NDISuperclassDefinition, len(schema.Superclasses)),
+		DependsOn:            make([]map[string]string, len(schema.Dependencies)),
+		Fields:               fields,
+		AdditionalProperties: schema.AdditionalProperties,
+	}
+	for _, file := range schema.Files {
+		entry := map[string]string{file.FileName: ""}
+		if file.Location != "" {
+			entry[fileLocationKey] = file.Location
+		}
+		definition.Files = append(definition.Files, entry)
+	}
+	for i, superclass := range schema.Superclasses {
+		definition.Superclasses[i] = &NDISuperclassDefinition{Name: superclass.SchemaName}
+	}
+	for i, dependency := range schema.Dependencies {
+		className := ""
+		if dependency.SchemaDependsOn != nil {
+			className = dependency.SchemaDependsOn.SchemaName
+		}
+		definition.DependsOn[i] = map[string]string{dependency.DependencyName: className}
+	}
+	return definition, nil
+}
+
+func buildFields(definitions []*NDIFieldDefinition, prefix string) ([]*NDIField, error) {
+	fields := make([]*NDIField, 0, len(definitions))
+	seen := make(map[string]bool)
+	for _, definition := range definitions {
+		var field *NDIField
+		if definition.Subfield != nil {
+			subfields, err := buildFields(definition.Subfield.Fields, prefix+definition.Subfield.Name+FieldPathSeparator)
+			if err != nil {
+				return nil, err
+			}
+			if len(subfields) == 0 {
+				return nil, fmt.Errorf("structure %s%s has no field", prefix, definition.Subfield.Name)
+			}
+			field = &NDIField{
+				FieldName:   definition.Subfield.Name,
+				Description: definition.Subfield.Documentation,
+				Subfields:   subfields,
+			}
+		} else {
+			dataType, err := datatypes.NewDataType(definition.Type, definition.Parameters)
+			if err != nil {
+				return nil, fmt.Errorf("field %s%s: %w", prefix, definition.Name, err)
+			}
+			field = &NDIField{
+				FieldName:   definition.Name,
+				Description: definition.Documentation,
+				DataType:    dataType,
+				Querable:    bool(definition.Queryable),
+				TypeName:    definition.Type,
+				Parameters:  definition.Parameters,
+				Required:    bool(definition.Required),
+			}
+			if definition.DefaultValue != "" {
+				field.DefaultValue = definition.DefaultValue
+			}
+			if err := validateDefault(field); err != nil {
+				return nil, fmt.Errorf("field %s%s: %w", prefix, definition.Name, err)
+			}
+		}
+		if field.FieldName == "" {
+			return nil, fmt.Errorf("a field of %s has no name", describePrefix(prefix))
+		}
+		if seen[field.FieldName] {
+			return nil, fmt.Errorf("field %s%s is defined more than once", prefix, field.FieldName)
+		}
+		seen[field.FieldName] = true
+		fields = append(fields, field)
+	}
+	return fields, nil
+}
+
+// Check that the default value of the field, if any, is accepted by its data type, so that
+// documents do not fail validation because of a value they were never given
+func validateDefault(field *NDIField) error {
+	if field.DefaultValue == nil {
+		return nil
+	}
+	str, err := contentValueToString(field.DefaultValue)
+	if err == nil {
+		err = field.DataType.Validate(str)
+	}
+	if err != nil {
+		return fmt.Errorf("invalid default value %v: %w", field.DefaultValue, err)
+	}
+	return nil
+}
+
+func fieldDefinitions(fields []*NDIField, prefix string) ([]*NDIFieldDefinition, error) {
+	definitions := make([]*NDIFieldDefinition, len(fields))
+	for i, field := range fields {
+		if field.IsStructure() {
+			subfields, err := fieldDefinitions(field.Subfields, prefix+field.FieldName+FieldPathSeparator)
+			if err != nil {
+				return nil, err
+			}
+			definitions[i] = &NDIFieldDefinition{Subfield: &NDISubfieldDefinition{
+				Name:          field.FieldName,
+				Documentation: field.Description,
+				Fields:        subfields,
+			}}
+			continue
+		}
+		if field.TypeName == "" {
+			return nil, fmt.Errorf("field %s%s has no type name", prefix, field.FieldName)
+		}
+		definitions[i] = &NDIFieldDefinition{
+			Name:          field.FieldName,
+			Type:          field.TypeName,
+			DefaultValue:  field.DefaultValue,
+			Parameters:    field.Parameters,
+			Queryable:     jsonFlag(field.Querable),
+			Required:      jsonFlag(field.Required),
+			Documentation: field.Description,
+		}
+	}
+	return definitions, nil
+}
+
+func describePrefix(prefix string) string {
+	if prefix == "" {
+		return "the schema"
+	}
+	return "structure " + prefix[:len(prefix)-len(FieldPathSeparator)]
+}
@@ -1,12 +1,18 @@
 package schema
 
-import "context"
+import (
+	"context"
+	"errors"
+)
+
+var ErrSchemaNotFound = errors.New("schema not found")
 
 type DIDSchemaRepository interface {
-	GetSchema(schemaName []string, ctx context.Context) (*NDISchema, error)
+	GetSchema(schemaName string, ctx context.Context) (*NDISchema, error)
 	GetAllSchemas(ctx context.Context) (map[string]*NDISchema, error)
 	InsertSchemas(schemas []*NDISchema, ctx context.Context) error
 	DeleteSchemas(schemaNames []string, ctx context.Context) error
 	UpdateSchema(schemaName string, fieldsToUpdateInto map[string]*NDIField, ctx context.Context) error
+	UpgradeLibrary(ctx context.Context) ([]string, error)
 	Setup(context.Context) error
 }
@@ -1,6 +1,10 @@
 package schema
 
-import datatypes "github.com/zhaoy17/ndid/internal/datatypes"
+import (
+	"sync"
+
+	datatypes "github.com/zhaoy17/ndid/internal/datatypes"
+)
 
 type NDISchema struct {
 	SchemaName   string
@@ -8,6 +12,20 @@ type NDISchema struct {
 	SchemaFields []*NDIField
 	Dependencies []*NDIDependency
 	Superclasses []*NDISchema
+
+	// Binary files documents may have attached, by name
+	Files []*NDIFile
+
+	// Weather or not documents may hold fields that are not defined in the schema or its
+	// superclasses
+	AdditionalProperties bool
+
+	// method resolution order and the error computing it, and the order walkHierarchy
+	// visits the hierarchy in, computed once (see Linearization)
+	mro           []*NDISchema
+	mroErr        error
+	hierarchy     []*NDISchema
+	hierarchyOnce sync.Once
 }
 
 type NDIField struct {
@@ -15,9 +33,130 @@ type NDIField struct {
 	Description string
 	DataType    datatypes.NDIDataType
 	Querable    bool
+
+	// Name and parameters DataType was created from with datatypes.NewDataType, written
+	// back to the schema definition when the schema is stored
+	TypeName   string
+	Parameters string
+
+	// Value given to the field, as decoded from JSON, when a document is created without
+	// it. Ignored if nil. Overrides the default of the data type, if any.
+	DefaultValue interface{}
+
+	// Weather or not documents must have the field, after defaults have been applied
+	Required bool
+
+	// Fields nested in this one, making it a structure. DataType is ignored for structures.
+	Subfields []*NDIField
+}
+
+// Check if the field is a structure holding nested fields
+func (field *NDIField) IsStructure() bool {
+	return len(field.Subfields) > 0
+}
+
+// Get the default value of the field, either set on the field itself or on its data type
+func (field *NDIField) Default() (interface{}, bool) {
+	if field.DefaultValue != nil {
+		return field.DefaultValue, true
+	}
+	if str, ok := field.DataType.(*datatypes.NDIString); ok && !str.NotNull && str.DefaultValue != "" {
+		return str.DefaultValue, true
+	}
+	return nil, false
+}
+
+// Check if documents must have the field, either because the field is required or because
+// its data type cannot be null
+func (field *NDIField) IsRequired() bool {
+	if field.Required {
+		return true
+	}
+	str, ok := field.DataType.(*datatypes.NDIString)
+	return ok && str.NotNull
+}
+
+// Get the fields of the schema followed by the ones inherited from its superclasses, in
+// method resolution order. A field hides the fields with the same name further along.
+func (schema *NDISchema) AllFields() []*NDIField {
+	res := make([]*NDIField, 0, len(schema.SchemaFields))
+	seenFields := make(map[string]bool)
+	schema.walkHierarchy(func(s *NDISchema) {
+		for _, field := range s.SchemaFields {
+			if !seenFields[field.FieldName] {
+				seenFields[field.FieldName] = true
+				res = append(res, field)
+			}
+		}
+	})
+	return res
+}
+
+// Get the dependencies of the schema followed by the ones inherited from its superclasses,
+// in the same order as AllFields. A dependency hides the ones with the same name further
+// up the hierarchy.
+func (schema *NDISchema) AllDependencies() []*NDIDependency {
+	res := make([]*NDIDependency, 0, len(schema.Dependencies))
+	seenDependencies := make(map[string]bool)
+	schema.walkHierarchy(func(s *NDISchema) {
+		for _, dependency := range s.Dependencies {
+			if !seenDependencies[dependency.DependencyName] {
+				seenDependencies[dependency.DependencyName] = true
+				res = append(res, dependency)
+			}
+		}
+	})
+	return res
+}
+
+// Get the files declared by the schema followed by the ones inherited from its
+// superclasses, in the same order as AllFields. A file hides the ones with the same name
+// further up the hierarchy.
+func (schema *NDISchema) AllFiles() []*NDIFile {
+	res := make([]*NDIFile, 0, len(schema.Files))
+	seenFiles := make(map[string]bool)
+	schema.walkHierarchy(func(s *NDISchema) {
+		for _, file := range s.Files {
+			if !seenFiles[file.FileName] {
+				seenFiles[file.FileName] = true
+				res = append(res, file)
+			}
+		}
+	})
+	return res
+}
+
+// Check if the schema is the one named className or inherits from it
+func (schema *NDISchema) IsSubclassOf(className string) bool {
+	found := false
+	schema.walkHierarchy(func(s *NDISchema) {
+		if s.SchemaName == className {
+			found = true
+		}
+	})
+	return found
+}
+
+// Call fn on the schema and each of its ancestors once, in method resolution order (see
+// Linearization). Fall back to a depth-first walk in the order the superclasses are
+// declared if the hierarchy cannot be linearized, which CheckHierarchy reports.
+func (schema *NDISchema) walkHierarchy(fn func(s *NDISchema)) {
+	schema.hierarchyOnce.Do(schema.computeHierarchy)
+	for _, s := range schema.hierarchy {
+		fn(s)
+	}
 }
 
 type NDIDependency struct {
 	DependencyName  string
 	SchemaDependsOn *NDISchema
 }
+
+// Binary file attached to documents, whose content is kept in a blob store
+type NDIFile struct {
+	FileName string
+
+	// Where the file is expected to be found when the document is exported, e.g. a path
+	// relative to the directory of the document. Empty if unspecified.
+	Location string
+}
@@ -2,36 +2,408 @@ package schema
 
 import (
 	"context"
+	dbsql "database/sql"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
 
 	sql "github.com/zhaoy17/ndid/internal/sql"
 )
 
 const SCHEMA_TABLE_NAME = "ndischema"
 
+// Store the definition of each schema as JSON, and rebuild the schemas from their
+// definitions when they are read.
+//
+// The schemas of the library (see LibraryDefinitions) are stored along with the version of
+// the library they come from. Fields added to them or replaced with UpdateSchema are kept
+// apart as extensions, so that upgrading the library does not overwrite them.
 type SQLSchemaRepository struct {
-	db sql.SqlDatabase
+	db *sql.SqlDatabase
 }
 
-func (schemaRepository *SQLSchemaRepository) InsertSchemas(schemas []*NDISchema) error {
-	return nil
+type schemaRow struct {
+	SchemaName     string `sql:"schema_name"`
+	Definition     string `sql:"schema_definition"`
+	LibraryVersion int    `sql:"library_version"`
+	Extension      string `sql:"extension_definition"`
 }
 
+// Schema as stored in the repository
+type storedSchema struct {
+	definition *NDISchemaDefinition
+
+	// Version of the library the schema comes from, 0 if it is not part of the library
+	libraryVersion int
+
+	// Fields added to a schema of the library, or replacing its fields
+	extension []*NDIFieldDefinition
+}
+
+func NewSQLSchemaRepository(db *sql.SqlDatabase) *SQLSchemaRepository {
+	return &SQLSchemaRepository{db: db}
+}
+
+func (schemaRepository *SQLSchemaRepository) GetSchema(schemaName string, ctx context.Context) (*NDISchema, error) {
+	schemas, err := schemaRepository.GetAllSchemas(ctx)
+	if err != nil {
+		return nil, err
+	}
+	schema, ok := schemas[schemaName]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrSchemaNotFound, schemaName)
+	}
+	return schema, nil
+}
+
+// Get all the stored schemas, keyed by their name
+func (schemaRepository *SQLSchemaRepository) GetAllSchemas(ctx context.Context) (map[string]*NDISchema, error) {
+	stored, err := schemaRepository.getStoredSchemas(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return buildStoredSchemas(stored)
+}
+
+// Insert the schemas in a single transaction. Fail if a schema already exists, refers to a
+// schema that is neither inserted nor stored, inherits from itself, or inherits
+// conflicting definitions of a field (see CheckHierarchy).
+func (schemaRepository *SQLSchemaRepository) InsertSchemas(schemas []*NDISchema, ctx context.Context) error {
+	return schemaRepository.db.WithTransaction(ctx, dbsql.LevelDefault, func(ctx context.Context, tx *sql.TransactionManager) error {
+		stored, err := schemaRepository.getStoredSchemas(ctx)
+		if err != nil {
+			return err
+		}
+		inserted := make([]*storedSchema, len(schemas))
+		for i, schema := range schemas {
+			if _, exists := stored[schema.SchemaName]; exists {
+				return fmt.Errorf("schema %s already exists", schema.SchemaName)
+			}
+			definition, err := schema.Definition()
+			if err != nil {
+				return err
+			}
+			inserted[i] = &storedSchema{definition: definition}
+			stored[schema.SchemaName] = inserted[i]
+		}
+		if _, err := buildStoredSchemas(stored); err != nil {
+			return err
+		}
+		for _, schema := range inserted {
+			if err := schemaRepository.storeSchema(schema, false, ctx); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Delete the schemas in a single transaction. Fail if a schema belongs to the library, or
+// if a schema that is not deleted inherits from one of them or depends on documents of
+// their classes.
+func (schemaRepository *SQLSchemaRepository) DeleteSchemas(schemaNames []string, ctx context.Context) error {
+	return schemaRepository.db.WithTransaction(ctx, dbsql.LevelDefault, func(ctx context.Context, tx *sql.TransactionManager) error {
+		stored, err := schemaRepository.getStoredSchemas(ctx)
+		if err != nil {
+			return err
+		}
+		toDelete := make(map[string]bool, len(schemaNames))
+		for _, name := range schemaNames {
+			schema, exists := stored[name]
+			if !exists {
+				return fmt.Errorf("%w: %s", ErrSchemaNotFound, name)
+			}
+			if schema.libraryVersion > 0 {
+				return fmt.Errorf("schema %s is part of the library and cannot be deleted", name)
+			}
+			toDelete[name] = true
+		}
+		for _, name := range sortedSchemaNames(stored) {
+			if toDelete[name] {
+				continue
+			}
+			definition := stored[name].definition
+			for _, superclass := range definition.Superclasses {
+				if toDelete[superclass.Name] {
+					return fmt.Errorf("cannot delete schema %s, which schema %s inherits from", superclass.Name, name)
+				}
+			}
+			for _, dependencies := range definition.DependsOn {
+				for dependencyName, className := range dependencies {
+					if toDelete[className] {
+						return fmt.Errorf("cannot delete schema %s, which dependency %s of schema %s refers to",
+							className, dependencyName, name)
+					}
+				}
+			}
+		}
+		for _, name := range schemaNames {
+			stmt, err := (&sql.DeleteStmt{
+				Dialect:        schemaRepository.db.Dialect,
+				Table:          SCHEMA_TABLE_NAME,
+				QueryCondition: sql.SQLEqual("", "schema_name", name),
+			}).GenerateStmt()
+			if err != nil {
+				return err
+			}
+			if _, err := schemaRepository.db.ExecuteSQL(stmt, ctx); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Add fields to the schema, or replace the fields with the same name, keyed by the name of
+// the field. The hierarchy of every schema is checked again, since the change may
+// introduce conflicts in the subclasses of the schema.
+func (schemaRepository *SQLSchemaRepository) UpdateSchema(schemaName string, fieldsToUpdateInto map[string]*NDIField, ctx context.Context) error {
+	return schemaRepository.db.WithTransaction(ctx, dbsql.LevelDefault, func(ctx context.Context, tx *sql.TransactionManager) error {
+		stored, err := schemaRepository.getStoredSchemas(ctx)
+		if err != nil {
+			return err
+		}
+		schema, exists := stored[schemaName]
+		if !exists {
+			return fmt.Errorf("%w: %s", ErrSchemaNotFound, schemaName)
+		}
+		names := make([]string, 0, len(fieldsToUpdateInto))
+		for name := range fieldsToUpdateInto {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		fields := make([]*NDIFieldDefinition, len(names))
+		for i, name := range names {
+			field := *fieldsToUpdateInto[name]
+			field.FieldName = name
+			definitions, err := fieldDefinitions([]*NDIField{&field}, "")
+			if err != nil {
+				return fmt.Errorf("schema %s: %w", schemaName, err)
+			}
+			fields[i] = definitions[0]
+		}
+		if schema.libraryVersion > 0 {
+			schema.extension = mergeFieldDefinitions(schema.extension, fields)
+		} else {
+			schema.definition.Fields = mergeFieldDefinitions(schema.definition.Fields, fields)
+		}
+		if _, err := buildStoredSchemas(stored); err != nil {
+			return err
+		}
+		return schemaRepository.storeSchema(schema, true, ctx)
+	})
+}
+
+// Store the schemas of the library that are missing, and replace the ones coming from an
+// older version of the library, keeping their extensions. Return the names of the schemas
+// installed or upgraded. Fail if a schema that is not part of the library has the name of
+// one that is, or if an extension conflicts with the new version of the library.
+func (schemaRepository *SQLSchemaRepository) UpgradeLibrary(ctx context.Context) ([]string, error) {
+	definitions, err := LibraryDefinitions()
+	if err != nil {
+		return nil, err
+	}
+	var upgraded []string
+	err = schemaRepository.db.WithTransaction(ctx, dbsql.LevelDefault, func(ctx context.Context, tx *sql.TransactionManager) error {
+		upgraded = make([]string, 0)
+		stored, err := schemaRepository.getStoredSchemas(ctx)
+		if err != nil {
+			return err
+		}
+		changed := make([]*storedSchema, 0)
+		replace := make(map[*storedSchema]bool)
+		for _, definition := range definitions {
+			schema, exists := stored[definition.ClassName]
+			switch {
+			case !exists:
+				schema = &storedSchema{definition: definition, libraryVersion: LibraryVersion}
+				stored[definition.ClassName] = schema
+			case schema.libraryVersion == 0:
+				return fmt.Errorf("schema %s conflicts with the schema of the library with the same name", definition.ClassName)
+			case schema.libraryVersion < LibraryVersion:
+				schema.definition = definition
+				schema.libraryVersion = LibraryVersion
+				replace[schema] = true
+			default:
+				continue
+			}
+			changed = append(changed, schema)
+			upgraded = append(upgraded, definition.ClassName)
+		}
+		if _, err := buildStoredSchemas(stored); err != nil {
+			return err
+		}
+		for _, schema := range changed {
+			if err := schemaRepository.storeSchema(schema, replace[schema], ctx); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return upgraded, nil
+}
+
+// Create the table storing the schemas, unless it exists, and store or upgrade the schemas
+// of the library. Running it again on an existing database is safe.
 func (schemaRepository *SQLSchemaRepository) Setup(ctx context.Context) error {
 	stmt := sql.CreateTableStmt{
-		Dialect: *schemaRepository.db.Dialect,
+		Dialect: schemaRepository.db.Dialect,
 		TableSchema: sql.TableSchema{
 			TableName: SCHEMA_TABLE_NAME,
 			Columns: map[string]sql.SqlDataType{
-				"table_name":        &sql.SqlText{},
-				"schema_name":       &sql.SqlText{},
-				"schema_definition": &sql.SqlText{},
+				"table_name":           &sql.SqlText{Len: 255},
+				"schema_name":          &sql.SqlText{Len: 255, NotNull: true},
+				"schema_definition":    &sql.SqlText{},
+				"library_version":      &sql.SqlInteger{NotNull: true},
+				"extension_definition": &sql.SqlText{},
 			},
+			PrimaryKey: []string{"schema_name"},
 		},
+		IfNotExists: true,
 	}
 	sqlStmt, err := stmt.GenerateStmt()
 	if err != nil {
 		return err
 	}
-	_, err = schemaRepository.db.ExecuteSQL(sqlStmt, ctx)
+	if _, err = schemaRepository.db.ExecuteSQL(sqlStmt, ctx); err != nil {
+		return err
+	}
+	_, err = schemaRepository.UpgradeLibrary(ctx)
 	return err
 }
+
+// Get the stored schemas, keyed by their name
+func (schemaRepository *SQLSchemaRepository) getStoredSchemas(ctx context.Context) (map[string]*storedSchema, error) {
+	stmt, err := (&sql.SelectStmt{
+		Dialect:        schemaRepository.db.Dialect,
+		ColumnsToQuery: []string{"schema_name", "schema_definition", "library_version", "extension_definition"},
+		Tables:         []string{SCHEMA_TABLE_NAME},
+	}).GenerateStmt()
+	if err != nil {
+		return nil, err
+	}
+	rows, err := sql.QueryStructs[schemaRow](schemaRepository.db, stmt, ctx)
+	if err != nil {
+		return nil, err
+	}
+	res := make(map[string]*storedSchema, len(rows))
+	for _, row := range rows {
+		definition, err := ParseSchemaDefinition([]byte(row.Definition))
+		if err != nil {
+			return nil, fmt.Errorf("schema %s: %w", row.SchemaName, err)
+		}
+		schema := &storedSchema{definition: definition, libraryVersion: row.LibraryVersion}
+		if row.Extension != "" {
+			if err := json.Unmarshal([]byte(row.Extension), &schema.extension); err != nil {
+				return nil, fmt.Errorf("extension of schema %s: %w", row.SchemaName, err)
+			}
+		}
+		res[row.SchemaName] = schema
+	}
+	return res, nil
+}
+
+// Insert the schema, or replace the stored one if replace is set
+func (schemaRepository *SQLSchemaRepository) storeSchema(schema *storedSchema, replace bool, ctx context.Context) error {
+	definition, err := json.Marshal(schema.definition)
+	if err != nil {
+		return err
+	}
+	extension := ""
+	if len(schema.extension) > 0 {
+		encoded, err := json.Marshal(schema.extension)
+		if err != nil {
+			return err
+		}
+		extension = string(encoded)
+	}
+	name := schema.definition.ClassName
+	insert := &sql.InsertStmt{
+		Dialect: schemaRepository.db.Dialect,
+		Table:   SCHEMA_TABLE_NAME,
+		Columns: []string{"table_name", "schema_name", "schema_definition", "library_version", "extension_definition"},
+		Values: []string{TableNameForSchema(name), name, string(definition),
+			strconv.Itoa(schema.libraryVersion), extension},
+	}
+	if replace {
+		insert.ConflictColumns = []string{"schema_name"}
+	}
+	stmt, err := insert.GenerateStmt()
+	if err != nil {
+		return err
+	}
+	_, err = schemaRepository.db.ExecuteSQL(stmt, ctx)
+	return err
+}
+
+// Get the definition of the schema with its extension applied
+func (schema *storedSchema) effectiveDefinition() *NDISchemaDefinition {
+	if len(schema.extension) == 0 {
+		return schema.definition
+	}
+	definition := *schema.definition
+	definition.Fields = mergeFieldDefinitions(schema.definition.Fields, schema.extension)
+	return &definition
+}
+
+// Build the schemas from their definitions, and check the hierarchy of each of them
+func buildStoredSchemas(stored map[string]*storedSchema) (map[string]*NDISchema, error) {
+	definitions := make([]*NDISchemaDefinition, 0, len(stored))
+	for _, name := range sortedSchemaNames(stored) {
+		definitions = append(definitions, stored[name].effectiveDefinition())
+	}
+	schemas, err := BuildSchemas(definitions, nil)
+	if err != nil {
+		return nil, err
+	}
+	for _, definition := range definitions {
+		if err := schemas[definition.ClassName].CheckHierarchy(); err != nil {
+			return nil, err
+		}
+	}
+	return schemas, nil
+}
+
+// Replace the fields with the same name as the updates, and append the other updates
+func mergeFieldDefinitions(fields []*NDIFieldDefinition, updates []*NDIFieldDefinition) []*NDIFieldDefinition {
+	res := append([]*NDIFieldDefinition{}, fields...)
+	for _, update := range updates {
+		replaced := false
+		for i, field := range res {
+			if field.fieldName() == update.fieldName() {
+				res[i] = update
+				replaced = true
+				break
+			}
+		}
+		if !replaced {
+			res = append(res, update)
+		}
+	}
+	return res
+}
+
+// Get the schema names in alphabetical order, so that errors are reported deterministically
+func sortedSchemaNames(stored map[string]*storedSchema) []string {
+	names := make([]string, 0, len(stored))
+	for name := range stored {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Get the name of the table storing the querable fields of the documents of a schema
+func TableNameForSchema(schemaName string) string {
+	return "ndi_" + strings.Map(func(r rune) rune {
+		if (r < 'a' || r > 'z') && (r < 'A' || r > 'Z') && (r < '0' || r > '9') {
+			return '_'
+		}
+		return r
+	}, schemaName)
+}
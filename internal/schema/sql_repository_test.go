@@ -0,0 +1,96 @@
+package schema
+
+import (
+	"context"
+	dbsql "database/sql"
+	"encoding/json"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	_ "github.com/mattn/go-sqlite3"
+	datatypes "github.com/zhaoy17/ndid/internal/datatypes"
+	sql "github.com/zhaoy17/ndid/internal/sql"
+)
+
+// Setting up an existing database must leave it alone and only upgrade the library
+func TestSetupOnExistingDatabase(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "schemas.db")
+	ctx := context.Background()
+	for run := 0; run < 2; run++ {
+		conn, err := dbsql.Open("sqlite3", file+"?_busy_timeout=5000")
+		if err != nil {
+			t.Fatal(err)
+		}
+		repo := NewSQLSchemaRepository(sql.NewSqlDatabase(sql.SqlLite, conn, sql.DefaultStmtCacheSize))
+		if err := repo.Setup(ctx); err != nil {
+			t.Fatalf("setup %d failed: %v", run+1, err)
+		}
+		upgraded, err := repo.UpgradeLibrary(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(upgraded) != 0 {
+			t.Fatalf("upgraded %v on a database already holding the library", upgraded)
+		}
+		definitions, err := LibraryDefinitions()
+		if err != nil {
+			t.Fatal(err)
+		}
+		schemas, err := repo.GetAllSchemas(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(schemas) != len(definitions) {
+			t.Fatalf("got %d schemas, want the %d of the library", len(schemas), len(definitions))
+		}
+		conn.Close()
+	}
+}
+
+// Defaults are checked against the data type of their field when schemas are loaded and
+// when they are inserted
+func TestInvalidDefaultValue(t *testing.T) {
+	definition, err := ParseSchemaDefinition([]byte(`{
+		"classname": "probe",
+		"field": [{"name": "channels", "type": "integer", "parameters": "1,64", "default_value": 0}]
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := BuildSchemas([]*NDISchemaDefinition{definition}, nil); err == nil || !strings.Contains(err.Error(), "invalid default value 0") {
+		t.Fatalf("got error %v, want the default to be rejected", err)
+	}
+	definition.Fields[0].DefaultValue = json.Number("16")
+	if _, err := BuildSchemas([]*NDISchemaDefinition{definition}, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	conn, err := dbsql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetMaxOpenConns(1)
+	repo := NewSQLSchemaRepository(sql.NewSqlDatabase(sql.SqlLite, conn, sql.DefaultStmtCacheSize))
+	ctx := context.Background()
+	if err := repo.Setup(ctx); err != nil {
+		t.Fatal(err)
+	}
+	dataType, err := datatypes.NewDataType("enum", "patch,sharp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	probe := &NDISchema{
+		SchemaName: "probe",
+		SchemaFields: []*NDIField{
+			{FieldName: "kind", DataType: dataType, TypeName: "enum", Parameters: "patch,sharp", DefaultValue: "n-trode"},
+		},
+	}
+	if err := repo.InsertSchemas([]*NDISchema{probe}, ctx); err == nil || !strings.Contains(err.Error(), "invalid default value n-trode") {
+		t.Fatalf("got error %v, want the default to be rejected", err)
+	}
+	if _, err := repo.GetSchema("probe", ctx); err == nil {
+		t.Fatalf("the schema was stored")
+	}
+}
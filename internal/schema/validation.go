@@ -0,0 +1,69 @@
+package schema
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// Rules a document may break, reported in ValidationError.Rule
+const (
+	// The value is rejected by the data type of the field
+	RuleDataType = "data_type"
+
+	// A structure does not hold an object or an array of objects
+	RuleStructure = "structure"
+
+	// A required field is missing
+	RuleRequired = "required"
+
+	// The field is not defined in the schema, which does not allow additional properties
+	RuleUnknownField = "unknown_field"
+)
+
+// Failure of a single field of a document
+type ValidationError struct {
+	// Path of the field, e.g. "epoch.t0.value" or "channels[2].name"
+	FieldPath string `json:"field"`
+
+	// The offending value as found in the content
+	Value interface{} `json:"value"`
+
+	Rule    string `json:"rule"`
+	Message string `json:"message"`
+}
+
+func (err *ValidationError) Error() string {
+	return fmt.Sprintf("%s: %s", err.FieldPath, err.Message)
+}
+
+// All the failures of a document, in the order of the fields in the schema. Serialized to
+// JSON as an object holding the list of failures, so that it can be sent as is in the
+// response to a rejected request.
+type ValidationErrors []*ValidationError
+
+func (errs ValidationErrors) Error() string {
+	messages := make([]string, len(errs))
+	for i, err := range errs {
+		messages[i] = err.Error()
+	}
+	return fmt.Sprintf("document has %d invalid field(s): %s", len(errs), strings.Join(messages, "; "))
+}
+
+func (errs ValidationErrors) MarshalJSON() ([]byte, error) {
+	failures := []*ValidationError(errs)
+	if failures == nil {
+		failures = []*ValidationError{}
+	}
+	return json.Marshal(struct {
+		Message string             `json:"message"`
+		Errors  []*ValidationError `json:"errors"`
+	}{
+		Message: "document failed validation",
+		Errors:  failures,
+	})
+}
+
+func (errs *ValidationErrors) add(path string, val interface{}, rule string, message string) {
+	*errs = append(*errs, &ValidationError{FieldPath: path, Value: val, Rule: rule, Message: message})
+}
@@ -0,0 +1,34 @@
+package sql
+
+import "fmt"
+
+// Generator for the SQL ALTER TABLE statement adding a column to an existing table
+type AddColumnStmt struct {
+	Dialect  Dialect
+	Table    string
+	Column   string
+	DataType SqlDataType
+}
+
+// Generate the ALTER TABLE statement based on the dialect provided. The column should be
+// nullable unless the table is empty, since existing rows have no value for it.
+func (stmt *AddColumnStmt) GenerateStmt() (res *SqlStmt, err error) {
+	if !validateToken(stmt.Table) {
+		return &SqlStmt{}, fmt.Errorf("table validation failed")
+	}
+	if !validateToken(stmt.Column) {
+		return &SqlStmt{}, fmt.Errorf("column validation failed")
+	}
+	if stmt.DataType == nil {
+		return &SqlStmt{}, fmt.Errorf("column %s has no data type", stmt.Column)
+	}
+	dataType, err := stmt.DataType.ToSqlDataType(stmt.Dialect)
+	if err != nil {
+		return &SqlStmt{}, err
+	}
+	return &SqlStmt{
+		Stmt: fmt.Sprintf("ALTER TABLE %s ADD %s %s;",
+			stmt.Dialect.QuoteIdentifier(stmt.Table), stmt.Dialect.QuoteIdentifier(stmt.Column), dataType),
+		Params: []string{},
+	}, nil
+}
@@ -7,7 +7,7 @@ import (
 
 type SqlDataType interface {
 	//Convert to the correct data types based on the dialect
-	ToSqlDataType(dialect SqlDialect) (string, error)
+	ToSqlDataType(dialect Dialect) (string, error)
 }
 
 // Represent String SQL data type
@@ -17,22 +17,8 @@ type SqlText struct {
 	NotNull bool
 }
 
-func (dataType *SqlText) ToSqlDataType(dialect SqlDialect) (string, error) {
-	var sb strings.Builder
-	switch dialect {
-	case Psql, MySql, SqlServer:
-		if dataType.Len <= 0 {
-			sb.WriteString(fmt.Sprintf("VARCHAR(%d)", dataType.Len))
-		} else {
-			sb.WriteString("TEXT")
-		}
-	case SqlLite:
-		sb.WriteString("TEXT")
-	default:
-		return "", fmt.Errorf("dialect not supported")
-	}
-	appendNotNull(&sb, dataType.NotNull)
-	return sb.String(), nil
+func (dataType *SqlText) ToSqlDataType(dialect Dialect) (string, error) {
+	return toSqlDataType(dialect, dataType, dataType.NotNull)
 }
 
 // Represent SQL Integer Type
@@ -40,17 +26,8 @@ type SqlInteger struct {
 	NotNull bool
 }
 
-func (dataType *SqlInteger) ToSqlDataType(dialect SqlDialect) (string, error) {
-	var sb strings.Builder
-	switch dialect {
-	case SqlLite, SqlServer, MySql, Psql:
-		sb.WriteString("INTEGER")
-	default:
-		return "", fmt.Errorf("dialect not supported")
-	}
-
-	appendNotNull(&sb, dataType.NotNull)
-	return sb.String(), nil
+func (dataType *SqlInteger) ToSqlDataType(dialect Dialect) (string, error) {
+	return toSqlDataType(dialect, dataType, dataType.NotNull)
 }
 
 // Represent SQL Float Type
@@ -58,17 +35,8 @@ type SqlFloat struct {
 	NotNull bool
 }
 
-func (dataType *SqlFloat) ToSqlDataType(dialect SqlDialect) (string, error) {
-	var sb strings.Builder
-	switch dialect {
-	case SqlLite, SqlServer, MySql, Psql:
-		sb.WriteString("FLOAT")
-	default:
-		return "", fmt.Errorf("dialect not supported")
-	}
-
-	appendNotNull(&sb, dataType.NotNull)
-	return sb.String(), nil
+func (dataType *SqlFloat) ToSqlDataType(dialect Dialect) (string, error) {
+	return toSqlDataType(dialect, dataType, dataType.NotNull)
 }
 
 // Represent SQL Datetime Type
@@ -76,19 +44,83 @@ type SqlDateTime struct {
 	NotNull bool
 }
 
-func (dataType *SqlFloat) ToDateTimeType(dialect SqlDialect) (string, error) {
-	var sb strings.Builder
-	switch dialect {
-	case SqlLite:
-		sb.WriteString("TEXT")
-	case Psql:
-		sb.WriteString("TIMESTAMP")
-	case SqlServer, MySql:
-		sb.WriteString("DATETIME")
-	default:
-		return "", fmt.Errorf("dialect not supported")
+func (dataType *SqlDateTime) ToSqlDataType(dialect Dialect) (string, error) {
+	return toSqlDataType(dialect, dataType, dataType.NotNull)
+}
+
+// Represent SQL Boolean Type
+type SqlBoolean struct {
+	NotNull bool
+}
+
+func (dataType *SqlBoolean) ToSqlDataType(dialect Dialect) (string, error) {
+	return toSqlDataType(dialect, dataType, dataType.NotNull)
+}
+
+// Represent SQL 64-bit Integer Type
+type SqlBigInt struct {
+	NotNull bool
+}
+
+func (dataType *SqlBigInt) ToSqlDataType(dialect Dialect) (string, error) {
+	return toSqlDataType(dialect, dataType, dataType.NotNull)
+}
+
+// Represent SQL fixed-point Decimal Type
+// Set Precision to 0 to use the default precision and scale of the database
+type SqlDecimal struct {
+	// total number of significant digits
+	Precision int
+
+	// number of digits after the decimal point
+	Scale int
+
+	NotNull bool
+}
+
+func (dataType *SqlDecimal) ToSqlDataType(dialect Dialect) (string, error) {
+	if dataType.Precision < 0 || dataType.Scale < 0 || dataType.Scale > dataType.Precision {
+		return "", fmt.Errorf("invalid decimal precision %d and scale %d", dataType.Precision, dataType.Scale)
+	}
+	return toSqlDataType(dialect, dataType, dataType.NotNull)
+}
+
+// Represent SQL binary data Type
+type SqlBlob struct {
+	NotNull bool
+}
+
+func (dataType *SqlBlob) ToSqlDataType(dialect Dialect) (string, error) {
+	return toSqlDataType(dialect, dataType, dataType.NotNull)
+}
+
+// Represent SQL JSON Type. Stored as text by dialects without a native JSON type.
+type SqlJSON struct {
+	NotNull bool
+}
+
+func (dataType *SqlJSON) ToSqlDataType(dialect Dialect) (string, error) {
+	return toSqlDataType(dialect, dataType, dataType.NotNull)
+}
+
+// Represent SQL UUID Type. Stored as text by dialects without a native UUID type.
+type SqlUUID struct {
+	NotNull bool
+}
+
+func (dataType *SqlUUID) ToSqlDataType(dialect Dialect) (string, error) {
+	return toSqlDataType(dialect, dataType, dataType.NotNull)
+}
+
+// Get the native name of the data type from the dialect, and append NOT NULL if needed
+func toSqlDataType(dialect Dialect, dataType SqlDataType, notNull bool) (string, error) {
+	typeName, err := dialect.TypeName(dataType)
+	if err != nil {
+		return "", err
 	}
-	appendNotNull(&sb, dataType.NotNull)
+	var sb strings.Builder
+	sb.WriteString(typeName)
+	appendNotNull(&sb, notNull)
 	return sb.String(), nil
 }
 
@@ -8,29 +8,35 @@ import (
 type TableSchema struct {
 	TableName string
 	Columns   map[string]SqlDataType
+
+	// Columns making up the primary key of the table, ignored if empty
+	PrimaryKey []string
 }
 
 // Generate parameterized CREATE TABLE statement based on the dialect provided, and the data type of each specified columns
 type CreateTableStmt struct {
-	Dialect     SqlDialect
+	Dialect     Dialect
 	TableSchema TableSchema
+
+	// Weather or not to leave an existing table of the same name untouched instead of
+	// failing, so that the statement can be run on every startup
+	IfNotExists bool
 }
 
 func (stmt *CreateTableStmt) GenerateStmt() (res *SqlStmt, err error) {
 	var sb strings.Builder
-	sb.WriteString("CREATE TABLE ")
 	if !validateToken(stmt.TableSchema.TableName) {
 		return &SqlStmt{}, fmt.Errorf("column validation failed")
 	}
-	sb.WriteString(stmt.TableSchema.TableName)
-	sb.WriteString(" (\n\t")
+	table := stmt.Dialect.QuoteIdentifier(stmt.TableSchema.TableName)
+	sb.WriteString("(\n\t")
 
 	index := 0
 	for col, dataType := range stmt.TableSchema.Columns {
 		if !validateToken(col) {
 			return &SqlStmt{}, fmt.Errorf("column validation failed")
 		}
-		sb.WriteString(col)
+		sb.WriteString(stmt.Dialect.QuoteIdentifier(col))
 		sb.WriteString(" ")
 
 		sqlDataType, err := dataType.ToSqlDataType(stmt.Dialect)
@@ -43,9 +49,25 @@ func (stmt *CreateTableStmt) GenerateStmt() (res *SqlStmt, err error) {
 		}
 		index += 1
 	}
-	sb.WriteString("\n);")
+	if len(stmt.TableSchema.PrimaryKey) > 0 {
+		primaryKey := make([]string, len(stmt.TableSchema.PrimaryKey))
+		for i, col := range stmt.TableSchema.PrimaryKey {
+			if _, exists := stmt.TableSchema.Columns[col]; !exists {
+				return &SqlStmt{}, fmt.Errorf("primary key column %s is not a column of the table", col)
+			}
+			primaryKey[i] = stmt.Dialect.QuoteIdentifier(col)
+		}
+		sb.WriteString(",\n\tPRIMARY KEY (")
+		sb.WriteString(strings.Join(primaryKey, ", "))
+		sb.WriteString(")")
+	}
+	sb.WriteString("\n)")
+	createStmt := fmt.Sprintf("CREATE TABLE %s %s;", table, sb.String())
+	if stmt.IfNotExists {
+		createStmt = createTableIfNotExists(stmt.Dialect, table, sb.String())
+	}
 	return &SqlStmt{
-		Stmt:   sb.String(),
+		Stmt:   createStmt,
 		Params: []string{},
 	}, nil
 }
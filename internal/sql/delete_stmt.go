@@ -0,0 +1,40 @@
+package sql
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Generator for SQL DELETE Statement
+type DeleteStmt struct {
+	Dialect        Dialect
+	Table          string
+	QueryCondition SqlQueryFunction
+}
+
+// Generate parameterized DELETE SQL statement based on the dialect provided, the table to
+// delete from, and the condition the rows to delete must meet. The condition is required,
+// so that a table cannot be emptied by mistake.
+func (stmt *DeleteStmt) GenerateStmt() (res *SqlStmt, err error) {
+	if !validateToken(stmt.Table) {
+		return &SqlStmt{}, fmt.Errorf("table validation failed")
+	}
+	if stmt.QueryCondition == nil {
+		return &SqlStmt{}, errors.New("must specify the rows to delete")
+	}
+	whereClause, params, err := stmt.QueryCondition.ToSQLParameterizedQuery(stmt.Dialect, 1)
+	if err != nil {
+		return &SqlStmt{}, err
+	}
+	var sb strings.Builder
+	sb.WriteString("DELETE FROM ")
+	sb.WriteString(stmt.Dialect.QuoteIdentifier(stmt.Table))
+	sb.WriteString("\nWHERE ")
+	sb.WriteString(whereClause)
+	sb.WriteString(";")
+	return &SqlStmt{
+		Stmt:   sb.String(),
+		Params: params,
+	}, nil
+}
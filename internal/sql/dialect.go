@@ -0,0 +1,208 @@
+package sql
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Describe the syntax of a SQL database. The SQL generators and SqlDatabase rely solely on
+// this interface, so supporting another database only requires implementing it (usually
+// by embedding the built-in dialect it is compatible with) and registering it with
+// RegisterDialect.
+type Dialect interface {
+	// Unique name of the dialect, used to look it up in the registry
+	Name() string
+
+	// Get the placeholder of the parameter at the given position (starting from 1) in a
+	// parameterized SQL statement
+	Placeholder(index int) (string, error)
+
+	// Quote a table or column name so that it can contain any character
+	QuoteIdentifier(identifier string) string
+
+	// Get the native name of a column data type, without the NOT NULL constraint
+	TypeName(dataType SqlDataType) (string, error)
+
+	// Generate the clause restricting the rows returned by a SELECT statement. limit is
+	// ignored if 0 or negative. ordered tells whether the statement has an ORDER BY clause,
+	// which some dialects require for pagination.
+	Pagination(limit int, offset int, ordered bool) string
+
+	// Generate a statement inserting a row into table, or updating the columns of the row
+	// that conflicts with it on conflictColumns. placeholders holds the placeholder of the
+	// value of each column. Table and column names are already quoted.
+	Upsert(table string, columns []string, conflictColumns []string, placeholders []string) (string, error)
+
+	// Generate a condition matching column (already quoted) against the regex pattern bound
+	// to placeholder
+	RegexMatch(column string, placeholder string) (string, error)
+}
+
+// The interfaces below are optional capabilities of a dialect, checked with a type assertion
+// so that supporting a new feature does not break the dialects written before it. Dialects
+// that do not implement them get standard SQL, or an error if there is none.
+
+// Implemented by the dialects whose savepoint syntax differs from the standard SAVEPOINT,
+// ROLLBACK TO SAVEPOINT and RELEASE SAVEPOINT
+type SavepointDialect interface {
+	// Get the statements creating, rolling back to and releasing the named savepoint.
+	// release may be empty if the database has no such statement.
+	Savepoint(name string) (create string, rollback string, release string)
+}
+
+// Implemented by the dialects whose syntax differs from the standard CREATE TABLE IF NOT
+// EXISTS
+type CreateTableIfNotExistsDialect interface {
+	// Generate a statement creating table (already quoted) with the columns and constraints
+	// given by definition, e.g. "(id TEXT)", unless a table of the same name already exists
+	CreateTableIfNotExists(table string, definition string) string
+}
+
+// Implemented by the dialects able to look into JSON arrays, which SQLHasMember requires
+type HasMemberDialect interface {
+	// Generate a condition matching the rows whose column holds a JSON array having member
+	// (a JSON scalar) as element, looking into nested arrays up to depth levels. Return the
+	// condition and the value to bind to placeholder.
+	HasMember(column string, placeholder string, member string, depth int) (string, string, error)
+}
+
+// Implemented by the dialects supporting common table expressions that refer to themselves,
+// with the recursive part joined to the initial one by UNION. UNION discards the rows
+// already produced, so that walking a graph visits every edge once rather than every path.
+type RecursiveWithDialect interface {
+	// Get the keyword introducing a recursive common table expression
+	RecursiveWith() string
+}
+
+// Get the keyword introducing a recursive common table expression, or false if the dialect
+// does not support them
+func RecursiveWith(dialect Dialect) (string, bool) {
+	if recursive, ok := dialect.(RecursiveWithDialect); ok {
+		return recursive.RecursiveWith(), true
+	}
+	return "", false
+}
+
+// Get the statements creating, rolling back to and releasing the named savepoint
+func savepointStmts(dialect Dialect, name string) (string, string, string) {
+	if savepoint, ok := dialect.(SavepointDialect); ok {
+		return savepoint.Savepoint(name)
+	}
+	return "SAVEPOINT " + name, "ROLLBACK TO SAVEPOINT " + name, "RELEASE SAVEPOINT " + name
+}
+
+// Built-in dialects. SQL dialects currently supported are PostgreSQL, MySQL, SQLite
+// and MS SQL Server
+var (
+	Psql      Dialect = PostgresDialect{}
+	MySql     Dialect = MySqlDialect{}
+	SqlLite   Dialect = SqliteDialect{}
+	SqlServer Dialect = SqlServerDialect{}
+)
+
+var dialectRegistry = struct {
+	sync.RWMutex
+	dialects map[string]Dialect
+}{
+	dialects: map[string]Dialect{
+		Psql.Name():      Psql,
+		MySql.Name():     MySql,
+		SqlLite.Name():   SqlLite,
+		SqlServer.Name(): SqlServer,
+	},
+}
+
+// Make a dialect available by its name, e.g. to be selected from the configuration.
+// Return an error if another dialect has been registered with the same name.
+func RegisterDialect(dialect Dialect) error {
+	dialectRegistry.Lock()
+	defer dialectRegistry.Unlock()
+	if _, exists := dialectRegistry.dialects[dialect.Name()]; exists {
+		return fmt.Errorf("dialect %s has already been registered", dialect.Name())
+	}
+	dialectRegistry.dialects[dialect.Name()] = dialect
+	return nil
+}
+
+// Get the dialect registered with the given name
+func GetDialect(name string) (Dialect, error) {
+	dialectRegistry.RLock()
+	defer dialectRegistry.RUnlock()
+	dialect, ok := dialectRegistry.dialects[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown dialect %s", name)
+	}
+	return dialect, nil
+}
+
+// Get the names of all the registered dialects, in alphabetical order
+func RegisteredDialects() []string {
+	dialectRegistry.RLock()
+	defer dialectRegistry.RUnlock()
+	names := make([]string, 0, len(dialectRegistry.dialects))
+	for name := range dialectRegistry.dialects {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Generate an upsert using INSERT ... ON CONFLICT, shared by PostgreSQL and SQLite
+func upsertOnConflict(table string, columns []string, conflictColumns []string, placeholders []string) string {
+	var sb strings.Builder
+	sb.WriteString(fmt.Sprintf("INSERT INTO %s (%s)\nVALUES (%s)\nON CONFLICT (%s) ",
+		table, strings.Join(columns, ", "), strings.Join(placeholders, ", "), strings.Join(conflictColumns, ", ")))
+	updates := make([]string, 0, len(columns))
+	for _, col := range nonConflictColumns(columns, conflictColumns) {
+		updates = append(updates, fmt.Sprintf("%s = EXCLUDED.%s", col, col))
+	}
+	if len(updates) == 0 {
+		sb.WriteString("DO NOTHING;")
+	} else {
+		sb.WriteString("DO UPDATE SET ")
+		sb.WriteString(strings.Join(updates, ", "))
+		sb.WriteString(";")
+	}
+	return sb.String()
+}
+
+// Generate a statement creating table unless it already exists
+func createTableIfNotExists(dialect Dialect, table string, definition string) string {
+	if create, ok := dialect.(CreateTableIfNotExistsDialect); ok {
+		return create.CreateTableIfNotExists(table, definition)
+	}
+	return fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s %s;", table, definition)
+}
+
+// Get the columns that are not part of the conflict target of an upsert
+func nonConflictColumns(columns []string, conflictColumns []string) []string {
+	res := make([]string, 0, len(columns))
+	for _, col := range columns {
+		isConflictColumn := false
+		for _, conflictCol := range conflictColumns {
+			if col == conflictCol {
+				isConflictColumn = true
+				break
+			}
+		}
+		if !isConflictColumn {
+			res = append(res, col)
+		}
+	}
+	return res
+}
+
+// Get the name of a decimal type such as NUMERIC(10, 2), omitting the precision and scale
+// if they are not specified
+func decimalTypeName(name string, dataType *SqlDecimal) string {
+	if dataType.Precision == 0 {
+		return name
+	}
+	return fmt.Sprintf("%s(%d, %d)", name, dataType.Precision, dataType.Scale)
+}
+
+func errDataTypeNotSupported(dialect Dialect, dataType SqlDataType) error {
+	return fmt.Errorf("data type %T not supported by dialect %s", dataType, dialect.Name())
+}
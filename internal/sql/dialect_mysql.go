@@ -0,0 +1,91 @@
+package sql
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Dialect of MySQL
+type MySqlDialect struct{}
+
+func (dialect MySqlDialect) Name() string {
+	return "mysql"
+}
+
+// MySQL uses ? as placeholders
+func (dialect MySqlDialect) Placeholder(index int) (string, error) {
+	return "?", nil
+}
+
+func (dialect MySqlDialect) QuoteIdentifier(identifier string) string {
+	return "`" + strings.ReplaceAll(identifier, "`", "``") + "`"
+}
+
+func (dialect MySqlDialect) TypeName(dataType SqlDataType) (string, error) {
+	switch t := dataType.(type) {
+	case *SqlText:
+		if t.Len <= 0 {
+			return "LONGTEXT", nil
+		}
+		return fmt.Sprintf("VARCHAR(%d)", t.Len), nil
+	case *SqlInteger:
+		return "INTEGER", nil
+	case *SqlBigInt:
+		return "BIGINT", nil
+	case *SqlFloat:
+		// FLOAT is single precision in MySQL
+		return "DOUBLE", nil
+	case *SqlDecimal:
+		return decimalTypeName("DECIMAL", t), nil
+	case *SqlDateTime:
+		return "DATETIME(6)", nil
+	case *SqlBoolean:
+		return "BOOLEAN", nil
+	case *SqlBlob:
+		return "LONGBLOB", nil
+	case *SqlJSON:
+		return "JSON", nil
+	case *SqlUUID:
+		return "CHAR(36)", nil
+	default:
+		return "", errDataTypeNotSupported(dialect, dataType)
+	}
+}
+
+// MySQL has no syntax for an offset without a limit, so the largest possible limit is used
+func (dialect MySqlDialect) Pagination(limit int, offset int, ordered bool) string {
+	if limit > 0 {
+		return fmt.Sprintf("LIMIT %d OFFSET %d", limit, offset)
+	}
+	return fmt.Sprintf("LIMIT 18446744073709551615 OFFSET %d", offset)
+}
+
+// MySQL does not take a conflict target: the row is updated if the insert violates any
+// PRIMARY KEY or UNIQUE index.
+func (dialect MySqlDialect) Upsert(table string, columns []string, conflictColumns []string, placeholders []string) (string, error) {
+	updateColumns := nonConflictColumns(columns, conflictColumns)
+	if len(updateColumns) == 0 {
+		// no-op update so that the conflicting row is left untouched
+		updateColumns = conflictColumns[:1]
+	}
+	updates := make([]string, len(updateColumns))
+	for i, col := range updateColumns {
+		updates[i] = fmt.Sprintf("%s = VALUES(%s)", col, col)
+	}
+	return fmt.Sprintf("INSERT INTO %s (%s)\nVALUES (%s)\nON DUPLICATE KEY UPDATE %s;",
+		table, strings.Join(columns, ", "), strings.Join(placeholders, ", "), strings.Join(updates, ", ")), nil
+}
+
+func (dialect MySqlDialect) RegexMatch(column string, placeholder string) (string, error) {
+	return fmt.Sprintf("%s REGEXP %s", column, placeholder), nil
+}
+
+// JSON_CONTAINS looks into nested arrays on its own
+func (dialect MySqlDialect) HasMember(column string, placeholder string, member string, depth int) (string, string, error) {
+	return fmt.Sprintf("JSON_CONTAINS(%s, %s)", column, placeholder), member, nil
+}
+
+// Recursive common table expressions are only available from MySQL 8.0
+func (dialect MySqlDialect) RecursiveWith() string {
+	return "WITH RECURSIVE"
+}
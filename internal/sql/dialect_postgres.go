@@ -0,0 +1,85 @@
+package sql
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Dialect of PostgreSQL
+type PostgresDialect struct{}
+
+func (dialect PostgresDialect) Name() string {
+	return "postgres"
+}
+
+// PostgreSQL uses $1...$N as placeholders
+func (dialect PostgresDialect) Placeholder(index int) (string, error) {
+	if index <= 0 {
+		return "", errors.New("index has to be greater than 0")
+	}
+	return fmt.Sprintf("$%d", index), nil
+}
+
+func (dialect PostgresDialect) QuoteIdentifier(identifier string) string {
+	return `"` + strings.ReplaceAll(identifier, `"`, `""`) + `"`
+}
+
+func (dialect PostgresDialect) TypeName(dataType SqlDataType) (string, error) {
+	switch t := dataType.(type) {
+	case *SqlText:
+		if t.Len <= 0 {
+			return "TEXT", nil
+		}
+		return fmt.Sprintf("VARCHAR(%d)", t.Len), nil
+	case *SqlInteger:
+		return "INTEGER", nil
+	case *SqlBigInt:
+		return "BIGINT", nil
+	case *SqlFloat:
+		return "FLOAT", nil
+	case *SqlDecimal:
+		return decimalTypeName("NUMERIC", t), nil
+	case *SqlDateTime:
+		return "TIMESTAMP", nil
+	case *SqlBoolean:
+		return "BOOLEAN", nil
+	case *SqlBlob:
+		return "BYTEA", nil
+	case *SqlJSON:
+		return "JSONB", nil
+	case *SqlUUID:
+		return "UUID", nil
+	default:
+		return "", errDataTypeNotSupported(dialect, dataType)
+	}
+}
+
+func (dialect PostgresDialect) Pagination(limit int, offset int, ordered bool) string {
+	if limit > 0 {
+		return fmt.Sprintf("LIMIT %d OFFSET %d", limit, offset)
+	}
+	return fmt.Sprintf("OFFSET %d", offset)
+}
+
+func (dialect PostgresDialect) Upsert(table string, columns []string, conflictColumns []string, placeholders []string) (string, error) {
+	return upsertOnConflict(table, columns, conflictColumns, placeholders), nil
+}
+
+func (dialect PostgresDialect) RegexMatch(column string, placeholder string) (string, error) {
+	return fmt.Sprintf("%s ~ %s", column, placeholder), nil
+}
+
+// Containment with @> looks into nested arrays, provided the member is wrapped into as many
+// arrays as the column has levels
+func (dialect PostgresDialect) HasMember(column string, placeholder string, member string, depth int) (string, string, error) {
+	param := member
+	for i := 0; i < depth; i++ {
+		param = "[" + param + "]"
+	}
+	return fmt.Sprintf("%s @> %s", column, placeholder), param, nil
+}
+
+func (dialect PostgresDialect) RecursiveWith() string {
+	return "WITH RECURSIVE"
+}
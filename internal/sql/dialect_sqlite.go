@@ -0,0 +1,68 @@
+package sql
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Dialect of SQLite
+type SqliteDialect struct{}
+
+func (dialect SqliteDialect) Name() string {
+	return "sqlite"
+}
+
+// SQLite uses ? as placeholders
+func (dialect SqliteDialect) Placeholder(index int) (string, error) {
+	return "?", nil
+}
+
+func (dialect SqliteDialect) QuoteIdentifier(identifier string) string {
+	return `"` + strings.ReplaceAll(identifier, `"`, `""`) + `"`
+}
+
+func (dialect SqliteDialect) TypeName(dataType SqlDataType) (string, error) {
+	switch dataType.(type) {
+	case *SqlText, *SqlDateTime, *SqlJSON, *SqlUUID:
+		return "TEXT", nil
+	case *SqlInteger, *SqlBigInt, *SqlBoolean:
+		return "INTEGER", nil
+	case *SqlFloat:
+		return "FLOAT", nil
+	case *SqlDecimal:
+		return "NUMERIC", nil
+	case *SqlBlob:
+		return "BLOB", nil
+	default:
+		return "", errDataTypeNotSupported(dialect, dataType)
+	}
+}
+
+// A negative limit means no limit in SQLite
+func (dialect SqliteDialect) Pagination(limit int, offset int, ordered bool) string {
+	if limit > 0 {
+		return fmt.Sprintf("LIMIT %d OFFSET %d", limit, offset)
+	}
+	return fmt.Sprintf("LIMIT -1 OFFSET %d", offset)
+}
+
+func (dialect SqliteDialect) Upsert(table string, columns []string, conflictColumns []string, placeholders []string) (string, error) {
+	return upsertOnConflict(table, columns, conflictColumns, placeholders), nil
+}
+
+// SQLite only parses the REGEXP operator; the regexp() function it calls has to be
+// provided by the driver or an extension.
+func (dialect SqliteDialect) RegexMatch(column string, placeholder string) (string, error) {
+	return fmt.Sprintf("%s REGEXP %s", column, placeholder), nil
+}
+
+// json_tree walks every nested array, and json_quote gives back the JSON representation of
+// each scalar so that strings and numbers are compared the same way
+func (dialect SqliteDialect) HasMember(column string, placeholder string, member string, depth int) (string, string, error) {
+	return fmt.Sprintf("EXISTS (SELECT 1 FROM json_tree(%s) WHERE atom IS NOT NULL AND json_quote(atom) = %s)",
+		column, placeholder), member, nil
+}
+
+func (dialect SqliteDialect) RecursiveWith() string {
+	return "WITH RECURSIVE"
+}
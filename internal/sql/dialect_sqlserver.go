@@ -0,0 +1,142 @@
+package sql
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Dialect of MS SQL Server. It does not implement RecursiveWithDialect: MS SQL Server only
+// allows UNION ALL in recursive common table expressions, which lists every path through a
+// graph.
+type SqlServerDialect struct{}
+
+func (dialect SqlServerDialect) Name() string {
+	return "sqlserver"
+}
+
+// MS SQL Server uses @p1...@pN as placeholders
+func (dialect SqlServerDialect) Placeholder(index int) (string, error) {
+	if index <= 0 {
+		return "", errors.New("index has to be greater than 0")
+	}
+	return fmt.Sprintf("@p%d", index), nil
+}
+
+func (dialect SqlServerDialect) QuoteIdentifier(identifier string) string {
+	return "[" + strings.ReplaceAll(identifier, "]", "]]") + "]"
+}
+
+func (dialect SqlServerDialect) TypeName(dataType SqlDataType) (string, error) {
+	switch t := dataType.(type) {
+	case *SqlText:
+		// TEXT is deprecated, and NVARCHAR is limited to 4000 characters
+		if t.Len <= 0 || t.Len > 4000 {
+			return "NVARCHAR(MAX)", nil
+		}
+		return fmt.Sprintf("NVARCHAR(%d)", t.Len), nil
+	case *SqlInteger:
+		return "INTEGER", nil
+	case *SqlBigInt:
+		return "BIGINT", nil
+	case *SqlFloat:
+		return "FLOAT", nil
+	case *SqlDecimal:
+		return decimalTypeName("DECIMAL", t), nil
+	case *SqlDateTime:
+		return "DATETIME2", nil
+	case *SqlBoolean:
+		return "BIT", nil
+	case *SqlBlob:
+		return "VARBINARY(MAX)", nil
+	case *SqlJSON:
+		return "NVARCHAR(MAX)", nil
+	case *SqlUUID:
+		return "UNIQUEIDENTIFIER", nil
+	default:
+		return "", errDataTypeNotSupported(dialect, dataType)
+	}
+}
+
+// OFFSET ... FETCH is only allowed after an ORDER BY clause, so a constant ordering is
+// added if the statement has none.
+func (dialect SqlServerDialect) Pagination(limit int, offset int, ordered bool) string {
+	var sb strings.Builder
+	if !ordered {
+		sb.WriteString("ORDER BY (SELECT NULL) ")
+	}
+	sb.WriteString(fmt.Sprintf("OFFSET %d ROWS", offset))
+	if limit > 0 {
+		sb.WriteString(fmt.Sprintf(" FETCH NEXT %d ROWS ONLY", limit))
+	}
+	return sb.String()
+}
+
+// MS SQL Server has no INSERT ... ON CONFLICT, so the upsert is generated with MERGE
+func (dialect SqlServerDialect) Upsert(table string, columns []string, conflictColumns []string, placeholders []string) (string, error) {
+	var sb strings.Builder
+	sb.WriteString(fmt.Sprintf("MERGE INTO %s AS target\nUSING (VALUES (%s)) AS source (%s)\nON ",
+		table, strings.Join(placeholders, ", "), strings.Join(columns, ", ")))
+	for i, col := range conflictColumns {
+		if i > 0 {
+			sb.WriteString(" AND ")
+		}
+		sb.WriteString(fmt.Sprintf("target.%s = source.%s", col, col))
+	}
+	updateColumns := nonConflictColumns(columns, conflictColumns)
+	if len(updateColumns) > 0 {
+		sb.WriteString("\nWHEN MATCHED THEN UPDATE SET ")
+		for i, col := range updateColumns {
+			if i > 0 {
+				sb.WriteString(", ")
+			}
+			sb.WriteString(fmt.Sprintf("%s = source.%s", col, col))
+		}
+	}
+	sourceColumns := make([]string, len(columns))
+	for i, col := range columns {
+		sourceColumns[i] = "source." + col
+	}
+	sb.WriteString(fmt.Sprintf("\nWHEN NOT MATCHED THEN INSERT (%s) VALUES (%s);",
+		strings.Join(columns, ", "), strings.Join(sourceColumns, ", ")))
+	return sb.String(), nil
+}
+
+// REGEXP_LIKE is only available from SQL Server 2025 and in Azure SQL
+func (dialect SqlServerDialect) RegexMatch(column string, placeholder string) (string, error) {
+	return fmt.Sprintf("REGEXP_LIKE(%s, %s)", column, placeholder), nil
+}
+
+// Each nested level is expanded with OPENJSON, which returns scalars as unquoted text
+func (dialect SqlServerDialect) HasMember(column string, placeholder string, member string, depth int) (string, string, error) {
+	var decoded interface{}
+	if err := json.Unmarshal([]byte(member), &decoded); err != nil {
+		return "", "", err
+	}
+	param := member
+	if str, isString := decoded.(string); isString {
+		param = str
+	}
+	if depth < 1 {
+		depth = 1
+	}
+	var sb strings.Builder
+	sb.WriteString(fmt.Sprintf("EXISTS (SELECT 1 FROM OPENJSON(%s) AS l1", column))
+	for level := 2; level <= depth; level++ {
+		sb.WriteString(fmt.Sprintf(" CROSS APPLY OPENJSON(l%d.[value]) AS l%d", level-1, level))
+	}
+	sb.WriteString(fmt.Sprintf(" WHERE l%d.[value] = %s)", depth, placeholder))
+	return sb.String(), param, nil
+}
+
+// MS SQL Server has no equivalent of RELEASE SAVEPOINT
+func (dialect SqlServerDialect) Savepoint(name string) (string, string, string) {
+	return "SAVE TRANSACTION " + name, "ROLLBACK TRANSACTION " + name, ""
+}
+
+// MS SQL Server has no CREATE TABLE IF NOT EXISTS, so the table is looked up in the catalog
+func (dialect SqlServerDialect) CreateTableIfNotExists(table string, definition string) string {
+	return fmt.Sprintf("IF OBJECT_ID(N'%s', N'U') IS NULL CREATE TABLE %s %s;",
+		strings.ReplaceAll(table, "'", "''"), table, definition)
+}
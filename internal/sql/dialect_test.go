@@ -0,0 +1,69 @@
+package sql
+
+import (
+	"context"
+	dbsql "database/sql"
+	"errors"
+	"strings"
+	"testing"
+)
+
+// Third-party dialect implementing the core of Dialect only
+type coreDialect struct{ Dialect }
+
+var (
+	_ SavepointDialect              = SqlServerDialect{}
+	_ CreateTableIfNotExistsDialect = SqlServerDialect{}
+	_ HasMemberDialect              = SqlServerDialect{}
+	_ RecursiveWithDialect          = SqliteDialect{}
+	_ HasMemberDialect              = SqliteDialect{}
+	_ RecursiveWithDialect          = PostgresDialect{}
+	_ RecursiveWithDialect          = MySqlDialect{}
+)
+
+func TestDialectWithoutOptionalCapabilities(t *testing.T) {
+	dialect := coreDialect{SqlLite}
+	sqldb := NewSqlDatabase(dialect, openE2EDatabase(t, e2eBackends[0]), DefaultStmtCacheSize)
+	resetE2ETable(t, sqldb)
+	ctx := context.Background()
+
+	// standard CREATE TABLE IF NOT EXISTS
+	stmt, err := (&CreateTableStmt{
+		Dialect:     dialect,
+		TableSchema: TableSchema{TableName: e2eTable, Columns: map[string]SqlDataType{"id": &SqlText{}}},
+		IfNotExists: true,
+	}).GenerateStmt()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(stmt.Stmt, "CREATE TABLE IF NOT EXISTS") {
+		t.Fatalf("got %s", stmt.Stmt)
+	}
+	if _, err := sqldb.ExecuteSQL(stmt, ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	// standard savepoints
+	err = sqldb.WithTransaction(ctx, dbsql.LevelDefault, func(ctx context.Context, tx *TransactionManager) error {
+		insertE2ERow(t, sqldb, dialect, []string{"d", "delta", "4"}, ctx)
+		err := sqldb.WithTransaction(ctx, dbsql.LevelDefault, func(ctx context.Context, tx *TransactionManager) error {
+			insertE2ERow(t, sqldb, dialect, []string{"e", "epsilon", "5"}, ctx)
+			return errors.New("discard e")
+		})
+		if err == nil {
+			t.Errorf("savepoint did not fail")
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertIDs(t, selectAllE2EIDs(t, sqldb, ctx), []string{"a", "b", "c", "d"})
+
+	if _, ok := RecursiveWith(dialect); ok {
+		t.Fatalf("dialect reported as supporting recursive common table expressions")
+	}
+	if _, _, err := SQLHasMember(e2eTable, "tags", "y", 1).ToSQLParameterizedQuery(dialect, 1); err == nil {
+		t.Fatalf("generated a JSON array lookup without HasMember")
+	}
+}
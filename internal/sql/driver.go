@@ -3,49 +3,131 @@ package sql
 import (
 	"context"
 	dbsql "database/sql"
-	"log"
+	"time"
 )
 
+// Common operations shared by SqlDatabase and TransactionManager, allowing higher-level code
+// to run the same statements with or without a transaction.
+type SqlExecutor interface {
+	ExecuteSQL(stmt *SqlStmt, ctx context.Context) (int64, error)
+	ExecuteQuery(stmt *SqlStmt, ctx context.Context) ([]map[string]interface{}, error)
+	QueryRows(stmt *SqlStmt, ctx context.Context) (*RowIterator, error)
+}
+
 // A wrapper around database/sql. Provide methods that allow higher-level code to
 // perform various action on a SQL database.
 type SqlDatabase struct {
-	Dialect  *SqlDialect
+	Dialect  Dialect
 	ConnPool *dbsql.DB
+
+	// Controls how WithTransaction retries transactions aborted by serialization failures
+	// or deadlocks. The zero value uses the default policy.
+	TxRetryPolicy RetryPolicy
+
+	// Notified of every statement executed through the database or its transactions
+	Hooks []QueryHook
+
+	// Compute the parameters reported to Hooks. Parameters are reported as is if nil.
+	RedactParams ParamRedactor
+
+	// nil if statements are not cached
+	stmtCache *stmtCache
+}
+
+// Create a SqlDatabase that keeps up to stmtCacheSize prepared statements, keyed by the
+// generated SQL text, and reuses them across calls and transactions. Set stmtCacheSize to
+// 0 or negative value to disable the cache.
+func NewSqlDatabase(dialect Dialect, connPool *dbsql.DB, stmtCacheSize int) *SqlDatabase {
+	sqldb := &SqlDatabase{
+		Dialect:  dialect,
+		ConnPool: connPool,
+	}
+	if stmtCacheSize > 0 {
+		sqldb.stmtCache = newStmtCache(stmtCacheSize)
+	}
+	return sqldb
+}
+
+// Return the counters of the prepared statement cache
+func (sqldb *SqlDatabase) StmtCacheStats() StmtCacheStats {
+	if sqldb.stmtCache == nil {
+		return StmtCacheStats{}
+	}
+	return sqldb.stmtCache.snapshot()
+}
+
+// Close the cached prepared statements and the connection pool
+func (sqldb *SqlDatabase) Close() error {
+	if sqldb.stmtCache != nil {
+		sqldb.stmtCache.clear()
+	}
+	return sqldb.ConnPool.Close()
 }
 
 // Begin a transaction, and return a pointer to TransactionManager
 func (sqldb *SqlDatabase) StartTransaction(ctx context.Context) (*TransactionManager, error) {
-	tx, err := sqldb.ConnPool.BeginTx(ctx, &dbsql.TxOptions{})
+	return sqldb.startTransaction(ctx, &dbsql.TxOptions{})
+}
+
+func (sqldb *SqlDatabase) startTransaction(ctx context.Context, opts *dbsql.TxOptions) (*TransactionManager, error) {
+	tx, err := sqldb.ConnPool.BeginTx(ctx, opts)
 	if err != nil {
 		return nil, err
 	}
-	return &TransactionManager{Transaction: tx}, nil
+	return &TransactionManager{Transaction: tx, db: sqldb}, nil
 }
 
 // Execute SQL statement that does not return anything.
 // Return the number of rows affected as well as any error encountered during the process.
+// Run inside the transaction carried by ctx, if any (see WithTransaction).
 func (sqldb *SqlDatabase) ExecuteSQL(stmt *SqlStmt, ctx context.Context) (int64, error) {
-	return executeSQLWithCtx(nil, sqldb.ConnPool, stmt, ctx)
+	if txManager := transactionFromContext(ctx); txManager != nil {
+		return txManager.ExecuteSQL(stmt, ctx)
+	}
+	return executeSQLWithCtx(nil, sqldb, stmt, ctx)
 }
 
 // Execute a SQL query that returns data from the database.
 // Return key-value pairs of column names and their corresponding value
+// Run inside the transaction carried by ctx, if any (see WithTransaction).
 func (sqldb *SqlDatabase) ExecuteQuery(stmt *SqlStmt, ctx context.Context) ([]map[string]interface{}, error) {
-	return executeQueryWithCtx(nil, sqldb.ConnPool, stmt, ctx)
+	if txManager := transactionFromContext(ctx); txManager != nil {
+		return txManager.ExecuteQuery(stmt, ctx)
+	}
+	return executeQueryWithCtx(nil, sqldb, stmt, ctx)
+}
+
+// Execute a SQL query and return an iterator that streams the rows from the database.
+// The caller is responsible for closing the iterator.
+// Run inside the transaction carried by ctx, if any (see WithTransaction).
+func (sqldb *SqlDatabase) QueryRows(stmt *SqlStmt, ctx context.Context) (*RowIterator, error) {
+	if txManager := transactionFromContext(ctx); txManager != nil {
+		return txManager.QueryRows(stmt, ctx)
+	}
+	return queryRowsWithCtx(nil, sqldb, stmt, ctx)
 }
 
 // Represent a SQL transaction. Allow users to execute a series of SQL query as a single transaction.
 type TransactionManager struct {
 	Transaction *dbsql.Tx
+
+	// database the transaction was started from
+	db *SqlDatabase
+
+	// set when the transaction is driven by WithTransaction, which takes care of rolling back
+	managed bool
+
+	// number of savepoints currently open
+	savepointDepth int
 }
 
 // Execute SQL statement that does not return anything inside a transaction.
 // Return the number of rows affected as well as any error encountered during the process.
-// Rollback if encountered errors.
+// Rollback if encountered errors, unless the transaction is managed by WithTransaction.
 func (txManager *TransactionManager) ExecuteSQL(stmt *SqlStmt, ctx context.Context) (int64, error) {
-	result, err := executeSQLWithCtx(txManager.Transaction, nil, stmt, ctx)
+	result, err := executeSQLWithCtx(txManager, txManager.db, stmt, ctx)
 	if err != nil {
-		txManager.Rollback()
+		txManager.rollbackOnError()
 		return 0, err
 	}
 	return result, nil
@@ -53,21 +135,38 @@ func (txManager *TransactionManager) ExecuteSQL(stmt *SqlStmt, ctx context.Conte
 
 // Execute a SQL query that returns data from the database.
 // Return key-value pairs of column names and their corresponding value
-// Rollback if encountered errors.
+// Rollback if encountered errors, unless the transaction is managed by WithTransaction.
 func (txManager *TransactionManager) ExecuteQuery(stmt *SqlStmt, ctx context.Context) ([]map[string]interface{}, error) {
-	result, err := executeQueryWithCtx(txManager.Transaction, nil, stmt, ctx)
+	result, err := executeQueryWithCtx(txManager, txManager.db, stmt, ctx)
 	if err != nil {
-		txManager.Rollback()
+		txManager.rollbackOnError()
 		return nil, err
 	}
 	return result, nil
 }
 
-// Rollback all the changes made to the database.
-func (txManager *TransactionManager) Rollback() {
-	err := txManager.Transaction.Rollback()
+// Execute a SQL query inside a transaction and return an iterator that streams the rows
+// from the database. The caller is responsible for closing the iterator.
+// Rollback if encountered errors, unless the transaction is managed by WithTransaction.
+func (txManager *TransactionManager) QueryRows(stmt *SqlStmt, ctx context.Context) (*RowIterator, error) {
+	iter, err := queryRowsWithCtx(txManager, txManager.db, stmt, ctx)
 	if err != nil {
-		log.Fatal(err)
+		txManager.rollbackOnError()
+		return nil, err
+	}
+	return iter, nil
+}
+
+// Rollback all the changes made to the database.
+func (txManager *TransactionManager) Rollback() error {
+	return txManager.Transaction.Rollback()
+}
+
+// Rollback a manually managed transaction after a failed statement. The error of the
+// statement is what gets reported to the caller, so a failed rollback is ignored here.
+func (txManager *TransactionManager) rollbackOnError() {
+	if !txManager.managed {
+		_ = txManager.Rollback()
 	}
 }
 
@@ -80,13 +179,34 @@ func (txManager *TransactionManager) Commit() error {
 	return nil
 }
 
-func executeSQLWithCtx(tx *dbsql.Tx, conn *dbsql.DB, stmt *SqlStmt, ctx context.Context) (int64, error) {
+func executeSQLWithCtx(txManager *TransactionManager, sqldb *SqlDatabase, stmt *SqlStmt, ctx context.Context) (int64, error) {
+	start := time.Now()
+	rows, err := runSQLWithCtx(txManager, sqldb, stmt, ctx)
+	sqldb.runQueryHooks(ctx, &QueryEvent{
+		Stmt:          stmt,
+		Duration:      time.Since(start),
+		RowsAffected:  rows,
+		Err:           err,
+		InTransaction: txManager != nil,
+	})
+	return rows, err
+}
+
+func runSQLWithCtx(txManager *TransactionManager, sqldb *SqlDatabase, stmt *SqlStmt, ctx context.Context) (int64, error) {
+	params := castStringListToAnyList(stmt.Params)
 	var result dbsql.Result
 	var err error
-	if tx == nil {
-		result, err = conn.ExecContext(ctx, stmt.Stmt, castStringListToAnyList(stmt.Params))
+	if sqldb.usesStmtCache(stmt) {
+		prepared, release, prepareErr := prepareWithCtx(txManager, sqldb, stmt, ctx)
+		if prepareErr != nil {
+			return 0, prepareErr
+		}
+		result, err = prepared.ExecContext(ctx, params...)
+		release()
+	} else if txManager == nil {
+		result, err = sqldb.ConnPool.ExecContext(ctx, stmt.Stmt, params...)
 	} else {
-		result, err = tx.ExecContext(ctx, stmt.Stmt, castStringListToAnyList(stmt.Params))
+		result, err = txManager.Transaction.ExecContext(ctx, stmt.Stmt, params...)
 	}
 	if err != nil {
 		return 0, err
@@ -98,36 +218,121 @@ func executeSQLWithCtx(tx *dbsql.Tx, conn *dbsql.DB, stmt *SqlStmt, ctx context.
 	return rows, nil
 }
 
-func executeQueryWithCtx(tx *dbsql.Tx, conn *dbsql.DB, stmt *SqlStmt, ctx context.Context) ([]map[string]interface{}, error) {
-	var rows *dbsql.Rows
-	var err error
-	if tx == nil {
-		rows, err = conn.QueryContext(ctx, stmt.Stmt, castStringListToAnyList(stmt.Params)...)
-	} else {
-		rows, err = tx.QueryContext(ctx, stmt.Stmt, castStringListToAnyList(stmt.Params)...)
-	}
+func executeQueryWithCtx(txManager *TransactionManager, sqldb *SqlDatabase, stmt *SqlStmt, ctx context.Context) ([]map[string]interface{}, error) {
+	iter, err := queryRowsWithCtx(txManager, sqldb, stmt, ctx)
 	if err != nil {
 		return nil, err
 	}
-	defer rows.Close()
-	cols, err := rows.Columns()
+	defer iter.Close()
+
+	res := make([]map[string]interface{}, 0)
+	for iter.Next() {
+		res = append(res, iter.Row())
+	}
+	if err := iter.Err(); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+func queryRowsWithCtx(txManager *TransactionManager, sqldb *SqlDatabase, stmt *SqlStmt, ctx context.Context) (*RowIterator, error) {
+	start := time.Now()
+	report := func(rowsRead int64, err error) {
+		sqldb.runQueryHooks(ctx, &QueryEvent{
+			Stmt:          stmt,
+			Duration:      time.Since(start),
+			RowsAffected:  rowsRead,
+			Err:           err,
+			InTransaction: txManager != nil,
+		})
+	}
+	iter, err := openRowsWithCtx(txManager, sqldb, stmt, ctx)
 	if err != nil {
+		report(0, err)
 		return nil, err
 	}
+	iter.report = report
+	return iter, nil
+}
 
-	res := make([]map[string]interface{}, 0)
-	for rows.Next() {
-		row := make(map[string]interface{})
-		vals := make([]interface{}, len(cols))
-		for index, colName := range cols {
-			row[colName] = &vals[index]
+func openRowsWithCtx(txManager *TransactionManager, sqldb *SqlDatabase, stmt *SqlStmt, ctx context.Context) (*RowIterator, error) {
+	params := castStringListToAnyList(stmt.Params)
+	if sqldb.usesStmtCache(stmt) {
+		prepared, release, err := prepareWithCtx(txManager, sqldb, stmt, ctx)
+		if err != nil {
+			return nil, err
 		}
-		if err := rows.Scan(vals...); err != nil {
+		rows, err := prepared.QueryContext(ctx, params...)
+		if err != nil {
+			release()
 			return nil, err
 		}
-		res = append(res, row)
+		iter, err := newRowIterator(rows)
+		if err != nil {
+			release()
+			return nil, err
+		}
+		iter.release = release
+		return iter, nil
 	}
-	return res, nil
+
+	var rows *dbsql.Rows
+	var err error
+	if txManager == nil {
+		rows, err = sqldb.ConnPool.QueryContext(ctx, stmt.Stmt, params...)
+	} else {
+		rows, err = txManager.Transaction.QueryContext(ctx, stmt.Stmt, params...)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return newRowIterator(rows)
+}
+
+// Get the prepared statement for stmt from the cache, preparing it on the pool and adding
+// it to the cache on a miss. The returned function must be called once the statement (and
+// any rows it returned) is no longer used.
+//
+// Inside a transaction, the cached statement is bound to the transaction with
+// Tx.StmtContext, so statements first run by a transaction are shared with later callers.
+// Preparing a missing statement needs a connection of the pool besides the one held by the
+// transaction: if none is left, e.g. when the pool only has one connection, the statement
+// is prepared on the transaction instead and closed once used.
+func prepareWithCtx(txManager *TransactionManager, sqldb *SqlDatabase, stmt *SqlStmt, ctx context.Context) (*dbsql.Stmt, func(), error) {
+	if txManager != nil && !sqldb.stmtCache.contains(stmt.Stmt) && !hasFreeConn(sqldb.ConnPool) {
+		sqldb.stmtCache.recordMiss()
+		prepared, err := txManager.Transaction.PrepareContext(ctx, stmt.Stmt)
+		if err != nil {
+			return nil, nil, err
+		}
+		return prepared, func() { prepared.Close() }, nil
+	}
+
+	cached, err := sqldb.stmtCache.acquire(ctx, sqldb.ConnPool, stmt.Stmt)
+	if err != nil {
+		return nil, nil, err
+	}
+	if txManager == nil {
+		return cached.stmt, func() { sqldb.stmtCache.release(cached) }, nil
+	}
+	txStmt := txManager.Transaction.StmtContext(ctx, cached.stmt)
+	return txStmt, func() {
+		txStmt.Close()
+		sqldb.stmtCache.release(cached)
+	}, nil
+}
+
+// Report whether a connection can be taken from the pool without waiting for another one
+// to be released
+func hasFreeConn(connPool *dbsql.DB) bool {
+	stats := connPool.Stats()
+	return stats.MaxOpenConnections <= 0 || stats.Idle > 0 || stats.OpenConnections < stats.MaxOpenConnections
+}
+
+// Report whether stmt should go through the prepared statement cache. DDL statements are
+// usually run once, and may invalidate the statements prepared before them.
+func (sqldb *SqlDatabase) usesStmtCache(stmt *SqlStmt) bool {
+	return sqldb.stmtCache != nil && !isDDLStmt(stmt.Stmt)
 }
 
 func castStringListToAnyList(arr []string) []any {
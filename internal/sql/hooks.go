@@ -0,0 +1,132 @@
+package sql
+
+import (
+	"context"
+	"log/slog"
+	"time"
+)
+
+// Placeholder replacing the parameters redacted by RedactAllParams
+const RedactedParam = "[REDACTED]"
+
+// Describe a single statement executed through SqlDatabase or TransactionManager
+type QueryEvent struct {
+	Stmt *SqlStmt
+
+	// Parameters bound to the statement, after redaction if SqlDatabase.RedactParams is set
+	Params []string
+
+	// Time spent executing the statement. For queries, this includes reading the rows, up
+	// until the iterator is closed.
+	Duration time.Duration
+
+	// Number of rows affected by ExecuteSQL, or number of rows read from a query
+	RowsAffected int64
+
+	// Error returned to the caller, if any
+	Err error
+
+	// Whether the statement was executed inside a transaction
+	InTransaction bool
+}
+
+// Receive an event for every statement executed through SqlDatabase or TransactionManager.
+// Hooks are called synchronously, so they should return quickly.
+type QueryHook interface {
+	AfterQuery(ctx context.Context, event *QueryEvent)
+}
+
+// Adapter allowing an ordinary function to be used as a QueryHook
+type QueryHookFunc func(ctx context.Context, event *QueryEvent)
+
+func (hook QueryHookFunc) AfterQuery(ctx context.Context, event *QueryEvent) {
+	hook(ctx, event)
+}
+
+// Compute the parameters reported to the hooks for the given statement, e.g. to hide
+// sensitive values
+type ParamRedactor func(stmt *SqlStmt) []string
+
+// ParamRedactor replacing every parameter with RedactedParam
+func RedactAllParams(stmt *SqlStmt) []string {
+	params := make([]string, len(stmt.Params))
+	for i := range params {
+		params[i] = RedactedParam
+	}
+	return params
+}
+
+// QueryHook logging every statement with log/slog. Failed statements are logged at error level.
+type SlogQueryLogger struct {
+	// Default to slog.Default()
+	Logger *slog.Logger
+
+	// Level used for statements that succeeded
+	Level slog.Level
+}
+
+func (logger *SlogQueryLogger) AfterQuery(ctx context.Context, event *QueryEvent) {
+	level := logger.Level
+	attrs := queryEventAttrs(event)
+	if event.Err != nil {
+		level = slog.LevelError
+		attrs = append(attrs, slog.Any("error", event.Err))
+	}
+	getSlogLogger(logger.Logger).LogAttrs(ctx, level, "sql statement executed", attrs...)
+}
+
+// QueryHook reporting statements that took at least Threshold to execute
+type SlowQueryReporter struct {
+	Threshold time.Duration
+
+	// Called for each slow statement. If nil, the statement is logged at warning level
+	// with Logger.
+	OnSlowQuery func(ctx context.Context, event *QueryEvent)
+
+	// Default to slog.Default()
+	Logger *slog.Logger
+}
+
+func (reporter *SlowQueryReporter) AfterQuery(ctx context.Context, event *QueryEvent) {
+	if event.Duration < reporter.Threshold {
+		return
+	}
+	if reporter.OnSlowQuery != nil {
+		reporter.OnSlowQuery(ctx, event)
+		return
+	}
+	attrs := append(queryEventAttrs(event), slog.Duration("threshold", reporter.Threshold))
+	getSlogLogger(reporter.Logger).LogAttrs(ctx, slog.LevelWarn, "slow sql statement", attrs...)
+}
+
+// Notify the hooks of the database that a statement has been executed
+func (sqldb *SqlDatabase) runQueryHooks(ctx context.Context, event *QueryEvent) {
+	if len(sqldb.Hooks) == 0 {
+		return
+	}
+	if sqldb.RedactParams != nil {
+		event.Params = sqldb.RedactParams(event.Stmt)
+	} else {
+		event.Params = event.Stmt.Params
+	}
+	for _, hook := range sqldb.Hooks {
+		hook.AfterQuery(ctx, event)
+	}
+}
+
+func queryEventAttrs(event *QueryEvent) []slog.Attr {
+	return []slog.Attr{
+		slog.String("stmt", event.Stmt.Stmt),
+		slog.Any("params", event.Params),
+		slog.Duration("duration", event.Duration),
+		slog.Int64("rows", event.RowsAffected),
+		slog.Bool("in_transaction", event.InTransaction),
+	}
+}
+
+func getSlogLogger(logger *slog.Logger) *slog.Logger {
+	if logger == nil {
+		return slog.Default()
+	}
+	return logger
+}
@@ -0,0 +1,68 @@
+package sql
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Generator for SQL INSERT Statement
+type InsertStmt struct {
+	Dialect Dialect
+	Table   string
+	Columns []string
+	Values  []string
+
+	// If set, generate an upsert: the row conflicting with the new one on these columns
+	// gets its other columns updated instead of the insert failing
+	ConflictColumns []string
+}
+
+// Generate parameterized INSERT SQL statement based on the dialect provided, the table to
+// insert into, and the value of each column.
+func (stmt *InsertStmt) GenerateStmt() (res *SqlStmt, err error) {
+	if !validateToken(stmt.Table) {
+		return &SqlStmt{}, fmt.Errorf("table validation failed")
+	}
+	if len(stmt.Columns) == 0 {
+		return &SqlStmt{}, fmt.Errorf("must insert at least one column")
+	}
+	if len(stmt.Columns) != len(stmt.Values) {
+		return &SqlStmt{}, fmt.Errorf("number of columns and values do not match")
+	}
+	table := stmt.Dialect.QuoteIdentifier(stmt.Table)
+	columns := make([]string, len(stmt.Columns))
+	placeholders := make([]string, len(stmt.Columns))
+	for i, col := range stmt.Columns {
+		if !validateToken(col) {
+			return &SqlStmt{}, fmt.Errorf("column validation failed")
+		}
+		columns[i] = stmt.Dialect.QuoteIdentifier(col)
+		placeholders[i], err = stmt.Dialect.Placeholder(i + 1)
+		if err != nil {
+			return &SqlStmt{}, err
+		}
+	}
+
+	if len(stmt.ConflictColumns) == 0 {
+		return &SqlStmt{
+			Stmt: fmt.Sprintf("INSERT INTO %s (%s)\nVALUES (%s);",
+				table, strings.Join(columns, ", "), strings.Join(placeholders, ", ")),
+			Params: stmt.Values,
+		}, nil
+	}
+	conflictColumns := make([]string, len(stmt.ConflictColumns))
+	for i, col := range stmt.ConflictColumns {
+		if !validateToken(col) {
+			return &SqlStmt{}, fmt.Errorf("column validation failed")
+		}
+		conflictColumns[i] = stmt.Dialect.QuoteIdentifier(col)
+	}
+	upsert, err := stmt.Dialect.Upsert(table, columns, conflictColumns, placeholders)
+	if err != nil {
+		return &SqlStmt{}, err
+	}
+	return &SqlStmt{
+		Stmt:   upsert,
+		Params: stmt.Values,
+	}, nil
+}
@@ -1,8 +1,11 @@
 package sql
 
 import (
+	"encoding/json"
 	"fmt"
+	"strconv"
 	"strings"
+	"time"
 )
 
 // Represent query that can be converted into a SQL WHERE clause statement
@@ -10,7 +13,7 @@ type SqlQueryFunction interface {
 
 	// Generate WHERE parameterized query based on the SQLDialect passed in.
 	// index represents the starting index of the query placeholder (only applicable to SQLServer and Postgres dialects)
-	ToSQLParameterizedQuery(dialect SqlDialect, index int) (stmt string, params []string, err error)
+	ToSQLParameterizedQuery(dialect Dialect, index int) (stmt string, params []string, err error)
 }
 
 // SQL WHERE clause with a single condition
@@ -21,7 +24,7 @@ type SqlSingleQueryFunction struct {
 	operator  string
 }
 
-func (function *SqlSingleQueryFunction) ToSQLParameterizedQuery(dialect SqlDialect, index int) (string, []string, error) {
+func (function *SqlSingleQueryFunction) ToSQLParameterizedQuery(dialect Dialect, index int) (string, []string, error) {
 	if function.table != "" {
 		if !validateToken(function.table) {
 			return "", nil, fmt.Errorf("token validation failed")
@@ -30,14 +33,23 @@ func (function *SqlSingleQueryFunction) ToSQLParameterizedQuery(dialect SqlDiale
 	if !validateToken(function.column) {
 		return "", nil, fmt.Errorf("token validation failed")
 	}
-	replacement, err := getPlaceholderForSqlDialect(dialect, index)
+	replacement, err := dialect.Placeholder(index)
 	if err != nil {
 		return "", nil, err
 	}
-	if function.table == "" {
-		return fmt.Sprintf("%s%s%s", function.column, function.operator, replacement), []string{function.valueEqTo}, nil
+	column := qualifiedColumn(dialect, function.table, function.column)
+	switch function.operator {
+	case "REGEX":
+		stmt, err := dialect.RegexMatch(column, replacement)
+		if err != nil {
+			return "", nil, err
+		}
+		return stmt, []string{function.valueEqTo}, nil
+	case "LIKE":
+		return fmt.Sprintf("%s LIKE %s", column, replacement), []string{function.valueEqTo}, nil
+	default:
+		return fmt.Sprintf("%s%s%s", column, function.operator, replacement), []string{function.valueEqTo}, nil
 	}
-	return fmt.Sprintf("%s.%s%s%s", function.table, function.column, function.operator, replacement), []string{function.valueEqTo}, nil
 }
 
 // SQL WHERE clause with multiple conditions chained by AND or OR
@@ -46,7 +58,7 @@ type SqlCompositeQueryFunction struct {
 	functions []SqlQueryFunction
 }
 
-func (function *SqlCompositeQueryFunction) ToSQLParameterizedQuery(dialect SqlDialect, index int) (string, []string, error) {
+func (function *SqlCompositeQueryFunction) ToSQLParameterizedQuery(dialect Dialect, index int) (string, []string, error) {
 	var stmtSb strings.Builder
 	var params []string
 	for i, f := range function.functions {
@@ -78,7 +90,7 @@ type SqlColumnEqualQueryFunction struct {
 	rColumn string
 }
 
-func (function *SqlColumnEqualQueryFunction) ToSQLParameterizedQuery(dialect SqlDialect, index int) (string, []string, error) {
+func (function *SqlColumnEqualQueryFunction) ToSQLParameterizedQuery(dialect Dialect, index int) (string, []string, error) {
 	if !validateToken(function.lTable) {
 		return "", nil, fmt.Errorf("token validation failed")
 	}
@@ -91,7 +103,57 @@ func (function *SqlColumnEqualQueryFunction) ToSQLParameterizedQuery(dialect Sql
 	if !validateToken(function.rColumn) {
 		return "", nil, fmt.Errorf("token validation failed")
 	}
-	return fmt.Sprintf("%s.%s=%s.%s", function.lTable, function.lColumn, function.rTable, function.rColumn), []string{}, nil
+	return fmt.Sprintf("%s=%s", qualifiedColumn(dialect, function.lTable, function.lColumn),
+		qualifiedColumn(dialect, function.rTable, function.rColumn)), []string{}, nil
+}
+
+// SQL WHERE clause testing whether a column holding a JSON array has a given member
+type SqlHasMemberQueryFunction struct {
+	table  string
+	column string
+	member interface{}
+	depth  int
+}
+
+func (function *SqlHasMemberQueryFunction) ToSQLParameterizedQuery(dialect Dialect, index int) (string, []string, error) {
+	if function.table != "" {
+		if !validateToken(function.table) {
+			return "", nil, fmt.Errorf("token validation failed")
+		}
+	}
+	if !validateToken(function.column) {
+		return "", nil, fmt.Errorf("token validation failed")
+	}
+	switch function.member.(type) {
+	case []interface{}, map[string]interface{}, nil:
+		return "", nil, fmt.Errorf("member must be a string, number or boolean")
+	}
+	member, err := json.Marshal(function.member)
+	if err != nil {
+		return "", nil, err
+	}
+	replacement, err := dialect.Placeholder(index)
+	if err != nil {
+		return "", nil, err
+	}
+	hasMember, ok := dialect.(HasMemberDialect)
+	if !ok {
+		return "", nil, fmt.Errorf("dialect %s cannot look into JSON arrays", dialect.Name())
+	}
+	column := qualifiedColumn(dialect, function.table, function.column)
+	stmt, param, err := hasMember.HasMember(column, replacement, string(member), function.depth)
+	if err != nil {
+		return "", nil, err
+	}
+	return stmt, []string{param}, nil
+}
+
+// Quote the column, prefixed with its table if any
+func qualifiedColumn(dialect Dialect, table string, column string) string {
+	if table == "" {
+		return dialect.QuoteIdentifier(column)
+	}
+	return dialect.QuoteIdentifier(table) + "." + dialect.QuoteIdentifier(column)
 }
 
 // Represent SQL Equal (=) Operator
@@ -119,7 +181,7 @@ func SQLLessThan(table string, col string, val float64) SqlQueryFunction {
 	return &SqlSingleQueryFunction{
 		table:     table,
 		column:    col,
-		valueEqTo: fmt.Sprintf("%.2f", val),
+		valueEqTo: strconv.FormatFloat(val, 'g', -1, 64),
 		operator:  "<",
 	}
 }
@@ -129,7 +191,38 @@ func SQLGreaterThan(table string, col string, val float64) SqlQueryFunction {
 	return &SqlSingleQueryFunction{
 		table:     table,
 		column:    col,
-		valueEqTo: fmt.Sprintf("%.2f", val),
+		valueEqTo: strconv.FormatFloat(val, 'g', -1, 64),
+		operator:  ">",
+	}
+}
+
+// Represent SQL Less Than (<) Operator for timestamps, matching values strictly before t
+func SQLBefore(table string, col string, t time.Time) SqlQueryFunction {
+	return &SqlSingleQueryFunction{
+		table:     table,
+		column:    col,
+		valueEqTo: t.UTC().Format(SqlDateTimeLayout),
+		operator:  "<",
+	}
+}
+
+// Represent SQL Less Than or Equal (<=) Operator for timestamps, matching values at or
+// before t
+func SQLAtOrBefore(table string, col string, t time.Time) SqlQueryFunction {
+	return &SqlSingleQueryFunction{
+		table:     table,
+		column:    col,
+		valueEqTo: t.UTC().Format(SqlDateTimeLayout),
+		operator:  "<=",
+	}
+}
+
+// Represent SQL Greater Than (>) Operator for timestamps, matching values strictly after t
+func SQLAfter(table string, col string, t time.Time) SqlQueryFunction {
+	return &SqlSingleQueryFunction{
+		table:     table,
+		column:    col,
+		valueEqTo: t.UTC().Format(SqlDateTimeLayout),
 		operator:  ">",
 	}
 }
@@ -154,6 +247,18 @@ func SQLSubstring(table string, col string, substr string) SqlQueryFunction {
 	}
 }
 
+// Represent membership test for columns holding JSON arrays, such as NDIArray (depth 1) and
+// NDIMatrix (depth 2) fields: match rows whose array, or any array nested in it up to depth
+// levels, has val (a string, number or boolean) as element
+func SQLHasMember(table string, col string, val interface{}, depth int) SqlQueryFunction {
+	return &SqlHasMemberQueryFunction{
+		table:  table,
+		column: col,
+		member: val,
+		depth:  depth,
+	}
+}
+
 // Represent SQL AND
 func SQLAnd(funcList ...SqlQueryFunction) SqlQueryFunction {
 	return &SqlCompositeQueryFunction{
@@ -0,0 +1,326 @@
+package sql
+
+import (
+	"context"
+	dbsql "database/sql"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Iterate over the result of a SQL query one row at a time, without loading the whole
+// result set into memory. Close must be called once the caller is done with the iterator.
+type RowIterator struct {
+	rows     *dbsql.Rows
+	cols     []string
+	colTypes []*dbsql.ColumnType
+	vals     []interface{}
+	err      error
+
+	// called once the rows are closed, e.g. to give back a cached prepared statement
+	release func()
+
+	// number of rows read so far, and function reporting it to the query hooks on Close
+	rowsRead int64
+	report   func(rowsRead int64, err error)
+
+	// field index of the last struct type decoded by ScanStruct
+	structType   reflect.Type
+	structFields []int
+}
+
+func newRowIterator(rows *dbsql.Rows) (*RowIterator, error) {
+	cols, err := rows.Columns()
+	if err != nil {
+		rows.Close()
+		return nil, err
+	}
+	colTypes, err := rows.ColumnTypes()
+	if err != nil {
+		rows.Close()
+		return nil, err
+	}
+	return &RowIterator{
+		rows:     rows,
+		cols:     cols,
+		colTypes: colTypes,
+	}, nil
+}
+
+// Return the names of the columns in the result set
+func (iter *RowIterator) Columns() []string {
+	return iter.cols
+}
+
+// Advance to the next row. Return false when there are no more rows or an error has
+// been encountered, in which case the error can be retrieved with Err.
+func (iter *RowIterator) Next() bool {
+	if iter.err != nil {
+		return false
+	}
+	if !iter.rows.Next() {
+		return false
+	}
+	vals := make([]interface{}, len(iter.cols))
+	ptrs := make([]interface{}, len(iter.cols))
+	for i := range vals {
+		ptrs[i] = &vals[i]
+	}
+	if err := iter.rows.Scan(ptrs...); err != nil {
+		iter.err = err
+		return false
+	}
+	for i, val := range vals {
+		vals[i], iter.err = decodeColumnValue(iter.colTypes[i], val)
+		if iter.err != nil {
+			return false
+		}
+	}
+	iter.vals = vals
+	iter.rowsRead += 1
+	return true
+}
+
+// Return the current row as key-value pairs of column names and their corresponding value,
+// or nil if Next has not returned true yet
+func (iter *RowIterator) Row() map[string]interface{} {
+	if iter.vals == nil {
+		return nil
+	}
+	row := make(map[string]interface{}, len(iter.cols))
+	for i, col := range iter.cols {
+		row[col] = iter.vals[i]
+	}
+	return row
+}
+
+// Decode the current row into the struct pointed to by dest. Columns are matched to fields
+// by the `sql` struct tag, or by the field name (case-insensitive) if the tag is absent.
+// Fields tagged with `sql:"-"` and columns without a matching field are ignored.
+func (iter *RowIterator) ScanStruct(dest interface{}) error {
+	ptr := reflect.ValueOf(dest)
+	if ptr.Kind() != reflect.Pointer || ptr.IsNil() || ptr.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("destination must be a non-nil pointer to a struct")
+	}
+	if iter.vals == nil {
+		return fmt.Errorf("no current row, Next must be called first")
+	}
+	structVal := ptr.Elem()
+	if structVal.Type() != iter.structType {
+		iter.structType = structVal.Type()
+		iter.structFields = mapColumnsToFields(iter.cols, iter.structType)
+	}
+	for i, fieldIndex := range iter.structFields {
+		if fieldIndex < 0 {
+			continue
+		}
+		if err := assignColumnValue(structVal.Field(fieldIndex), iter.vals[i]); err != nil {
+			return fmt.Errorf("column %s: %w", iter.cols[i], err)
+		}
+	}
+	return nil
+}
+
+// Return the error, if any, that was encountered during iteration
+func (iter *RowIterator) Err() error {
+	if iter.err != nil {
+		return iter.err
+	}
+	return iter.rows.Err()
+}
+
+// Close the iterator and release the underlying connection
+func (iter *RowIterator) Close() error {
+	err := iter.rows.Close()
+	if iter.release != nil {
+		iter.release()
+		iter.release = nil
+	}
+	if iter.report != nil {
+		iterErr := iter.Err()
+		if iterErr == nil {
+			iterErr = err
+		}
+		iter.report(iter.rowsRead, iterErr)
+		iter.report = nil
+	}
+	return err
+}
+
+// Execute the query and decode every row into a value of type T, which must be a struct.
+// See RowIterator.ScanStruct for how columns are matched to fields.
+func QueryStructs[T any](executor SqlExecutor, stmt *SqlStmt, ctx context.Context) ([]T, error) {
+	iter, err := executor.QueryRows(stmt, ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer iter.Close()
+
+	res := make([]T, 0)
+	for iter.Next() {
+		var item T
+		if err := iter.ScanStruct(&item); err != nil {
+			return nil, err
+		}
+		res = append(res, item)
+	}
+	if err := iter.Err(); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// Convert the raw value returned by the driver into a Go value. Drivers commonly return
+// []byte for textual and numeric columns; those are converted based on the column's
+// database type name. DECIMAL and NUMERIC columns are returned as string to avoid losing
+// precision.
+func decodeColumnValue(colType *dbsql.ColumnType, val interface{}) (interface{}, error) {
+	raw, isBytes := val.([]byte)
+	if !isBytes {
+		return val, nil
+	}
+	typeName := strings.ToUpper(colType.DatabaseTypeName())
+	switch {
+	case strings.Contains(typeName, "BLOB"), strings.Contains(typeName, "BINARY"), typeName == "BYTEA":
+		return raw, nil
+	case isIntegerType(typeName):
+		return parseInteger(string(raw))
+	case strings.Contains(typeName, "FLOAT"), strings.Contains(typeName, "DOUBLE"), strings.Contains(typeName, "REAL"):
+		return strconv.ParseFloat(string(raw), 64)
+	case strings.HasPrefix(typeName, "BOOL"):
+		return strconv.ParseBool(string(raw))
+	default:
+		return string(raw), nil
+	}
+}
+
+// Check if the database type name reported by the driver denotes an integer column,
+// e.g. INTEGER, INT4 or BIGINT
+func isIntegerType(typeName string) bool {
+	switch typeName {
+	case "INTEGER", "INT2", "INT4", "INT8":
+		return true
+	}
+	return strings.HasSuffix(typeName, "INT")
+}
+
+// Parse an integer column value as int64, or as uint64 if it is an unsigned value too large
+// for int64, e.g. of a BIGINT UNSIGNED column
+func parseInteger(str string) (interface{}, error) {
+	num, err := strconv.ParseInt(str, 10, 64)
+	if err == nil {
+		return num, nil
+	}
+	if unsigned, uintErr := strconv.ParseUint(str, 10, 64); uintErr == nil {
+		return unsigned, nil
+	}
+	return nil, err
+}
+
+// Find the struct field each column should be decoded into. The result holds the field
+// index for each column, or -1 if the column has no corresponding field.
+func mapColumnsToFields(cols []string, structType reflect.Type) []int {
+	byName := make(map[string]int)
+	for i := 0; i < structType.NumField(); i++ {
+		field := structType.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		name, hasTag := field.Tag.Lookup("sql")
+		if name == "-" {
+			continue
+		}
+		if !hasTag || name == "" {
+			name = field.Name
+		}
+		byName[strings.ToLower(name)] = i
+	}
+	res := make([]int, len(cols))
+	for i, col := range cols {
+		fieldIndex, ok := byName[strings.ToLower(col)]
+		if !ok {
+			fieldIndex = -1
+		}
+		res[i] = fieldIndex
+	}
+	return res
+}
+
+// Assign a decoded column value to a struct field, converting between compatible types.
+// NULL leaves the field at its zero value; pointer fields are allocated for non-NULL values.
+func assignColumnValue(field reflect.Value, val interface{}) error {
+	if val == nil {
+		field.Set(reflect.Zero(field.Type()))
+		return nil
+	}
+	if field.Kind() == reflect.Pointer {
+		elem := reflect.New(field.Type().Elem())
+		if err := assignColumnValue(elem.Elem(), val); err != nil {
+			return err
+		}
+		field.Set(elem)
+		return nil
+	}
+	src := reflect.ValueOf(val)
+	if src.Type().AssignableTo(field.Type()) {
+		field.Set(src)
+		return nil
+	}
+	if str, isString := val.(string); isString {
+		return assignStringValue(field, str)
+	}
+	if _, isTime := val.(time.Time); !isTime && src.Type().ConvertibleTo(field.Type()) &&
+		(field.Kind() != reflect.String || src.Kind() == reflect.Slice) {
+		field.Set(src.Convert(field.Type()))
+		return nil
+	}
+	return fmt.Errorf("cannot assign %T to %s", val, field.Type())
+}
+
+// Parse a string column value into a field of numeric, boolean or time.Time type. Timestamps
+// are returned as text by the dialects without a native timestamp type.
+func assignStringValue(field reflect.Value, str string) error {
+	if field.Type() == reflect.TypeOf(time.Time{}) {
+		t, err := time.Parse(SqlDateTimeLayout, str)
+		if err != nil {
+			if t, err = time.Parse(time.RFC3339Nano, str); err != nil {
+				return fmt.Errorf("%s is not a valid timestamp", str)
+			}
+		}
+		field.Set(reflect.ValueOf(t))
+		return nil
+	}
+	switch field.Kind() {
+	case reflect.String:
+		field.SetString(str)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		num, err := strconv.ParseInt(str, 10, field.Type().Bits())
+		if err != nil {
+			return err
+		}
+		field.SetInt(num)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		num, err := strconv.ParseUint(str, 10, field.Type().Bits())
+		if err != nil {
+			return err
+		}
+		field.SetUint(num)
+	case reflect.Float32, reflect.Float64:
+		num, err := strconv.ParseFloat(str, field.Type().Bits())
+		if err != nil {
+			return err
+		}
+		field.SetFloat(num)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(str)
+		if err != nil {
+			return err
+		}
+		field.SetBool(b)
+	default:
+		return fmt.Errorf("cannot assign string to %s", field.Type())
+	}
+	return nil
+}
@@ -0,0 +1,147 @@
+package sql
+
+import (
+	"context"
+	"math"
+	"strconv"
+	"testing"
+	"time"
+)
+
+const rowsTable = "ndid_rows_test"
+
+type rowsTestRow struct {
+	ID      string    `sql:"id"`
+	Count   int       `sql:"n"`
+	Ratio   *float64  `sql:"ratio"`
+	Created time.Time `sql:"created"`
+	Label   string
+	Secret  string `sql:"-"`
+	hidden  string
+}
+
+func openRowsDatabase(t *testing.T) *SqlDatabase {
+	sqldb := NewSqlDatabase(SqlLite, openE2EDatabase(t, e2eBackends[0]), DefaultStmtCacheSize)
+	for _, stmt := range []string{
+		"CREATE TABLE " + rowsTable + " (id TEXT, n INTEGER, ratio REAL, created TEXT, label TEXT, secret TEXT, hidden TEXT);",
+		"INSERT INTO " + rowsTable + " VALUES ('a', 1, 0.5, '2023-04-01 12:00:00.000000', 'first', 's', 'h');",
+		"INSERT INTO " + rowsTable + " VALUES ('b', 2, NULL, '2023-04-01T13:00:00Z', 'second', 's', 'h');",
+	} {
+		if _, err := sqldb.ConnPool.Exec(stmt); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return sqldb
+}
+
+func TestRowIterator(t *testing.T) {
+	sqldb := openRowsDatabase(t)
+	iter, err := sqldb.QueryRows(&SqlStmt{Stmt: "SELECT id, n, ratio FROM " + rowsTable + " ORDER BY id;"}, context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer iter.Close()
+
+	if row := iter.Row(); row != nil {
+		t.Fatalf("got row %v before Next", row)
+	}
+	var dest rowsTestRow
+	if err := iter.ScanStruct(&dest); err == nil {
+		t.Fatalf("scanned a struct before Next")
+	}
+	if cols := iter.Columns(); len(cols) != 3 || cols[0] != "id" || cols[2] != "ratio" {
+		t.Fatalf("got columns %v", cols)
+	}
+	var rows []map[string]interface{}
+	for iter.Next() {
+		rows = append(rows, iter.Row())
+	}
+	if err := iter.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 2 {
+		t.Fatalf("got %d rows, want 2", len(rows))
+	}
+	if rows[0]["id"] != "a" || rows[0]["n"] != int64(1) || rows[0]["ratio"] != 0.5 {
+		t.Fatalf("got first row %v", rows[0])
+	}
+	if rows[1]["ratio"] != nil {
+		t.Fatalf("got ratio %v for NULL", rows[1]["ratio"])
+	}
+	if iter.Next() {
+		t.Fatalf("advanced past the last row")
+	}
+}
+
+func TestQueryStructs(t *testing.T) {
+	sqldb := openRowsDatabase(t)
+	rows, err := QueryStructs[rowsTestRow](sqldb, &SqlStmt{Stmt: "SELECT * FROM " + rowsTable + " ORDER BY id;"}, context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 2 {
+		t.Fatalf("got %d rows, want 2", len(rows))
+	}
+	first, second := rows[0], rows[1]
+	if first.ID != "a" || first.Count != 1 || first.Label != "first" {
+		t.Fatalf("got first row %+v", first)
+	}
+	if first.Ratio == nil || *first.Ratio != 0.5 || second.Ratio != nil {
+		t.Fatalf("got ratios %v and %v, want 0.5 and nil", first.Ratio, second.Ratio)
+	}
+	if !first.Created.Equal(time.Date(2023, 4, 1, 12, 0, 0, 0, time.UTC)) ||
+		!second.Created.Equal(time.Date(2023, 4, 1, 13, 0, 0, 0, time.UTC)) {
+		t.Fatalf("got timestamps %v and %v", first.Created, second.Created)
+	}
+	if first.Secret != "" || first.hidden != "" {
+		t.Fatalf("filled ignored fields: %+v", first)
+	}
+
+	_, err = QueryStructs[struct {
+		Count time.Time `sql:"n"`
+	}](sqldb, &SqlStmt{Stmt: "SELECT n FROM " + rowsTable + ";"}, context.Background())
+	if err == nil {
+		t.Fatalf("decoded an integer into a time.Time")
+	}
+}
+
+func TestScanStructRejectsNonStruct(t *testing.T) {
+	sqldb := openRowsDatabase(t)
+	iter, err := sqldb.QueryRows(&SqlStmt{Stmt: "SELECT id FROM " + rowsTable + ";"}, context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer iter.Close()
+	if !iter.Next() {
+		t.Fatal(iter.Err())
+	}
+	var id string
+	if err := iter.ScanStruct(&id); err == nil {
+		t.Fatalf("scanned a row into a string")
+	}
+	var row *rowsTestRow
+	if err := iter.ScanStruct(row); err == nil {
+		t.Fatalf("scanned a row into a nil pointer")
+	}
+}
+
+func TestParseInteger(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    interface{}
+		wantErr bool
+	}{
+		{"42", int64(42), false},
+		{"-42", int64(-42), false},
+		{strconv.FormatInt(math.MaxInt64, 10), int64(math.MaxInt64), false},
+		{strconv.FormatUint(math.MaxUint64, 10), uint64(math.MaxUint64), false},
+		{"18446744073709551616", nil, true},
+		{"4.2", nil, true},
+	}
+	for _, test := range tests {
+		got, err := parseInteger(test.in)
+		if (err != nil) != test.wantErr || got != test.want {
+			t.Fatalf("parseInteger(%s) = %v, %v", test.in, got, err)
+		}
+	}
+}
@@ -8,55 +8,106 @@ import (
 
 // Generator for SQL SELECT Statement
 type SelectStmt struct {
-	Dialect        SqlDialect
+	Dialect        Dialect
 	ColumnsToQuery []string
 	Tables         []string
 	QueryCondition SqlQueryFunction
+
+	// Columns to sort the result by, each in its own direction
+	OrderBy []OrderByColumn
+
+	// Maximum number of rows to return, no limit if 0 or negative
+	Limit int
+
+	// Number of rows to skip
+	Offset int
+}
+
+// Column of an ORDER BY clause, along with the direction to sort it in
+type OrderByColumn struct {
+	Column     string
+	Descending bool
+}
+
+// Sort by the column in ascending order
+func Ascending(column string) OrderByColumn {
+	return OrderByColumn{Column: column}
+}
+
+// Sort by the column in descending order
+func Descending(column string) OrderByColumn {
+	return OrderByColumn{Column: column, Descending: true}
 }
 
 // Generate parameterized SELECT SQL statement with FROM clause and WHERE clause based on
 // the dialect provided, the tables to query from, as well as the condition while fethcing
-// the data.
+// the data. ORDER BY and pagination clauses are added if requested.
 func (stmt *SelectStmt) GenerateStmt() (res *SqlStmt, err error) {
 	var sb strings.Builder
 
 	sb.WriteString("SELECT ")
-	selectCluse, err := generateSelectClause(stmt.ColumnsToQuery)
+	selectCluse, err := generateSelectClause(stmt.Dialect, stmt.ColumnsToQuery)
 	if err != nil {
 		return &SqlStmt{}, err
 	}
 	sb.WriteString(selectCluse)
 
 	sb.WriteString("\nFROM ")
-	fromClause, err := generateFromClause(stmt.Tables)
+	fromClause, err := generateFromClause(stmt.Dialect, stmt.Tables)
 	if err != nil {
 		return &SqlStmt{}, err
 	}
 	sb.WriteString(fromClause)
 
-	if stmt.QueryCondition == nil {
-		return &SqlStmt{
-			Stmt:   sb.String(),
-			Params: nil,
-		}, nil
-	} else {
+	var params []string
+	if stmt.QueryCondition != nil {
 		// generate WHERE clause if query conditions are specified
 		sb.WriteString("\nWHERE ")
-		whereClause, params, err := stmt.QueryCondition.ToSQLParameterizedQuery(stmt.Dialect, 1)
+		whereClause, whereParams, err := stmt.QueryCondition.ToSQLParameterizedQuery(stmt.Dialect, 1)
 		if err != nil {
 			return &SqlStmt{}, err
 		}
 		sb.WriteString(whereClause)
-		sb.WriteString(";")
-		return &SqlStmt{
-			Stmt:   sb.String(),
-			Params: params,
-		}, nil
+		params = whereParams
+	}
+
+	if len(stmt.OrderBy) > 0 {
+		sb.WriteString("\nORDER BY ")
+		orderByClause, err := generateOrderByClause(stmt.Dialect, stmt.OrderBy)
+		if err != nil {
+			return &SqlStmt{}, err
+		}
+		sb.WriteString(orderByClause)
+	}
+
+	if stmt.Limit > 0 || stmt.Offset > 0 {
+		sb.WriteString("\n")
+		sb.WriteString(stmt.Dialect.Pagination(stmt.Limit, stmt.Offset, len(stmt.OrderBy) > 0))
+	}
+	sb.WriteString(";")
+	return &SqlStmt{
+		Stmt:   sb.String(),
+		Params: params,
+	}, nil
+}
+
+// Generate ORDER BY clause and validate each column sorted by
+func generateOrderByClause(dialect Dialect, columns []OrderByColumn) (string, error) {
+	clauses := make([]string, len(columns))
+	for i, col := range columns {
+		if !validateToken(col.Column) {
+			return "", fmt.Errorf("column validation failed")
+		}
+		clauses[i] = dialect.QuoteIdentifier(col.Column)
+		if col.Descending {
+			clauses[i] += " DESC"
+		}
 	}
+	return strings.Join(clauses, ", "), nil
 }
 
 // Generate SELECT clause and validate each columns selected
-func generateSelectClause(columns []string) (string, error) {
+func generateSelectClause(dialect Dialect, columns []string) (string, error) {
 	numOfCols := len(columns)
 	if numOfCols == 0 {
 		return "*", nil
@@ -66,7 +117,7 @@ func generateSelectClause(columns []string) (string, error) {
 		if !validateToken(s) {
 			return "", fmt.Errorf("column validation failed")
 		}
-		sb.WriteString(s)
+		sb.WriteString(dialect.QuoteIdentifier(s))
 		if i < numOfCols-1 {
 			sb.WriteString(", ")
 		}
@@ -75,7 +126,7 @@ func generateSelectClause(columns []string) (string, error) {
 }
 
 // Generate FROM clause and validate each table that will be queried from
-func generateFromClause(tables []string) (string, error) {
+func generateFromClause(dialect Dialect, tables []string) (string, error) {
 	numOfTables := len(tables)
 
 	var sb strings.Builder
@@ -87,7 +138,7 @@ func generateFromClause(tables []string) (string, error) {
 		if !validateToken(s) {
 			return "", fmt.Errorf("table validation failed")
 		}
-		sb.WriteString(s)
+		sb.WriteString(dialect.QuoteIdentifier(s))
 		if i < numOfTables-1 {
 			sb.WriteString(", ")
 		}
@@ -0,0 +1,162 @@
+package sql
+
+import (
+	"container/list"
+	"context"
+	dbsql "database/sql"
+	"strings"
+	"sync"
+)
+
+// Number of prepared statements kept by a SqlDatabase created with NewSqlDatabase
+// unless specified otherwise
+const DefaultStmtCacheSize = 128
+
+// Counters of the prepared statement cache of a SqlDatabase
+type StmtCacheStats struct {
+	// Number of statements served from the cache
+	Hits uint64
+
+	// Number of statements that had to be prepared
+	Misses uint64
+
+	// Number of statements closed to make room for new ones
+	Evictions uint64
+
+	// Number of statements currently cached
+	Size int
+}
+
+// Bounded LRU cache of prepared statements keyed by the SQL text
+type stmtCache struct {
+	mu       sync.Mutex
+	capacity int
+	entries  map[string]*list.Element
+	lru      *list.List
+	stats    StmtCacheStats
+}
+
+// Prepared statement held by the cache. A statement evicted while still in use is closed
+// once the last user releases it.
+type cachedStmt struct {
+	query   string
+	stmt    *dbsql.Stmt
+	refs    int
+	evicted bool
+}
+
+func newStmtCache(capacity int) *stmtCache {
+	return &stmtCache{
+		capacity: capacity,
+		entries:  make(map[string]*list.Element),
+		lru:      list.New(),
+	}
+}
+
+// Get the prepared statement for query, preparing it on conn if it is not cached yet.
+// The statement must be given back with release once the caller is done with it.
+func (cache *stmtCache) acquire(ctx context.Context, conn *dbsql.DB, query string) (*cachedStmt, error) {
+	cache.mu.Lock()
+	if elem, ok := cache.entries[query]; ok {
+		cache.stats.Hits += 1
+		return cache.use(elem), nil
+	}
+	cache.stats.Misses += 1
+	cache.mu.Unlock()
+
+	// prepare without holding the lock so that other statements are not blocked
+	stmt, err := conn.PrepareContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+
+	cache.mu.Lock()
+	if elem, ok := cache.entries[query]; ok {
+		// prepared concurrently by another caller
+		stmt.Close()
+		return cache.use(elem), nil
+	}
+	entry := &cachedStmt{query: query, stmt: stmt}
+	cache.entries[query] = cache.lru.PushFront(entry)
+	for cache.lru.Len() > cache.capacity {
+		cache.evict(cache.lru.Back())
+	}
+	return cache.use(cache.entries[query]), nil
+}
+
+// Report whether query is cached, without taking a reference on its statement
+func (cache *stmtCache) contains(query string) bool {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+	_, ok := cache.entries[query]
+	return ok
+}
+
+func (cache *stmtCache) recordMiss() {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+	cache.stats.Misses += 1
+}
+
+// Mark the entry as most recently used and take a reference on it. Must be called with the
+// lock held, which is released before returning.
+func (cache *stmtCache) use(elem *list.Element) *cachedStmt {
+	defer cache.mu.Unlock()
+	cache.lru.MoveToFront(elem)
+	entry := elem.Value.(*cachedStmt)
+	entry.refs += 1
+	return entry
+}
+
+// Give back a statement obtained from acquire
+func (cache *stmtCache) release(entry *cachedStmt) {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+	entry.refs -= 1
+	if entry.evicted && entry.refs == 0 {
+		entry.stmt.Close()
+	}
+}
+
+// Remove an entry from the cache. Must be called with the lock held.
+func (cache *stmtCache) evict(elem *list.Element) {
+	entry := elem.Value.(*cachedStmt)
+	cache.lru.Remove(elem)
+	delete(cache.entries, entry.query)
+	cache.stats.Evictions += 1
+	entry.evicted = true
+	if entry.refs == 0 {
+		entry.stmt.Close()
+	}
+}
+
+// Evict every statement from the cache
+func (cache *stmtCache) clear() {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+	for cache.lru.Len() > 0 {
+		cache.evict(cache.lru.Back())
+	}
+}
+
+func (cache *stmtCache) snapshot() StmtCacheStats {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+	stats := cache.stats
+	stats.Size = cache.lru.Len()
+	return stats
+}
+
+// Keywords starting the DDL statements, which are not cached. IF starts the conditional
+// DDL statements of MS SQL Server (see SqlServerDialect.CreateTableIfNotExists).
+var ddlKeywords = []string{"CREATE", "ALTER", "DROP", "TRUNCATE", "IF"}
+
+func isDDLStmt(query string) bool {
+	query = strings.ToUpper(strings.TrimSpace(query))
+	for _, keyword := range ddlKeywords {
+		if strings.HasPrefix(query, keyword) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,54 @@
+package sql
+
+import (
+	"context"
+	dbsql "database/sql"
+	"testing"
+)
+
+func TestStmtCacheSharedWithTransactions(t *testing.T) {
+	sqldb := NewSqlDatabase(SqlLite, openE2EDatabase(t, e2eBackends[0]), DefaultStmtCacheSize)
+	resetE2ETable(t, sqldb)
+	if size := sqldb.StmtCacheStats().Size; size != 1 {
+		t.Fatalf("cached %d statements, want only the insert and not the CREATE TABLE", size)
+	}
+
+	ctx := context.Background()
+	err := sqldb.WithTransaction(ctx, dbsql.LevelDefault, func(ctx context.Context, tx *TransactionManager) error {
+		selectAllE2EIDs(t, sqldb, ctx)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	stats := sqldb.StmtCacheStats()
+	if stats.Size != 2 || stats.Misses != 2 {
+		t.Fatalf("statement missed inside the transaction was not cached: %+v", stats)
+	}
+
+	assertIDs(t, selectAllE2EIDs(t, sqldb, ctx), []string{"a", "b", "c"})
+	if hits := sqldb.StmtCacheStats().Hits; hits != stats.Hits+1 {
+		t.Fatalf("got %d hits, want %d", hits, stats.Hits+1)
+	}
+}
+
+func TestStmtCacheWithSingleConnection(t *testing.T) {
+	conn := openE2EDatabase(t, e2eBackends[0])
+	conn.SetMaxOpenConns(1)
+	sqldb := NewSqlDatabase(SqlLite, conn, DefaultStmtCacheSize)
+	resetE2ETable(t, sqldb)
+
+	// the only connection is held by the transaction, so the statement cannot be prepared
+	// on the pool
+	ctx := context.Background()
+	err := sqldb.WithTransaction(ctx, dbsql.LevelDefault, func(ctx context.Context, tx *TransactionManager) error {
+		assertIDs(t, selectAllE2EIDs(t, sqldb, ctx), []string{"a", "b", "c"})
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if size := sqldb.StmtCacheStats().Size; size != 1 {
+		t.Fatalf("cached %d statements, want 1", size)
+	}
+}
@@ -0,0 +1,200 @@
+package sql
+
+import (
+	"context"
+	dbsql "database/sql"
+	"errors"
+	"fmt"
+	"math/rand"
+	"strings"
+	"time"
+)
+
+const (
+	defaultTxMaxRetries = 3
+	defaultTxMinBackoff = 10 * time.Millisecond
+	defaultTxMaxBackoff = time.Second
+)
+
+// Function run by WithTransaction. ctx carries the transaction, so any SqlDatabase call made
+// with it (including from repositories) runs inside the same transaction.
+type TxFunc func(ctx context.Context, tx *TransactionManager) error
+
+// Policy deciding whether and how often WithTransaction retries a failed transaction.
+// Zero values fall back to the defaults.
+type RetryPolicy struct {
+	// Number of retries after the first attempt. Set to a negative value to disable retries.
+	MaxRetries int
+
+	// Backoff before the first retry, doubled for each subsequent retry
+	MinBackoff time.Duration
+
+	// Upper bound of the backoff between two retries
+	MaxBackoff time.Duration
+
+	// Report whether an error is transient. Default to IsRetryableTxError.
+	IsRetryable func(error) bool
+}
+
+type txContextKey struct{}
+
+// Run fn inside a transaction with the given isolation level. The transaction is committed
+// if fn returns nil, and rolled back if fn returns an error or panics. Transactions aborted
+// by a serialization failure or a deadlock are retried with exponential backoff according
+// to TxRetryPolicy, so fn may be called more than once and must not have side effects
+// outside of the database.
+//
+// If ctx already carries a transaction, fn runs inside a savepoint of that transaction
+// instead (see TransactionManager.WithTransaction), which allows operations to compose
+// inside larger ones.
+func (sqldb *SqlDatabase) WithTransaction(ctx context.Context, isolation dbsql.IsolationLevel, fn TxFunc) error {
+	if txManager := transactionFromContext(ctx); txManager != nil {
+		return txManager.WithTransaction(ctx, isolation, fn)
+	}
+	policy := sqldb.TxRetryPolicy.withDefaults()
+	for attempt := 0; ; attempt++ {
+		err := sqldb.runTransaction(ctx, isolation, fn)
+		if err == nil || attempt >= policy.MaxRetries || !policy.IsRetryable(err) {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(policy.backoff(attempt)):
+		}
+	}
+}
+
+// Run a single attempt of fn inside a new transaction
+func (sqldb *SqlDatabase) runTransaction(ctx context.Context, isolation dbsql.IsolationLevel, fn TxFunc) error {
+	txManager, err := sqldb.startTransaction(ctx, &dbsql.TxOptions{Isolation: isolation})
+	if err != nil {
+		return err
+	}
+	txManager.managed = true
+	defer func() {
+		if p := recover(); p != nil {
+			_ = txManager.Rollback()
+			panic(p)
+		}
+	}()
+
+	if err := fn(context.WithValue(ctx, txContextKey{}, txManager), txManager); err != nil {
+		if rollbackErr := txManager.Rollback(); rollbackErr != nil && !errors.Is(rollbackErr, dbsql.ErrTxDone) {
+			return fmt.Errorf("%w (rollback failed: %v)", err, rollbackErr)
+		}
+		return err
+	}
+	return txManager.Commit()
+}
+
+// Run fn inside a savepoint of the transaction. The savepoint is released if fn returns nil,
+// and rolled back if fn returns an error or panics, leaving the rest of the transaction
+// intact. isolation is ignored since it cannot be changed once a transaction has started.
+// While the savepoint is open, a failing statement no longer rolls back a transaction started
+// by hand, since that would discard the savepoint along with the rest of the transaction.
+func (txManager *TransactionManager) WithTransaction(ctx context.Context, isolation dbsql.IsolationLevel, fn TxFunc) (err error) {
+	txManager.savepointDepth += 1
+	managed := txManager.managed
+	txManager.managed = true
+	defer func() {
+		txManager.savepointDepth -= 1
+		txManager.managed = managed
+	}()
+	savepoint := fmt.Sprintf("ndidsavepoint%d", txManager.savepointDepth)
+
+	createStmt, rollbackStmt, releaseStmt := savepointStmts(txManager.db.Dialect, savepoint)
+	if _, err := txManager.Transaction.ExecContext(ctx, createStmt); err != nil {
+		return err
+	}
+	defer func() {
+		if p := recover(); p != nil {
+			_, _ = txManager.Transaction.ExecContext(ctx, rollbackStmt)
+			panic(p)
+		}
+	}()
+
+	if err := fn(context.WithValue(ctx, txContextKey{}, txManager), txManager); err != nil {
+		if _, rollbackErr := txManager.Transaction.ExecContext(ctx, rollbackStmt); rollbackErr != nil {
+			return fmt.Errorf("%w (rollback to savepoint failed: %v)", err, rollbackErr)
+		}
+		return err
+	}
+	if releaseStmt != "" {
+		_, err = txManager.Transaction.ExecContext(ctx, releaseStmt)
+	}
+	return err
+}
+
+// Report whether ctx carries a transaction started by WithTransaction, in which case the
+// statements run with it are only committed along with that transaction
+func InTransaction(ctx context.Context) bool {
+	return transactionFromContext(ctx) != nil
+}
+
+// Return the transaction started by WithTransaction that ctx carries, or nil
+func transactionFromContext(ctx context.Context) *TransactionManager {
+	txManager, _ := ctx.Value(txContextKey{}).(*TransactionManager)
+	return txManager
+}
+
+// Report whether err was caused by a serialization failure or a deadlock, in which case the
+// transaction can safely be retried. Errors exposing a SQLSTATE (e.g. from pgx) are checked
+// for codes 40001 and 40P01; other drivers are recognized by their error messages.
+func IsRetryableTxError(err error) bool {
+	if err == nil {
+		return false
+	}
+	var stateErr interface{ SQLState() string }
+	if errors.As(err, &stateErr) {
+		code := stateErr.SQLState()
+		return code == "40001" || code == "40P01"
+	}
+	msg := strings.ToLower(err.Error())
+	for _, retryableMsg := range retryableTxErrorMessages {
+		if strings.Contains(msg, retryableMsg) {
+			return true
+		}
+	}
+	return false
+}
+
+// Fragments of the error messages reported by the supported databases when a transaction
+// is aborted by a serialization failure, a deadlock or a locked database
+var retryableTxErrorMessages = []string{
+	"could not serialize access",
+	"deadlock",
+	"try restarting transaction",
+	"database is locked",
+	"sqlstate 40001",
+	"sqlstate 40p01",
+}
+
+func (policy RetryPolicy) withDefaults() RetryPolicy {
+	if policy.MaxRetries == 0 {
+		policy.MaxRetries = defaultTxMaxRetries
+	}
+	if policy.MinBackoff <= 0 {
+		policy.MinBackoff = defaultTxMinBackoff
+	}
+	if policy.MaxBackoff <= 0 {
+		policy.MaxBackoff = defaultTxMaxBackoff
+	}
+	if policy.IsRetryable == nil {
+		policy.IsRetryable = IsRetryableTxError
+	}
+	return policy
+}
+
+// Compute the backoff before the given retry, with jitter so that concurrent transactions
+// that conflicted with each other do not collide again
+func (policy RetryPolicy) backoff(attempt int) time.Duration {
+	backoff := policy.MinBackoff
+	for i := 0; i < attempt && backoff < policy.MaxBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > policy.MaxBackoff {
+		backoff = policy.MaxBackoff
+	}
+	return backoff/2 + time.Duration(rand.Int63n(int64(backoff/2)+1))
+}
@@ -0,0 +1,248 @@
+package sql
+
+import (
+	"context"
+	dbsql "database/sql"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+var errTestRetryable = errors.New("retryable")
+
+// Insert a row with the given id, returning the error instead of failing the test
+func tryInsertE2ERow(t *testing.T, executor SqlExecutor, id string, ctx context.Context) error {
+	t.Helper()
+	stmt, err := (&InsertStmt{
+		Dialect: SqlLite,
+		Table:   e2eTable,
+		Columns: []string{"id", "name"},
+		Values:  []string{id, "name of " + id},
+	}).GenerateStmt()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = executor.ExecuteSQL(stmt, ctx)
+	return err
+}
+
+func newTransactionTestDatabase(t *testing.T) *SqlDatabase {
+	sqldb := NewSqlDatabase(SqlLite, openE2EDatabase(t, e2eBackends[0]), DefaultStmtCacheSize)
+	resetE2ETable(t, sqldb)
+	return sqldb
+}
+
+// A statement failing inside a savepoint of a transaction started by hand only rolls back
+// the savepoint
+func TestSavepointOfUnmanagedTransaction(t *testing.T) {
+	sqldb := newTransactionTestDatabase(t)
+	ctx := context.Background()
+	tx, err := sqldb.StartTransaction(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := tryInsertE2ERow(t, tx, "d", ctx); err != nil {
+		t.Fatal(err)
+	}
+	err = tx.WithTransaction(ctx, dbsql.LevelDefault, func(ctx context.Context, tx *TransactionManager) error {
+		if err := tryInsertE2ERow(t, tx, "e", ctx); err != nil {
+			return err
+		}
+		// duplicate primary key
+		return tryInsertE2ERow(t, tx, "a", ctx)
+	})
+	if err == nil {
+		t.Fatalf("inserted a duplicate row")
+	}
+	if tx.managed {
+		t.Fatalf("transaction is still marked as managed after the savepoint")
+	}
+	if err := tryInsertE2ERow(t, tx, "f", ctx); err != nil {
+		t.Fatalf("transaction did not survive the savepoint: %v", err)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	assertIDs(t, selectAllE2EIDs(t, sqldb, ctx), []string{"a", "b", "c", "d", "f"})
+}
+
+func TestNestedSavepoints(t *testing.T) {
+	sqldb := newTransactionTestDatabase(t)
+	ctx := context.Background()
+	err := sqldb.WithTransaction(ctx, dbsql.LevelDefault, func(ctx context.Context, tx *TransactionManager) error {
+		if err := tryInsertE2ERow(t, sqldb, "d", ctx); err != nil {
+			return err
+		}
+		return sqldb.WithTransaction(ctx, dbsql.LevelDefault, func(ctx context.Context, tx *TransactionManager) error {
+			if err := tryInsertE2ERow(t, sqldb, "e", ctx); err != nil {
+				return err
+			}
+			err := sqldb.WithTransaction(ctx, dbsql.LevelDefault, func(ctx context.Context, tx *TransactionManager) error {
+				if err := tryInsertE2ERow(t, sqldb, "f", ctx); err != nil {
+					return err
+				}
+				return errors.New("discard f")
+			})
+			if err == nil {
+				t.Errorf("innermost savepoint did not fail")
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertIDs(t, selectAllE2EIDs(t, sqldb, ctx), []string{"a", "b", "c", "d", "e"})
+}
+
+func TestRollbackOnPanic(t *testing.T) {
+	sqldb := newTransactionTestDatabase(t)
+	ctx := context.Background()
+	recovered := func(fn func()) (p interface{}) {
+		defer func() { p = recover() }()
+		fn()
+		return nil
+	}
+
+	p := recovered(func() {
+		_ = sqldb.WithTransaction(ctx, dbsql.LevelDefault, func(ctx context.Context, tx *TransactionManager) error {
+			if err := tryInsertE2ERow(t, sqldb, "d", ctx); err != nil {
+				return err
+			}
+			panic("transaction")
+		})
+	})
+	if p != "transaction" {
+		t.Fatalf("got panic %v", p)
+	}
+	assertIDs(t, selectAllE2EIDs(t, sqldb, ctx), []string{"a", "b", "c"})
+
+	err := sqldb.WithTransaction(ctx, dbsql.LevelDefault, func(ctx context.Context, tx *TransactionManager) error {
+		if err := tryInsertE2ERow(t, sqldb, "e", ctx); err != nil {
+			return err
+		}
+		p := recovered(func() {
+			_ = sqldb.WithTransaction(ctx, dbsql.LevelDefault, func(ctx context.Context, tx *TransactionManager) error {
+				if err := tryInsertE2ERow(t, sqldb, "f", ctx); err != nil {
+					return err
+				}
+				panic("savepoint")
+			})
+		})
+		if p != "savepoint" {
+			t.Errorf("got panic %v", p)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertIDs(t, selectAllE2EIDs(t, sqldb, ctx), []string{"a", "b", "c", "e"})
+}
+
+func TestWithTransactionRetries(t *testing.T) {
+	tests := []struct {
+		name         string
+		maxRetries   int
+		err          error
+		failAttempts int
+		wantAttempts int
+		wantErr      bool
+	}{
+		{"Succeeds", 2, nil, 0, 1, false},
+		{"RetriedUntilSuccess", 2, errTestRetryable, 2, 3, false},
+		{"RetriesExhausted", 2, errTestRetryable, 5, 3, true},
+		{"NotRetryable", 2, errors.New("permanent"), 5, 1, true},
+		{"RetriesDisabled", -1, errTestRetryable, 5, 1, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			sqldb := newTransactionTestDatabase(t)
+			sqldb.TxRetryPolicy = RetryPolicy{
+				MaxRetries:  test.maxRetries,
+				MinBackoff:  time.Millisecond,
+				MaxBackoff:  2 * time.Millisecond,
+				IsRetryable: func(err error) bool { return errors.Is(err, errTestRetryable) },
+			}
+			ctx := context.Background()
+			attempts := 0
+			err := sqldb.WithTransaction(ctx, dbsql.LevelDefault, func(ctx context.Context, tx *TransactionManager) error {
+				attempts += 1
+				if err := tryInsertE2ERow(t, sqldb, fmt.Sprintf("z%d", attempts), ctx); err != nil {
+					return err
+				}
+				if attempts <= test.failAttempts {
+					return test.err
+				}
+				return nil
+			})
+			if (err != nil) != test.wantErr || attempts != test.wantAttempts {
+				t.Fatalf("got %v after %d attempts", err, attempts)
+			}
+			// only the rows of the successful attempt are kept
+			want := []string{"a", "b", "c"}
+			if !test.wantErr {
+				want = append(want, fmt.Sprintf("z%d", attempts))
+			}
+			assertIDs(t, selectAllE2EIDs(t, sqldb, ctx), want)
+		})
+	}
+}
+
+func TestWithTransactionStopsRetryingWhenCancelled(t *testing.T) {
+	sqldb := newTransactionTestDatabase(t)
+	sqldb.TxRetryPolicy = RetryPolicy{
+		MinBackoff:  time.Hour,
+		MaxBackoff:  time.Hour,
+		IsRetryable: func(err error) bool { return true },
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	err := sqldb.WithTransaction(ctx, dbsql.LevelDefault, func(ctx context.Context, tx *TransactionManager) error {
+		cancel()
+		return errTestRetryable
+	})
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("got %v, want context.Canceled", err)
+	}
+}
+
+type sqlStateError string
+
+func (err sqlStateError) Error() string    { return "sql error" }
+func (err sqlStateError) SQLState() string { return string(err) }
+
+func TestIsRetryableTxError(t *testing.T) {
+	tests := []struct {
+		err  error
+		want bool
+	}{
+		{nil, false},
+		{errors.New("syntax error"), false},
+		{sqlStateError("40001"), true},
+		{sqlStateError("40P01"), true},
+		{sqlStateError("23505"), false},
+		{fmt.Errorf("insert: %w", sqlStateError("40001")), true},
+		{errors.New("pq: could not serialize access due to concurrent update"), true},
+		{errors.New("Error 1213: Deadlock found when trying to get lock; try restarting transaction"), true},
+		{errors.New("database is locked"), true},
+		{errors.New("ERROR: deadlock detected (SQLSTATE 40P01)"), true},
+	}
+	for _, test := range tests {
+		if got := IsRetryableTxError(test.err); got != test.want {
+			t.Fatalf("IsRetryableTxError(%v) = %v, want %v", test.err, got, test.want)
+		}
+	}
+}
+
+func TestRetryBackoff(t *testing.T) {
+	policy := RetryPolicy{MinBackoff: 10 * time.Millisecond, MaxBackoff: 50 * time.Millisecond}.withDefaults()
+	for attempt, max := range []time.Duration{10, 20, 40, 50, 50} {
+		max *= time.Millisecond
+		for i := 0; i < 20; i++ {
+			if backoff := policy.backoff(attempt); backoff < max/2 || backoff > max {
+				t.Fatalf("backoff %v before retry %d is not within [%v, %v]", backoff, attempt, max/2, max)
+			}
+		}
+	}
+}
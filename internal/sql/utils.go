@@ -1,21 +1,11 @@
 package sql
 
-import (
-	"errors"
-	"fmt"
-)
+import "strings"
 
-// Contains a list of valid SQL dialect supported by the sql generator
-type SqlDialect int64
-
-// SQL dialects currently supported are PostgreSQL, MySQL, SQLite
-// and MS SQL Server
-const (
-	Psql SqlDialect = iota
-	MySql
-	SqlLite
-	SqlServer
-)
+// Format of the timestamps passed as parameters, e.g. by SQLBefore and SQLAfter. Timestamps
+// are expected to be in UTC, and have a fixed width so that they can also be compared as
+// text by dialects without a native timestamp type.
+const SqlDateTimeLayout = "2006-01-02 15:04:05.000000"
 
 // Result returned by the SQL generators ready to be passed in for the SQL driver.
 // The struct contains a parameterized SQL statement and parameters.
@@ -24,38 +14,19 @@ type SqlStmt struct {
 	Params []string
 }
 
-// Validate if the given token contains only alphabet or number. Implemented to mitigate
-// SQL injection attack in parameterized query.
+// Validate if the given token contains only alphabet, number or underscore, optionally
+// split into several parts by dots, as in the columns storing nested fields (e.g.
+// "epoch.t0"). Implemented to mitigate SQL injection attack in parameterized query. Since
+// identifiers are quoted, a dotted token names a single column rather than a table and one
+// of its columns.
 func validateToken(token string) bool {
-	if token == "" {
+	if token == "" || strings.HasPrefix(token, ".") || strings.HasSuffix(token, ".") || strings.Contains(token, "..") {
 		return false
 	}
 	for _, r := range token {
-		if (r < 'a' || r > 'z') && (r < 'A' || r > 'Z') && (r < '0' || r > '9') {
+		if (r < 'a' || r > 'z') && (r < 'A' || r > 'Z') && (r < '0' || r > '9') && r != '_' && r != '.' {
 			return false
 		}
 	}
 	return true
 }
-
-// Get placeholders in the parameterized SQL statement for the given dialect.
-// Different dialect uses different placeholder. PostgreSQL uses $1...$N; SQLLite
-// uses ? and MS SQL Server uses %1...%N.
-func getPlaceholderForSqlDialect(dialect SqlDialect, index int) (string, error) {
-	switch dialect {
-	case Psql:
-		if index <= 0 {
-			return "", errors.New("index has to be greater than 0")
-		}
-		return fmt.Sprintf("$%d", index), nil
-	case MySql, SqlLite:
-		return "?", nil
-	case SqlServer:
-		if index <= 0 {
-			return "", errors.New("index has to be greater than 0")
-		}
-		return fmt.Sprintf("@p%d", index), nil
-	default:
-		return "", errors.New("unknown dialect or dialect not supported")
-	}
-}
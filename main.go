@@ -1,47 +1,159 @@
 package main
 
 import (
+	"context"
+	dbsql "database/sql"
+	"errors"
+	"flag"
 	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
+	_ "github.com/go-sql-driver/mysql"
+	_ "github.com/lib/pq"
+	_ "github.com/mattn/go-sqlite3"
+	_ "github.com/microsoft/go-mssqldb"
+	"github.com/zhaoy17/ndid/internal/api"
+	"github.com/zhaoy17/ndid/internal/blob"
+	"github.com/zhaoy17/ndid/internal/document"
+	"github.com/zhaoy17/ndid/internal/schema"
 	sql "github.com/zhaoy17/ndid/internal/sql"
 )
 
+// database/sql driver of each dialect
+var driverNames = map[string]string{
+	"postgres":  "postgres",
+	"mysql":     "mysql",
+	"sqlite":    "sqlite3",
+	"sqlserver": "sqlserver",
+}
+
+type config struct {
+	addr string
+
+	dialect string
+	dsn     string
+
+	blobDir    string
+	s3         blob.S3Config
+	uploadDir  string
+	uploadTTL  time.Duration
+	blobGrace  time.Duration
+	sweepEvery time.Duration
+}
+
 func main() {
-	s := &sql.SelectStmt{
-		Dialect:        sql.Psql,
-		ColumnsToQuery: []string{"id", "name", "author"},
-		Tables:         []string{"books", "john"},
-		QueryCondition: sql.SQLOr(
-			sql.SQLEqual("", "id", "1659"),
-			sql.SQLColumnEqual("joe", "id", "books", "id"),
-			sql.SQLAnd(
-				sql.SQLEqual("book", "idb", "1234"),
-				sql.SQLOr(
-					sql.SQLLessThan("", "joe", 123.345),
-					sql.SQLGreaterThan("", "kate", 145),
-				),
-				sql.SQLEqual("bookid", "abc", "123456"),
-			),
-		),
-	}
-	res, err := s.GenerateStmt()
-	//res, err := ct.GenerateStmt()
+	cfg := &config{}
+	flag.StringVar(&cfg.addr, "addr", ":8080", "address to listen on")
+	flag.StringVar(&cfg.dialect, "dialect", "sqlite", "SQL dialect of the database: postgres, mysql, sqlite or sqlserver")
+	flag.StringVar(&cfg.dsn, "dsn", "ndid.db", "data source name of the database, as expected by its driver")
+	flag.StringVar(&cfg.blobDir, "blob-dir", "blobs", "directory the content of the files is stored in, unless -s3-bucket is set")
+	flag.StringVar(&cfg.s3.Endpoint, "s3-endpoint", "https://s3.amazonaws.com", "URL of the S3 service")
+	flag.StringVar(&cfg.s3.Region, "s3-region", "us-east-1", "region of the S3 bucket")
+	flag.StringVar(&cfg.s3.Bucket, "s3-bucket", "", "S3 bucket the content of the files is stored in, with the credentials from AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY and AWS_SESSION_TOKEN")
+	flag.StringVar(&cfg.s3.Prefix, "s3-prefix", "", "prefix of the names of the objects in the S3 bucket")
+	flag.BoolVar(&cfg.s3.PathStyle, "s3-path-style", false, "address the S3 bucket in the path of the URLs, as needed by MinIO")
+	flag.StringVar(&cfg.uploadDir, "upload-dir", "uploads", "directory uploads are staged in until they are complete")
+	flag.DurationVar(&cfg.uploadTTL, "upload-ttl", 24*time.Hour, "time after which unfinished uploads are removed")
+	flag.DurationVar(&cfg.blobGrace, "blob-grace-period", time.Hour, "time after which stored content no document or version refers to is removed")
+	flag.DurationVar(&cfg.sweepEvery, "sweep-interval", 10*time.Minute, "interval between removals of expired uploads and unreferenced content")
+	flag.Parse()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	if err := run(cfg, ctx); err != nil {
+		slog.Error("server failed", slog.Any("error", err))
+		os.Exit(1)
+	}
+}
+
+// Set up the database and the stores, and serve the API until ctx is done
+func run(cfg *config, ctx context.Context) error {
+	dialect, err := sql.GetDialect(cfg.dialect)
 	if err != nil {
-		fmt.Printf("%s\n", err.Error())
+		return err
+	}
+	driverName, ok := driverNames[cfg.dialect]
+	if !ok {
+		return fmt.Errorf("no driver available for dialect %s", cfg.dialect)
+	}
+	conn, err := dbsql.Open(driverName, cfg.dsn)
+	if err != nil {
+		return err
+	}
+	db := sql.NewSqlDatabase(dialect, conn, sql.DefaultStmtCacheSize)
+	defer db.Close()
+
+	var blobs blob.BlobStore
+	if cfg.s3.Bucket != "" {
+		cfg.s3.AccessKeyID = os.Getenv("AWS_ACCESS_KEY_ID")
+		cfg.s3.SecretAccessKey = os.Getenv("AWS_SECRET_ACCESS_KEY")
+		cfg.s3.SessionToken = os.Getenv("AWS_SESSION_TOKEN")
+		blobs, err = blob.NewS3BlobStore(cfg.s3)
 	} else {
-		fmt.Printf("%s\n", res.Stmt)
-		fmt.Printf("%s\n", res.Params)
-	}
-	/**
-	ct := &sql.CreateTableStmt{
-		Dialect: sql.Psql,
-		TableSchema: sql.TableSchema{
-			TableName: "exampletable",
-			Columns: map[string]sql.SqlDataType{
-				"col1": &sql.SqlInteger{NotNull: true},
-				"col2": &sql.SqlFloat{NotNull: false},
-			},
-		},
-	}
-	**/
+		blobs, err = blob.NewLocalBlobStore(cfg.blobDir)
+	}
+	if err != nil {
+		return err
+	}
+	uploads, err := api.NewUploadSessions(cfg.uploadDir)
+	if err != nil {
+		return err
+	}
+
+	schemas := schema.NewSQLSchemaRepository(db)
+	if err := schemas.Setup(ctx); err != nil {
+		return fmt.Errorf("setting up the schemas: %w", err)
+	}
+	documents := document.NewSQLDocumentRepository(db, schemas.GetSchema, blobs)
+	if err := documents.Setup(ctx); err != nil {
+		return fmt.Errorf("setting up the documents: %w", err)
+	}
+
+	files := api.NewFileHandler(documents, schemas.GetSchema, uploads)
+	mux := http.NewServeMux()
+	mux.Handle("/documents/", files)
+	mux.Handle("/uploads/", files)
+	server := &http.Server{Addr: cfg.addr, Handler: mux}
+
+	go sweep(uploads, documents, cfg, ctx)
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+		server.Shutdown(shutdownCtx)
+	}()
+	slog.Info("listening", slog.String("addr", cfg.addr))
+	if err := server.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+// Remove the uploads that were abandoned and the content nothing refers to, every
+// sweepEvery until ctx is done
+func sweep(uploads *api.UploadSessions, documents *document.SQLDocumentRepository, cfg *config, ctx context.Context) {
+	ticker := time.NewTicker(cfg.sweepEvery)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if removed, err := uploads.RemoveExpired(cfg.uploadTTL); err != nil {
+			slog.Error("removing expired uploads", slog.Any("error", err))
+		} else if len(removed) > 0 {
+			slog.Info("removed expired uploads", slog.Any("uploads", removed))
+		}
+		if collected, err := documents.CollectBlobs(cfg.blobGrace, ctx); err != nil {
+			slog.Error("collecting unreferenced blobs", slog.Any("error", err))
+		} else if len(collected) > 0 {
+			slog.Info("collected unreferenced blobs", slog.Any("blobs", collected))
+		}
+	}
 }